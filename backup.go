@@ -0,0 +1,287 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// backupPagesPerStep is how many pages are copied between two checks of
+// the context, small enough to not hold the source lock for long
+const backupPagesPerStep = 128
+
+// Backup copies the live database into the file at destPath using SQLite's
+// online backup API, while other connections keep reading and writing.
+// Parent directories are created like WithFile does. The copy is done a few
+// pages at a time and stops with ctx.Err() if ctx is done in between.
+func (db *Database) Backup(ctx context.Context, destPath string) (err error) {
+	err = os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Done()
+
+	dest, err := sqlite.OpenConn(destPath, sqlite.OpenReadWrite|sqlite.OpenCreate)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := dest.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	backup, err := sqlite.NewBackup(dest, "main", conn.conn, "main")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := backup.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		more, err := backup.Step(backupPagesPerStep)
+		if err != nil {
+			if !more {
+				return err
+			}
+
+			// the source is busy or locked, give the writer some room
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		if !more {
+			return nil
+		}
+	}
+}
+
+// ErrVacuumInTx is returned by Vacuum and VacuumInto when they are called
+// from inside a Save scope, SQLite refuses to VACUUM within a transaction
+var ErrVacuumInTx = errors.New("database cannot vacuum within a transaction")
+
+// Vacuum rebuilds the database file, repacking it into the minimal amount of
+// disk space. It must not be called from within a Save scope.
+func (db *Database) Vacuum(ctx context.Context) error {
+	return db.vacuum(ctx, `VACUUM;`)
+}
+
+// VacuumInto writes a compacted copy of the database to path, which must not
+// exist yet. It's a simpler alternative to Backup for cold copies and like
+// Vacuum, it must not be called from within a Save scope.
+func (db *Database) VacuumInto(ctx context.Context, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return db.vacuum(ctx, `VACUUM INTO ?;`, path)
+}
+
+func (db *Database) vacuum(ctx context.Context, sql string, values ...any) error {
+	// a connection passed down through the context might be in the middle of
+	// a transaction, running VACUUM next to it on another connection would
+	// only wait on its lock, so refuse early instead
+	if conn, ok := ConnFromContext(ctx); ok && conn.db == db && !conn.conn.AutocommitEnabled() {
+		return ErrVacuumInTx
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Done()
+
+	if !conn.conn.AutocommitEnabled() {
+		return ErrVacuumInTx
+	}
+
+	return conn.Exec(ctx, sql, values...)
+}
+
+// Optimize runs PRAGMA optimize, which lets SQLite refresh the statistics of
+// the query planner, with ANALYZE, for the tables whose queries would benefit.
+// It's cheap when there is nothing to do. See WithOptimizeOnClose to run it
+// when the database is closed.
+func (db *Database) Optimize(ctx context.Context) error {
+	return db.Exec(ctx, func(ctx context.Context, conn *Conn) error {
+		return conn.Exec(ctx, `PRAGMA optimize;`)
+	})
+}
+
+// IntegrityCheck runs PRAGMA integrity_check and PRAGMA foreign_key_check and
+// returns every problem they report, one string per problem. An empty slice
+// means the database is healthy.
+func (db *Database) IntegrityCheck(ctx context.Context) ([]string, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Done()
+
+	problems := []string{}
+
+	for stmt, err := range conn.Query(ctx, `PRAGMA integrity_check;`) {
+		if err != nil {
+			return nil, err
+		}
+
+		if msg := stmt.ColumnText(0); msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+
+	for stmt, err := range conn.Query(ctx, `PRAGMA foreign_key_check;`) {
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, fmt.Sprintf(
+			"foreign key %d of %s row %d references a missing row in %s",
+			stmt.ColumnInt64(3), stmt.ColumnText(0), stmt.ColumnInt64(1), stmt.ColumnText(2),
+		))
+	}
+
+	return problems, nil
+}
+
+// CheckpointMode selects how much work a WAL checkpoint does, see Checkpoint
+type CheckpointMode int
+
+const (
+	CheckpointPassive  CheckpointMode = iota // copy what it can without waiting on readers or writers
+	CheckpointFull                           // wait for writers, then copy the whole WAL
+	CheckpointRestart                        // like Full, then wait for readers so the WAL restarts from the beginning
+	CheckpointTruncate                       // like Restart, then truncate the WAL file to zero bytes
+)
+
+func (mode CheckpointMode) String() string {
+	switch mode {
+	case CheckpointPassive:
+		return "PASSIVE"
+	case CheckpointFull:
+		return "FULL"
+	case CheckpointRestart:
+		return "RESTART"
+	case CheckpointTruncate:
+		return "TRUNCATE"
+	default:
+		return fmt.Sprintf("CheckpointMode(%d)", int(mode))
+	}
+}
+
+// Checkpoint copies the content of the WAL file back into the database file
+// with PRAGMA wal_checkpoint, which keeps the -wal file from growing under a
+// heavy write load. busy is 1 if the checkpoint couldn't complete because of
+// other connections, log is the number of frames in the WAL and checkpointed
+// how many of them were copied, both are -1 if the database is not in WAL
+// mode.
+func (db *Database) Checkpoint(ctx context.Context, mode CheckpointMode) (busy, log, checkpointed int, err error) {
+	switch mode {
+	case CheckpointPassive, CheckpointFull, CheckpointRestart, CheckpointTruncate:
+	default:
+		return 0, 0, 0, fmt.Errorf("unknown checkpoint mode %s", mode)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer conn.Done()
+
+	for stmt, err := range conn.Query(ctx, "PRAGMA wal_checkpoint("+mode.String()+");") {
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		busy = stmt.ColumnInt(0)
+		log = stmt.ColumnInt(1)
+		checkpointed = stmt.ColumnInt(2)
+	}
+
+	return busy, log, checkpointed, nil
+}
+
+// WithAutoCheckpoint checkpoints the WAL every interval with mode in a
+// background goroutine, see Checkpoint, which stops when the database is
+// closed. It does nothing for databases that are not in WAL mode, e.g.
+// in-memory ones, or are opened read-only. Failed checkpoints are logged.
+//
+// Modes stronger than CheckpointPassive hold off writers while they wait for
+// readers, so each run gives up once interval has passed.
+func WithAutoCheckpoint(interval time.Duration, mode CheckpointMode) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if interval <= 0 {
+			return fmt.Errorf("auto checkpoint: interval must be positive, got %s", interval)
+		}
+		db.autoCheckpoint = interval
+		db.autoCheckpointMode = mode
+		return nil
+	}
+}
+
+func (db *Database) startAutoCheckpoint(ctx context.Context) error {
+	if db.openFlags&OpenReadOnly != 0 {
+		return nil
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	journalMode, err := QueryRow(ctx, conn, func(stmt *Stmt) (string, error) {
+		return stmt.ColumnText(0), nil
+	}, `PRAGMA journal_mode;`)
+	conn.Done()
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(journalMode, "wal") {
+		db.log().DebugContext(ctx, "auto checkpoint skipped, database is not in WAL mode", "journal_mode", journalMode)
+		return nil
+	}
+
+	db.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(db.autoCheckpoint)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// the stronger modes wait on readers and writers for as long as
+			// it takes, give up at the next tick instead
+			checkpointCtx, cancel := context.WithTimeout(ctx, db.autoCheckpoint)
+			_, _, _, err := db.Checkpoint(checkpointCtx, db.autoCheckpointMode)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				db.log().WarnContext(ctx, "auto checkpoint failed", "mode", db.autoCheckpointMode.String(), "error", err)
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package sqlite_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(filepath.Join(dir, "source.db")), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		err := conn.ExecScript(`CREATE TABLE IF NOT EXISTS backup_items (id INTEGER PRIMARY KEY, name TEXT);`)
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]any, 0, 5000)
+		for i := range 5000 {
+			rows = append(rows, []any{i + 1, fmt.Sprintf("item-%d", i+1)})
+		}
+		_, err = conn.BatchInsert(ctx, `INSERT INTO backup_items (id, name) VALUES`, rows)
+		return err
+	})
+	assert.NoError(t, err)
+
+	backupPath := filepath.Join(dir, "backups", "nested", "backup.db")
+
+	err = db.Backup(ctx, backupPath)
+	assert.NoError(t, err)
+
+	backup, err := sqlite.New(ctx, sqlite.WithFile(backupPath), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		backup.Close()
+	})
+
+	err = backup.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		stmt, err := conn.Prepare(ctx, `SELECT COUNT(*) AS count FROM backup_items;`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Reset()
+
+		_, err = stmt.Step()
+		assert.Equal(t, int64(5000), stmt.GetInt64("count"))
+		return err
+	})
+	assert.NoError(t, err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, db.Backup(canceled, filepath.Join(dir, "canceled.db")), context.Canceled)
+}
+
+func TestVacuum(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(filepath.Join(dir, "source.db")), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		err := conn.ExecScript(`
+			CREATE TABLE vacuum_items (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO vacuum_items (name) VALUES ('a'), ('b'), ('c');
+			DELETE FROM vacuum_items WHERE name = 'b';
+		`)
+		return err
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Vacuum(ctx))
+
+	copyPath := filepath.Join(dir, "copies", "vacuum.db")
+	assert.NoError(t, db.VacuumInto(ctx, copyPath))
+
+	copied, err := sqlite.New(ctx, sqlite.WithFile(copyPath), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		copied.Close()
+	})
+
+	err = copied.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		stmt, err := conn.Prepare(ctx, `SELECT COUNT(*) AS count FROM vacuum_items;`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Reset()
+
+		_, err = stmt.Step()
+		assert.Equal(t, int64(2), stmt.GetInt64("count"))
+		return err
+	})
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		if err := conn.Exec(ctx, `BEGIN;`); err != nil {
+			return err
+		}
+		defer conn.Exec(ctx, `ROLLBACK;`)
+
+		return db.Vacuum(ctx)
+	})
+	assert.ErrorIs(t, err, sqlite.ErrVacuumInTx)
+}
+
+func TestOptimize(t *testing.T) {
+	ctx := context.Background()
+
+	var queries []string
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithFile(filepath.Join(t.TempDir(), "optimize.db")),
+		sqlite.WithPoolSize(2),
+		sqlite.WithOptimizeOnClose(),
+		sqlite.WithQueryLogger(func(ctx context.Context, sql string, dur time.Duration, err error) {
+			assert.NoError(t, err)
+			queries = append(queries, sql)
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		err := conn.ExecScript(`
+			CREATE TABLE optimize_items (id INTEGER PRIMARY KEY, category TEXT);
+			CREATE INDEX optimize_items_category ON optimize_items (category);
+		`)
+		if err != nil {
+			return err
+		}
+
+		for i := range 100 {
+			err := conn.Exec(ctx, `INSERT INTO optimize_items (category) VALUES (?);`, fmt.Sprintf("c%d", i%5))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Optimize(ctx))
+	assert.Equal(t, "PRAGMA optimize;", queries[len(queries)-1])
+
+	// usable right up to Close
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		count, err := conn.Count(ctx, "optimize_items", "category = ?", "c1")
+		assert.Equal(t, int64(20), count)
+		return err
+	})
+	assert.NoError(t, err)
+
+	queries = nil
+	assert.NoError(t, db.Close())
+	assert.Equal(t, []string{"PRAGMA optimize;"}, queries)
+}
+
+func TestIntegrityCheck(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(filepath.Join(t.TempDir(), "check.db")), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	problems, err := db.IntegrityCheck(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	err = conn.ExecScript(`
+		CREATE TABLE check_parents (id INTEGER PRIMARY KEY);
+		CREATE TABLE check_children (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES check_parents (id));
+	`)
+	assert.NoError(t, err)
+
+	err = conn.WithoutForeignKeys(func() error {
+		return conn.Exec(ctx, `INSERT INTO check_children (id, parent_id) VALUES (7, 1);`)
+	})
+	assert.NoError(t, err)
+	conn.Done()
+
+	problems, err = db.IntegrityCheck(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foreign key 0 of check_children row 7 references a missing row in check_parents"}, problems)
+}
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "wal.db")
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	err = conn.ExecScript(`CREATE TABLE checkpoint_items (name TEXT);`)
+	assert.NoError(t, err)
+
+	for i := range 100 {
+		err = conn.Exec(ctx, `INSERT INTO checkpoint_items (name) VALUES (?);`, fmt.Sprintf("item-%d", i))
+		assert.NoError(t, err)
+	}
+	conn.Done()
+
+	info, err := os.Stat(path + "-wal")
+	assert.NoError(t, err)
+	assert.NotZero(t, info.Size())
+
+	busy, log, checkpointed, err := db.Checkpoint(ctx, sqlite.CheckpointTruncate)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, busy)
+	assert.Equal(t, log, checkpointed)
+
+	info, err = os.Stat(path + "-wal")
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	_, _, _, err = db.Checkpoint(ctx, sqlite.CheckpointMode(42))
+	assert.Error(t, err)
+}
+
+func TestWithAutoCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "auto.db")
+
+	goroutines := runtime.NumGoroutine()
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithFile(path),
+		sqlite.WithPoolSize(2),
+		sqlite.WithAutoCheckpoint(10*time.Millisecond, sqlite.CheckpointTruncate),
+	)
+	assert.NoError(t, err)
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	err = conn.ExecScript(`
+		CREATE TABLE auto_items (name TEXT);
+		INSERT INTO auto_items (name) VALUES ('one'), ('two');
+	`)
+	assert.NoError(t, err)
+	conn.Done()
+
+	assert.Eventually(t, func() bool {
+		info, err := os.Stat(path + "-wal")
+		return err == nil && info.Size() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, db.Close())
+
+	// polled by hand, assert.Eventually runs goroutines of its own
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+
+	t.Run("in-memory", func(t *testing.T) {
+		db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithAutoCheckpoint(time.Millisecond, sqlite.CheckpointPassive))
+		assert.NoError(t, err)
+		assert.NoError(t, db.Close())
+	})
+}
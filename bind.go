@@ -0,0 +1,139 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// bindValue binds a single Go value to the parameter at idx (starting from 1)
+//
+//   - nil binds NULL, so does a Null[T] that isn't Valid
+//   - time.Duration binds its nanoseconds
+//   - []byte and json.RawMessage bind a blob
+//   - any other slice and maps bind their JSON encoding as text
+//   - strings, integers, floats and bools (including named types) bind natively
+//   - time.Time binds Unix seconds, TimeValue binds using its encoding
+//   - fmt.Stringer binds the result of String()
+func bindValue(stmt *Stmt, idx int, value any) error {
+	if n, ok := value.(nullable); ok {
+		value = n.nullValue()
+	}
+
+	if value == nil {
+		stmt.BindNull(idx)
+		return nil
+	}
+
+	// time.Duration is an int64 kind, bind it explicitly so it's always
+	// stored as nanoseconds, see LoadDuration
+	if d, ok := value.(time.Duration); ok {
+		stmt.BindInt64(idx, d.Nanoseconds())
+		return nil
+	}
+
+	valueType := reflect.TypeOf(value)
+
+	switch valueType.Kind() {
+	case reflect.Slice:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			blob := reflect.ValueOf(value).Bytes()
+			stmt.BindZeroBlob(idx, int64(len(blob)))
+			stmt.BindBytes(idx, blob)
+			return nil
+		}
+		fallthrough
+	case reflect.Map:
+		var buffer bytes.Buffer
+		err := json.NewEncoder(&buffer).Encode(value)
+		if err != nil {
+			return err
+		}
+		stmt.BindText(idx, buffer.String())
+		return nil
+	case reflect.String:
+		stmt.BindText(idx, reflect.ValueOf(value).String())
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		stmt.BindInt64(idx, reflect.ValueOf(value).Int())
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		stmt.BindInt64(idx, int64(reflect.ValueOf(value).Uint()))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		stmt.BindFloat(idx, reflect.ValueOf(value).Float())
+		return nil
+	case reflect.Bool:
+		stmt.BindBool(idx, reflect.ValueOf(value).Bool())
+		return nil
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		TimeSeconds.bind(stmt, idx, v)
+	case TimeValue:
+		v.Encoding.bind(stmt, idx, v.Time)
+	case fmt.Stringer:
+		stmt.BindText(idx, v.String())
+	default:
+		return ErrUnknownType
+	}
+
+	return nil
+}
+
+// bindNamed binds value to the named parameter, e.g. ":id", "@id" or "$id".
+// A name without a prefix matches any of them.
+func bindNamed(stmt *Stmt, name string, value any) error {
+	for i := 1; i <= stmt.BindParamCount(); i++ {
+		param := stmt.BindParamName(i)
+		if param == "" {
+			continue
+		}
+
+		if param == name || param[1:] == name {
+			return bindValue(stmt, i, value)
+		}
+	}
+
+	return fmt.Errorf("%w: unknown parameter %s", ErrPrepareSQL, name)
+}
+
+// checkNumberedParams validates a statement using numbered placeholders, e.g.
+// `WHERE a = ?1 OR b = ?1`. values[0] binds ?1, values[1] binds ?2 and so on,
+// so each one can be referenced more than once, but they must start at ?1
+// without gaps and there must be exactly one value per number.
+func checkNumberedParams(stmt *Stmt, count int) error {
+	params := stmt.BindParamCount()
+
+	numbered := false
+	for i := 1; i <= params && !numbered; i++ {
+		numbered = isNumberedParam(stmt.BindParamName(i))
+	}
+	if !numbered {
+		return nil
+	}
+
+	for i := 1; i <= params; i++ {
+		if stmt.BindParamName(i) == "" {
+			return fmt.Errorf("%w: ?%d is not used, numbered placeholders must start at ?1 without gaps", ErrPrepareSQL, i)
+		}
+	}
+
+	if count != params {
+		return fmt.Errorf("%w: %d values for %d numbered placeholders", ErrPrepareSQL, count, params)
+	}
+
+	return nil
+}
+
+func isNumberedParam(name string) bool {
+	if len(name) < 2 || name[0] != '?' {
+		return false
+	}
+	_, err := strconv.Atoi(name[1:])
+	return err == nil
+}
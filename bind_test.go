@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"zombiezen.com/go/sqlite"
+)
+
+type color int
+
+type name string
+
+func TestBindValue(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6_000_000, time.UTC)
+
+	testCases := []struct {
+		name     string
+		value    any
+		wantType sqlite.ColumnType
+		wantText string
+		wantErr  error
+	}{
+		{"nil", nil, sqlite.TypeNull, "", nil},
+		{"duration", 1500 * time.Millisecond, sqlite.TypeInteger, "1500000000", nil},
+		{"bytes", []byte("blob"), sqlite.TypeBlob, "blob", nil},
+		{"raw json", json.RawMessage(`{"a":1}`), sqlite.TypeBlob, `{"a":1}`, nil},
+		{"slice", []int{1, 2}, sqlite.TypeText, "[1,2]\n", nil},
+		{"map", map[string]int{"a": 1}, sqlite.TypeText, "{\"a\":1}\n", nil},
+		{"string", "hello", sqlite.TypeText, "hello", nil},
+		{"named string", name("john"), sqlite.TypeText, "john", nil},
+		{"int", 42, sqlite.TypeInteger, "42", nil},
+		{"named int", color(3), sqlite.TypeInteger, "3", nil},
+		{"int8", int8(-8), sqlite.TypeInteger, "-8", nil},
+		{"uint16", uint16(16), sqlite.TypeInteger, "16", nil},
+		{"float32", float32(1.5), sqlite.TypeFloat, "1.5", nil},
+		{"float64", 2.25, sqlite.TypeFloat, "2.25", nil},
+		{"bool", true, sqlite.TypeInteger, "1", nil},
+		{"time", at, sqlite.TypeInteger, "1704164645", nil},
+		{"time millis", Time(at, TimeMillis), sqlite.TypeInteger, "1704164645006", nil},
+		{"time rfc3339", Time(at, TimeRFC3339), sqlite.TypeText, "2024-01-02T03:04:05.006Z", nil},
+		{"stringer", &url.URL{Scheme: "https", Host: "ella.to"}, sqlite.TypeText, "https://ella.to", nil},
+		{"unknown", struct{ A int }{1}, sqlite.TypeNull, "", ErrUnknownType},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stmt, _, err := conn.PrepareTransient(`SELECT ? AS value;`)
+			assert.NoError(t, err)
+			defer stmt.Finalize()
+
+			err = bindValue(stmt, 1, tc.value)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			hasRow, err := stmt.Step()
+			assert.NoError(t, err)
+			assert.True(t, hasRow)
+			assert.Equal(t, tc.wantType, stmt.ColumnType(0))
+			assert.Equal(t, tc.wantText, stmt.ColumnText(0))
+		})
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	stmt, _, err := conn.PrepareTransient(`SELECT :id AS id, @name AS name;`)
+	assert.NoError(t, err)
+	defer stmt.Finalize()
+
+	assert.NoError(t, bindNamed(stmt, ":id", 7))
+	assert.NoError(t, bindNamed(stmt, "name", "john"))
+	assert.ErrorIs(t, bindNamed(stmt, "missing", 1), ErrPrepareSQL)
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, int64(7), stmt.GetInt64("id"))
+	assert.Equal(t, "john", stmt.GetText("name"))
+}
@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OpenBlob opens the BLOB stored in column of the row with rowid for
+// incremental I/O, so it can be streamed with io.Copy instead of being loaded
+// in memory. db is the schema name, "main" unless the table is in an attached
+// database. Close the returned Blob once done.
+//
+// A blob can't be resized through OpenBlob, writes past its end fail. Size it
+// first, for example by inserting zeroblob(n) or binding a zero blob, or use
+// InsertBlobStream which does both.
+func (c *Conn) OpenBlob(ctx context.Context, db, table, column string, rowid int64, write bool) (*Blob, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.conn.OpenBlob(db, table, column, rowid, write)
+}
+
+// InsertBlobStream inserts a new row into table with a BLOB of size bytes in
+// col, read from r, and returns the row's id. The blob is allocated with
+// zeroblob first and then streamed, so it never has to fit in memory. The
+// insert is rolled back if r returns fewer than size bytes.
+func InsertBlobStream(ctx context.Context, conn *Conn, table, col string, r io.Reader, size int64) (rowid int64, err error) {
+	if !isIdentifier(table) || !isIdentifierPart(col) {
+		return 0, fmt.Errorf("%w: invalid table or column name %q.%q", ErrPrepareSQL, table, col)
+	}
+
+	defer conn.Save()(&err)
+
+	err = conn.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (zeroblob(?));`, table, col), size)
+	if err != nil {
+		return 0, err
+	}
+
+	rowid = conn.conn.LastInsertRowID()
+
+	schema, name := "main", table
+	if before, after, ok := strings.Cut(table, "."); ok {
+		schema, name = before, after
+	}
+
+	blob, err := conn.OpenBlob(ctx, schema, name, col, rowid, true)
+	if err != nil {
+		return 0, err
+	}
+	defer blob.Close()
+
+	_, err = io.CopyN(blob, r, size)
+	if err != nil {
+		return 0, err
+	}
+
+	return rowid, nil
+}
@@ -0,0 +1,56 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertBlobStream(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS blob_files (id INTEGER PRIMARY KEY, data BLOB);`)
+	assert.NoError(t, err)
+
+	data := make([]byte, 5<<20)
+	_, err = rand.Read(data)
+	assert.NoError(t, err)
+
+	rowid, err := sqlite.InsertBlobStream(ctx, conn, "blob_files", "data", bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+
+	blob, err := conn.OpenBlob(ctx, "main", "blob_files", "data", rowid, false)
+	assert.NoError(t, err)
+	defer blob.Close()
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, blob)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, buf.Bytes()))
+
+	// a short reader rolls the insert back
+	before, err := conn.Count(ctx, "blob_files", "")
+	assert.NoError(t, err)
+
+	_, err = sqlite.InsertBlobStream(ctx, conn, "blob_files", "data", bytes.NewReader(data[:10]), 100)
+	assert.ErrorIs(t, err, io.EOF)
+
+	after, err := conn.Count(ctx, "blob_files", "")
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
@@ -1,31 +1,283 @@
 package sqlite
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
-	"reflect"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
-	"ella.to/logger"
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
 type Conn struct {
-	conn *sqlite.Conn
-	put  func(conn *Conn)
+	conn    *sqlite.Conn
+	db      *Database
+	put     func(conn *Conn)
+	lastErr error
+	label   string
+	takenAt time.Time
+	depth   int
 }
 
-// When your try to use transaction in a nice way, you can use the following
-// at the beginning of your code:
+// Result is returned by the helpers that modify rows
+type Result struct {
+	LastInsertID int64
+	RowsAffected int64
+}
+
+func (c *Conn) result() Result {
+	return Result{
+		LastInsertID: c.conn.LastInsertRowID(),
+		RowsAffected: int64(c.conn.Changes()),
+	}
+}
+
+// Save starts a savepoint and returns the function that ends it, meant to be
+// deferred right away at the beginning of a function with a named error:
 //
-// defer conn.Save(&err)
-func (c *Conn) Save(err *error) {
-	sqlitex.Save(c.conn)(err)
+//	defer conn.Save()(&err)
+//
+// It works like Savepoint: the changes are committed, or kept by the
+// enclosing transaction, if *err is nil, and rolled back otherwise. A panic
+// unwinding through the deferred call rolls back too, then keeps panicking.
+func (c *Conn) Save() (release func(*error)) {
+	return c.Savepoint("save")
+}
+
+// Savepoint starts a savepoint called name and returns the function that ends
+// it, meant to be deferred right away:
+//
+//	defer conn.Savepoint("import_rows")(&err)
+//
+// If *err is nil when it runs, the savepoint is released and its changes
+// become part of the enclosing transaction, or are committed if there is
+// none. Otherwise, or on panic, everything done since the savepoint started
+// is rolled back, which allows undoing a sub-operation while keeping the
+// work of the outer savepoints. Savepoints nest, see Depth.
+func (c *Conn) Savepoint(name string) (release func(*error)) {
+	if !isIdentifierPart(name) {
+		return savepointFailed(fmt.Errorf("%w: invalid savepoint name %q", ErrPrepareSQL, name))
+	}
+
+	err := sqlitex.ExecuteTransient(c.conn, "SAVEPOINT "+name+";", nil)
+	if err != nil {
+		return savepointFailed(fmt.Errorf("%w: %w", ErrExecSQL, err))
+	}
+	c.depth++
+
+	return func(errp *error) {
+		c.depth--
+
+		recovered := recover()
+		if recovered == nil && *errp == nil {
+			err := sqlitex.ExecuteTransient(c.conn, "RELEASE "+name+";", nil)
+			if err == nil {
+				return
+			}
+			*errp = fmt.Errorf("%w: %w", ErrExecSQL, err)
+		}
+
+		// the rollback must run even if the connection has been interrupted
+		prev := c.conn.SetInterrupt(nil)
+		defer c.conn.SetInterrupt(prev)
+
+		err := sqlitex.ExecuteTransient(c.conn, "ROLLBACK TO "+name+";", nil)
+		if err == nil {
+			err = sqlitex.ExecuteTransient(c.conn, "RELEASE "+name+";", nil)
+		}
+		if err != nil && recovered == nil {
+			*errp = fmt.Errorf("%w: %w", *errp, err)
+		}
+
+		if recovered != nil {
+			panic(recovered)
+		}
+	}
+}
+
+// BeginImmediate starts a transaction with BEGIN IMMEDIATE, which takes the
+// write lock right away, and returns the function that ends it, meant to be
+// deferred right away:
+//
+//	end, err := conn.BeginImmediate()
+//	if err != nil {
+//		return err
+//	}
+//	defer end(&err)
+//
+// If *err is nil when it runs, the transaction is committed, otherwise, or on
+// panic, it's rolled back. Use it for transactions that read and then write:
+// a deferred transaction, which Save and Savepoint start, takes the write
+// lock only at its first write and fails with SQLITE_BUSY if another writer
+// got there first, while this one waits for the other writer to finish. It
+// can't be called inside another transaction or savepoint.
+func (c *Conn) BeginImmediate() (end func(*error), err error) {
+	err = sqlitex.ExecuteTransient(c.conn, "BEGIN IMMEDIATE;", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrExecSQL, err)
+	}
+
+	return func(errp *error) {
+		recovered := recover()
+		if recovered == nil && *errp == nil {
+			err := sqlitex.ExecuteTransient(c.conn, "COMMIT;", nil)
+			if err == nil {
+				return
+			}
+			*errp = fmt.Errorf("%w: %w", ErrExecSQL, err)
+		}
+
+		// the rollback must run even if the connection has been interrupted
+		prev := c.conn.SetInterrupt(nil)
+		defer c.conn.SetInterrupt(prev)
+
+		if !c.conn.AutocommitEnabled() {
+			err := sqlitex.ExecuteTransient(c.conn, "ROLLBACK;", nil)
+			if err != nil && recovered == nil {
+				*errp = fmt.Errorf("%w: %w", *errp, err)
+			}
+		}
+
+		if recovered != nil {
+			panic(recovered)
+		}
+	}, nil
+}
+
+// savepointFailed is returned by Savepoint when the savepoint couldn't be
+// started, it reports err unless the caller already failed
+func savepointFailed(err error) func(*error) {
+	return func(errp *error) {
+		if *errp == nil {
+			*errp = err
+		}
+	}
+}
+
+// Depth returns how many savepoints started with Save or Savepoint are open
+// on this connection
+func (c *Conn) Depth() int {
+	return c.depth
+}
+
+// ErrForeignKeysInTx is returned by WithoutForeignKeys when it is called from
+// inside a Save scope, SQLite ignores PRAGMA foreign_keys within a transaction
+var ErrForeignKeysInTx = errors.New("foreign keys cannot be toggled within a transaction")
+
+// WithoutForeignKeys disables foreign key enforcement on this connection
+// while fn runs and restores the previous setting afterwards, useful to load
+// rows in an order that breaks references for a while. It must not be called
+// from within a Save scope. Use PRAGMA foreign_key_check to find rows that
+// are still broken once fn returns.
+func (c *Conn) WithoutForeignKeys(fn func() error) (err error) {
+	if !c.conn.AutocommitEnabled() {
+		return ErrForeignKeysInTx
+	}
+
+	var enabled bool
+	err = sqlitex.ExecuteTransient(c.conn, `PRAGMA foreign_keys;`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			enabled = stmt.ColumnInt(0) == 1
+			return nil
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExecSQL, err)
+	}
+
+	if !enabled {
+		return fn()
+	}
+
+	err = sqlitex.ExecuteTransient(c.conn, `PRAGMA foreign_keys = OFF;`, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExecSQL, err)
+	}
+
+	defer func() {
+		restoreErr := sqlitex.ExecuteTransient(c.conn, `PRAGMA foreign_keys = ON;`, nil)
+		if restoreErr != nil && err == nil {
+			err = fmt.Errorf("%w: %w", ErrExecSQL, restoreErr)
+		}
+	}()
+
+	return fn()
+}
+
+// WithTempTable creates a temporary table with ddl, runs fn and drops the
+// table again, even if fn fails or panics, so it never outlives the
+// operation and leaks into whoever takes the connection from the pool next:
+//
+//	err := conn.WithTempTable(ctx, `CREATE TEMP TABLE ids (id INTEGER PRIMARY KEY);`, func() error {
+//		...
+//	})
+//
+// ddl must be a CREATE TEMP TABLE statement. A table that already existed,
+// e.g. with IF NOT EXISTS, is left alone.
+func (c *Conn) WithTempTable(ctx context.Context, ddl string, fn func() error) (err error) {
+	fields := strings.Fields(strings.ToUpper(ddl))
+	if len(fields) < 3 || fields[0] != "CREATE" || (fields[1] != "TEMP" && fields[1] != "TEMPORARY") || fields[2] != "TABLE" {
+		return fmt.Errorf("%w: WithTempTable needs a CREATE TEMP TABLE statement", ErrPrepareSQL)
+	}
+
+	before, err := c.tempTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.Exec(ctx, ddl)
+	if err != nil {
+		return err
+	}
+
+	after, err := c.tempTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// the drop must run even if the connection has been interrupted
+		prev := c.conn.SetInterrupt(nil)
+		defer c.conn.SetInterrupt(prev)
+
+		for _, table := range after {
+			if slices.Contains(before, table) {
+				continue
+			}
+
+			dropErr := sqlitex.ExecuteTransient(c.conn, `DROP TABLE IF EXISTS temp."`+strings.ReplaceAll(table, `"`, `""`)+`";`, nil)
+			if dropErr != nil && err == nil {
+				err = fmt.Errorf("%w: %w", ErrExecSQL, dropErr)
+			}
+		}
+	}()
+
+	return fn()
+}
+
+// tempTables returns the names of the temporary tables of this connection
+func (c *Conn) tempTables(ctx context.Context) ([]string, error) {
+	var tables []string
+
+	for stmt, err := range c.Query(ctx, `SELECT name FROM temp.sqlite_master WHERE type = 'table';`) {
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, stmt.GetText("name"))
+	}
+
+	return tables, nil
+}
+
+// Label returns the label given to ConnLabeled
+func (c *Conn) Label() string {
+	return c.label
 }
 
 // Done returns the connection back to the pool
@@ -33,79 +285,639 @@ func (c *Conn) Done() {
 	c.put(c)
 }
 
-func (c *Conn) Prepare(ctx context.Context, sql string, values ...any) (*Stmt, error) {
-	if slog.Default().Enabled(ctx, slog.LevelDebug) {
-		logger.Debug(ctx, "prepare sql", "sql", ShowSql(sql, values...))
+// LastErr returns the error of the most recent Prepare or Exec call on this
+// connection, or nil if that call succeeded
+func (c *Conn) LastErr() error {
+	return c.lastErr
+}
+
+func (c *Conn) Prepare(ctx context.Context, sql string, values ...any) (_ *Stmt, err error) {
+	defer func() {
+		c.lastErr = err
+	}()
+
+	if log := c.db.log(); log.Enabled(ctx, slog.LevelDebug) {
+		log.DebugContext(ctx, "prepare sql", "sql", showSql(c.db.timeEncoding, sql, values...))
 	}
 
 	sql = strings.TrimSpace(sql)
 
+	start := time.Now()
 	stmt, err := c.conn.Prepare(sql)
+	c.db.metrics.StatementPrepared(time.Since(start))
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrPrepareSQL, err)
 	}
 
-	for i, value := range values {
-		i++ // bind starts from 1
+	err = c.bind(stmt, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// Query prepares sql and returns an iterator over its rows. The statement is
+// reset once the loop ends, even with an early break, so don't keep the
+// yielded *Stmt around after the loop.
+//
+//	for stmt, err := range conn.Query(ctx, `SELECT name FROM users;`) {
+//		if err != nil {
+//			return err
+//		}
+//		names = append(names, stmt.GetText("name"))
+//	}
+func (c *Conn) Query(ctx context.Context, sql string, values ...any) iter.Seq2[*Stmt, error] {
+	return func(yield func(*Stmt, error) bool) {
+		var err error
+		ctx, q := c.startQuery(ctx, sql, values)
+		defer q.finish(&err)
+		q.rowsKey = "db.rows_returned"
+
+		ctx, done := c.withQueryTimeout(ctx)
+		defer done()
 
-		if value == nil {
-			stmt.BindNull(i)
-			continue
+		stmt, err := q.prepare(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
 		}
+		defer stmt.Reset()
 
-		valueType := reflect.TypeOf(value)
+		for {
+			var hasRow bool
+			hasRow, err = stmt.Step()
+			if err != nil {
+				err = stepError(ctx, err)
+				c.lastErr = err
+				yield(nil, err)
+				return
+			}
 
-		switch valueType.Kind() {
-		case reflect.Slice:
-			if valueType.Elem().Kind() == reflect.Uint8 {
-				blob, ok := value.([]byte)
-				if !ok {
-					blob = value.(json.RawMessage)
-				}
-				stmt.BindZeroBlob(i, int64(len(blob)))
-				stmt.BindBytes(i, blob)
-				continue
+			if !hasRow {
+				return
 			}
-			fallthrough
-		case reflect.Map:
-			var buffer bytes.Buffer
-			err = json.NewEncoder(&buffer).Encode(value)
+
+			q.rows++
+			if !yield(stmt, nil) {
+				return
+			}
+		}
+	}
+}
+
+// queryRun tracks a query from start to finish for the metrics, tracer and
+// logger set on the database
+type queryRun struct {
+	conn   *Conn
+	ctx    context.Context
+	start  time.Time
+	sql    string
+	values []any
+	span   Span
+
+	// rowsKey names the rows attribute of the span, rows affected or
+	// returned, it's left out when empty
+	rowsKey string
+	rows    int64
+
+	// prepareDur is how long preparing and binding took, the rest of the
+	// query's time is spent stepping
+	prepareDur time.Duration
+}
+
+// prepare prepares the query's sql with its values, timing it apart from
+// the stepping
+func (q *queryRun) prepare(ctx context.Context) (*Stmt, error) {
+	start := time.Now()
+	stmt, err := q.conn.Prepare(ctx, q.sql, q.values...)
+	q.prepareDur = time.Since(start)
+	return stmt, err
+}
+
+// startQuery starts tracking a query, the returned context carries the span
+// if a tracer is set. Call finish with the query's error once it's over.
+func (c *Conn) startQuery(ctx context.Context, sql string, values []any) (context.Context, *queryRun) {
+	q := &queryRun{conn: c, ctx: ctx, start: time.Now(), sql: sql, values: values}
+
+	if c.db != nil && c.db.tracer != nil {
+		ctx, q.span = c.db.tracer.Start(ctx, spanName(sql))
+	}
+
+	return ctx, q
+}
+
+func (q *queryRun) finish(err *error) {
+	db := q.conn.db
+	if db == nil {
+		return
+	}
+
+	dur := time.Since(q.start)
+
+	if *err != nil {
+		db.metrics.QueryFailed(errorKind(*err))
+	} else {
+		db.metrics.QueryExecuted(dur)
+	}
+
+	if q.span != nil {
+		q.span.SetAttribute("db.system", "sqlite")
+		q.span.SetAttribute("db.statement", showSql(db.timeEncoding, q.sql, q.values...))
+		if q.rowsKey != "" {
+			q.span.SetAttribute(q.rowsKey, q.rows)
+		}
+		if *err != nil {
+			q.span.RecordError(*err)
+		}
+		q.span.End()
+	}
+
+	if db.queryLogger != nil {
+		db.queryLogger(q.ctx, showSql(db.timeEncoding, q.sql, q.values...), dur, *err)
+	}
+
+	if db.queryStats != nil {
+		db.queryStats(q.ctx, QueryStats{
+			SQL:             showSql(db.timeEncoding, q.sql, q.values...),
+			PrepareDuration: q.prepareDur,
+			StepDuration:    max(dur-q.prepareDur, 0),
+			Err:             *err,
+		})
+	}
+}
+
+// QueryRow runs a query that is expected to return a single row and scans it
+// with scan. It returns ErrNotFound if the query returns no rows. The statement
+// is reset either way, so the connection can go back to the pool.
+func QueryRow[T any](ctx context.Context, conn *Conn, scan func(*Stmt) (T, error), sql string, values ...any) (_ T, err error) {
+	ctx, q := conn.startQuery(ctx, sql, values)
+	defer q.finish(&err)
+	q.rowsKey = "db.rows_returned"
+
+	var zero T
+
+	ctx, done := conn.withQueryTimeout(ctx)
+	defer done()
+
+	stmt, err := q.prepare(ctx)
+	if err != nil {
+		return zero, err
+	}
+	defer stmt.Reset()
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return zero, stepError(ctx, err)
+	}
+
+	if !hasRow {
+		return zero, ErrNotFound
+	}
+	q.rows = 1
+
+	return scan(stmt)
+}
+
+// withQueryTimeout derives a context bounded by the timeout set with
+// WithQueryTimeout, or by ctx's own deadline when it ends earlier, and
+// interrupts the connection when it's done. The returned func restores the
+// previous interrupt and must be called once the query is over.
+func (c *Conn) withQueryTimeout(ctx context.Context) (context.Context, func()) {
+	cancel := context.CancelFunc(func() {})
+	if c.db != nil && c.db.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.db.queryTimeout)
+	}
+
+	// a ctx that never ends leaves the interrupt set by the pool alone
+	if ctx.Done() == nil {
+		return ctx, cancel
+	}
+
+	prev := c.conn.SetInterrupt(ctx.Done())
+
+	return ctx, func() {
+		c.conn.SetInterrupt(prev)
+		cancel()
+	}
+}
+
+// stepError wraps an error returned by Step with ErrExecSQL, and with the
+// context's error when the statement was interrupted because ctx is done
+func stepError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w: %w", ErrExecSQL, err, ctxErr)
+	}
+	return fmt.Errorf("%w: %w", ErrExecSQL, err)
+}
+
+// bind binds values to stmt starting from the first parameter. time.Time
+// values use the database's TimeEncoding.
+func (c *Conn) bind(stmt *Stmt, values ...any) error {
+	err := checkNumberedParams(stmt, len(values))
+	if err != nil {
+		return err
+	}
+
+	for i, value := range values {
+		if n, ok := value.(nullable); ok {
+			value = n.nullValue()
+		}
+
+		if t, ok := value.(time.Time); ok {
+			value = Time(t, c.db.timeEncoding)
+		}
+
+		err := bindValue(stmt, i+1, value) // bind starts from 1
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rebind resets stmt, clears its bindings and binds values the same way
+// Prepare does, so a statement can be stepped again with new values without
+// being prepared again:
+//
+//	stmt, err := conn.Prepare(ctx, `INSERT INTO users (name) VALUES (?);`)
+//	for _, name := range names {
+//		err = conn.Rebind(stmt, name)
+//		...
+//		_, err = stmt.Step()
+//	}
+func (c *Conn) Rebind(stmt *Stmt, values ...any) error {
+	err := stmt.Reset()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExecSQL, err)
+	}
+
+	err = stmt.ClearBindings()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExecSQL, err)
+	}
+
+	return c.bind(stmt, values...)
+}
+
+// ExecMany prepares sql once and executes it for every set of values in
+// argSets, which is a lot faster than preparing the same statement over and
+// over for bulk updates or deletes. Everything runs in one savepoint, so if
+// one set fails none of them are applied.
+func (c *Conn) ExecMany(ctx context.Context, sql string, argSets [][]any) (err error) {
+	ctx, q := c.startQuery(ctx, sql, nil)
+	defer q.finish(&err)
+	q.rowsKey = "db.rows_affected"
+
+	defer func() {
+		c.lastErr = err
+	}()
+
+	ctx, done := c.withQueryTimeout(ctx)
+	defer done()
+
+	defer c.Save()(&err)
+
+	stmt, err := q.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	defer stmt.Reset()
+
+	for _, args := range argSets {
+		err = c.Rebind(stmt, args...)
+		if err != nil {
+			return err
+		}
+
+		for {
+			hasRow, err := stmt.Step()
 			if err != nil {
-				return nil, err
+				return stepError(ctx, err)
+			}
+
+			if !hasRow {
+				break
 			}
-			stmt.BindText(i, buffer.String())
-			continue
-		case reflect.String:
-			stmt.BindText(i, reflect.ValueOf(value).String())
-			continue
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			stmt.BindInt64(i, reflect.ValueOf(value).Int())
-			continue
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			stmt.BindInt64(i, int64(reflect.ValueOf(value).Uint()))
-			continue
-		case reflect.Float32, reflect.Float64:
-			stmt.BindFloat(i, reflect.ValueOf(value).Float())
-			continue
-		case reflect.Bool:
-			stmt.BindBool(i, reflect.ValueOf(value).Bool())
-			continue
-		}
-
-		switch v := value.(type) {
-		case time.Time:
-			stmt.BindInt64(i, v.UTC().Unix())
-		case fmt.Stringer:
-			stmt.BindText(i, v.String())
-		default:
-			return nil, ErrUnknownType
 		}
+		q.rows += int64(c.conn.Changes())
 	}
 
-	return stmt, nil
+	return nil
+}
+
+// BatchInsert inserts rows with multi-row VALUES statements. sql is the
+// statement up to and including VALUES, e.g.
+//
+//	conn.BatchInsert(ctx, `INSERT INTO users (name, age) VALUES`, [][]any{{"john", 30}, {"jane", 25}})
+//
+// All rows must have the same number of values. Rows are split into chunks
+// so a single statement never binds more than SQLite's default variable
+// limit (999), and all chunks run in one savepoint. The returned Result has
+// the total rows affected and the last inserted id.
+func (c *Conn) BatchInsert(ctx context.Context, sql string, rows [][]any) (result Result, err error) {
+	if len(rows) == 0 {
+		return Result{}, nil
+	}
+
+	numCols := len(rows[0])
+	if numCols == 0 || numCols > maxVariableNumber {
+		return Result{}, fmt.Errorf("%w: rows must have between 1 and %d values", ErrPrepareSQL, maxVariableNumber)
+	}
+
+	for i, row := range rows {
+		if len(row) != numCols {
+			return Result{}, fmt.Errorf("%w: row %d has %d values, expected %d", ErrPrepareSQL, i, len(row), numCols)
+		}
+	}
+
+	defer c.Save()(&err)
+
+	sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+	chunkSize := maxVariableNumber / numCols
+
+	for start := 0; start < len(rows); start += chunkSize {
+		end := min(start+chunkSize, len(rows))
+
+		var sb strings.Builder
+		sb.WriteString(sql)
+		sb.WriteString(" ")
+		GroupPlaceholdersStringBuilder(end-start, numCols, &sb)
+		sb.WriteString(";")
+
+		args := make([]any, 0, (end-start)*numCols)
+		for _, row := range rows[start:end] {
+			args = append(args, row...)
+		}
+
+		err = c.Exec(ctx, sb.String(), args...)
+		if err != nil {
+			return Result{}, err
+		}
+
+		result.RowsAffected += int64(c.conn.Changes())
+		result.LastInsertID = c.conn.LastInsertRowID()
+	}
+
+	return result, nil
+}
+
+// PrepareIn works like Prepare, but every slice value is expanded into one
+// placeholder per element, which is what an IN clause needs:
+//
+//	conn.PrepareIn(ctx, `SELECT * FROM users WHERE id IN (?);`, []int64{1, 2, 3})
+//
+// Prepare on the other hand binds a slice as a single JSON array text. To keep
+// that behavior for a value passed to PrepareIn, json.Marshal it yourself and
+// pass it as a string. See ExpandSlices for details.
+func (c *Conn) PrepareIn(ctx context.Context, sql string, values ...any) (*Stmt, error) {
+	sql, values, err := ExpandSlices(sql, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Prepare(ctx, sql, values...)
 }
 
 // Use this function to execute a script that contains multiple SQL statements
 func (c *Conn) ExecScript(sql string) error {
 	return sqlitex.ExecScript(c.conn, strings.TrimSpace(sql))
 }
+
+// Exec prepares the sql, binds the values and steps through the statement
+// until it's done. Any returned rows are ignored, use Prepare if you need them.
+func (c *Conn) Exec(ctx context.Context, sql string, values ...any) (err error) {
+	ctx, q := c.startQuery(ctx, sql, values)
+	defer q.finish(&err)
+	q.rowsKey = "db.rows_affected"
+
+	defer func() {
+		c.lastErr = err
+	}()
+
+	ctx, done := c.withQueryTimeout(ctx)
+	defer done()
+
+	stmt, err := q.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	defer stmt.Reset()
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return stepError(ctx, err)
+		}
+
+		if !hasRow {
+			q.rows = int64(c.conn.Changes())
+			return nil
+		}
+	}
+}
+
+// ExecReturning runs an INSERT, UPDATE or DELETE with a RETURNING clause and
+// calls scan for every returned row, which saves a SELECT to read generated
+// ids or column defaults back:
+//
+//	err := conn.ExecReturning(ctx, `INSERT INTO users (name) VALUES (?) RETURNING id, created_at;`, func(stmt *sqlite.Stmt) error {
+//		id = stmt.GetInt64("id")
+//		createdAt = sqlite.LoadTime(stmt, "created_at")
+//		return nil
+//	}, "john")
+//
+// SQLite makes all the changes before the first row is returned, so an error
+// from scan stops the loop but doesn't undo them, run it within Save if it
+// has to.
+func (c *Conn) ExecReturning(ctx context.Context, sql string, scan func(*Stmt) error, values ...any) (err error) {
+	ctx, q := c.startQuery(ctx, sql, values)
+	defer q.finish(&err)
+	q.rowsKey = "db.rows_affected"
+
+	defer func() {
+		c.lastErr = err
+	}()
+
+	ctx, done := c.withQueryTimeout(ctx)
+	defer done()
+
+	stmt, err := q.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	defer stmt.Reset()
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return stepError(ctx, err)
+		}
+
+		if !hasRow {
+			q.rows = int64(c.conn.Changes())
+			return nil
+		}
+
+		err = scan(stmt)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Count returns the number of rows in table, optionally filtered by where,
+// which is appended after WHERE and bound with args. table must be a plain
+// identifier, optionally qualified with a schema name.
+//
+//	count, err := conn.Count(ctx, "users", "age > ?", 18)
+func (c *Conn) Count(ctx context.Context, table string, where string, args ...any) (int64, error) {
+	if !isIdentifier(table) {
+		return 0, fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	sql := "SELECT COUNT(*) FROM " + table
+	if where != "" {
+		sql += " WHERE " + where
+	}
+	sql += ";"
+
+	return QueryRow(ctx, c, func(stmt *Stmt) (int64, error) {
+		return stmt.ColumnInt64(0), nil
+	}, sql, args...)
+}
+
+// Exists reports whether the select in sql returns at least one row. The
+// query is wrapped in SELECT EXISTS(...), so SQLite stops at the first match
+// instead of producing every row.
+//
+//	ok, err := conn.Exists(ctx, `SELECT 1 FROM users WHERE email = ?`, email)
+func (c *Conn) Exists(ctx context.Context, sql string, args ...any) (bool, error) {
+	sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+
+	return QueryRow(ctx, c, func(stmt *Stmt) (bool, error) {
+		return stmt.ColumnBool(0), nil
+	}, "SELECT EXISTS("+sql+");", args...)
+}
+
+// Upsert inserts row, a map of column name to value, into table. If the row
+// conflicts with an existing one on conflictCols, the existing row gets the
+// new values of updateCols instead, or is left alone if updateCols is empty:
+//
+//	INSERT INTO users (email, name) VALUES (?, ?)
+//	ON CONFLICT (email) DO UPDATE SET name = excluded.name;
+//
+// The table and every column must be plain identifiers.
+func (c *Conn) Upsert(ctx context.Context, table string, row map[string]any, conflictCols []string, updateCols []string) (Result, error) {
+	if !isIdentifier(table) {
+		return Result{}, fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	if len(row) == 0 {
+		return Result{}, fmt.Errorf("%w: upsert needs at least one column", ErrPrepareSQL)
+	}
+
+	if len(conflictCols) == 0 {
+		return Result{}, fmt.Errorf("%w: upsert needs at least one conflict column", ErrPrepareSQL)
+	}
+
+	cols := slices.Sorted(maps.Keys(row))
+	for _, col := range slices.Concat(cols, conflictCols, updateCols) {
+		if !isIdentifierPart(col) {
+			return Result{}, fmt.Errorf("%w: invalid column name %q", ErrPrepareSQL, col)
+		}
+	}
+
+	values := make([]any, 0, len(cols))
+	for _, col := range cols {
+		values = append(values, row[col])
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (")
+	sb.WriteString(strings.Join(cols, ", "))
+	sb.WriteString(") VALUES (")
+	placeholders(len(cols), &sb)
+	sb.WriteString(") ON CONFLICT (")
+	sb.WriteString(strings.Join(conflictCols, ", "))
+	sb.WriteString(")")
+
+	if len(updateCols) == 0 {
+		sb.WriteString(" DO NOTHING;")
+	} else {
+		sb.WriteString(" DO UPDATE SET ")
+		for i, col := range updateCols {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(col)
+			sb.WriteString(" = excluded.")
+			sb.WriteString(col)
+		}
+		sb.WriteString(";")
+	}
+
+	err := c.Exec(ctx, sb.String(), values...)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return c.result(), nil
+}
+
+// DeleteBatched deletes the rows of table matching where, bound with args, at
+// most batchSize rows per statement until none is left, and returns how many
+// rows were deleted in total. Outside a transaction every batch commits on
+// its own, so the write lock is released between batches and other writers
+// get a turn during a large delete; inside a Save scope it all still commits
+// at once. table must be a plain identifier, optionally qualified with a
+// schema name.
+//
+//	total, err := conn.DeleteBatched(ctx, "events", "created_at < ?", []any{cutoff}, 1000)
+//
+// SQLite only supports DELETE ... LIMIT when compiled with
+// SQLITE_ENABLE_UPDATE_DELETE_LIMIT, otherwise the batch is picked by rowid
+// in a subquery, which doesn't work for WITHOUT ROWID tables.
+func (c *Conn) DeleteBatched(ctx context.Context, table, where string, args []any, batchSize int) (total int64, err error) {
+	if !isIdentifier(table) {
+		return 0, fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	if batchSize < 1 {
+		return 0, fmt.Errorf("%w: batch size must be positive, got %d", ErrPrepareSQL, batchSize)
+	}
+
+	if where == "" {
+		where = "1=1"
+	}
+
+	deleteLimit, err := QueryRow(ctx, c, func(stmt *Stmt) (bool, error) {
+		return stmt.ColumnBool(0), nil
+	}, `SELECT sqlite_compileoption_used('ENABLE_UPDATE_DELETE_LIMIT');`)
+	if err != nil {
+		return 0, err
+	}
+
+	sql := fmt.Sprintf("DELETE FROM %s WHERE rowid IN (SELECT rowid FROM %s WHERE %s LIMIT ?);", table, table, where)
+	if deleteLimit {
+		sql = fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT ?;", table, where)
+	}
+
+	values := append(args[:len(args):len(args)], batchSize)
+
+	for {
+		err := c.Exec(ctx, sql, values...)
+		if err != nil {
+			return total, err
+		}
+
+		n := int64(c.conn.Changes())
+		if n == 0 {
+			return total, nil
+		}
+		total += n
+	}
+}
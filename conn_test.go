@@ -2,8 +2,13 @@ package sqlite_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"ella.to/sqlite"
 	"github.com/stretchr/testify/assert"
@@ -141,3 +146,1091 @@ func TestConcurrentCallsInsert(t *testing.T) {
 	stmt.Finalize()
 	assert.Equal(t, int64(concurrentWorkers*totalCalls), count)
 }
+
+func TestDurationRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS durations (value INTEGER);`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO durations (value) VALUES (?);`, 1500*time.Millisecond)
+	assert.NoError(t, err)
+
+	stmt, err := conn.Prepare(ctx, `SELECT value FROM durations;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, int64(1_500_000_000), stmt.GetInt64("value"))
+	assert.Equal(t, 1500*time.Millisecond, sqlite.LoadDuration(stmt, "value"))
+}
+
+func TestLastErr(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	assert.NoError(t, conn.LastErr())
+
+	err = conn.Exec(ctx, `SELECT * FROM table_does_not_exist;`)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+	assert.ErrorIs(t, conn.LastErr(), sqlite.ErrPrepareSQL)
+
+	err = conn.Exec(ctx, `SELECT 1;`)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.LastErr())
+}
+
+func TestPrepareIn(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS in_users (id INTEGER PRIMARY KEY, tags TEXT);`)
+	assert.NoError(t, err)
+
+	for i := range 5 {
+		err = conn.Exec(ctx, `INSERT INTO in_users (id, tags) VALUES (?, ?);`, i+1, []string{"a", "b"})
+		assert.NoError(t, err)
+	}
+
+	stmt, err := conn.PrepareIn(ctx, `SELECT id FROM in_users WHERE id IN (?) ORDER BY id;`, []int{2, 4, 6})
+	assert.NoError(t, err)
+
+	var ids []int64
+	for {
+		hasRow, err := stmt.Step()
+		assert.NoError(t, err)
+		if !hasRow {
+			break
+		}
+		ids = append(ids, stmt.GetInt64("id"))
+	}
+	assert.Equal(t, []int64{2, 4}, ids)
+
+	queryIDs := func(sql string, values ...any) []int64 {
+		stmt, err := conn.PrepareIn(ctx, sql, values...)
+		assert.NoError(t, err)
+		defer stmt.Reset()
+
+		ids := []int64{}
+		for {
+			hasRow, err := stmt.Step()
+			assert.NoError(t, err)
+			if !hasRow {
+				return ids
+			}
+			ids = append(ids, stmt.GetInt64("id"))
+		}
+	}
+
+	assert.Equal(t, []int64{1, 3}, queryIDs(`SELECT id FROM in_users WHERE id IN (?) ORDER BY id;`, []int64{1, 3}))
+	assert.Equal(t, []int64{2, 5}, queryIDs(`SELECT id FROM in_users WHERE CAST(id AS TEXT) IN (?) ORDER BY id;`, []string{"2", "5", "9"}))
+	assert.Equal(t, []int64{}, queryIDs(`SELECT id FROM in_users WHERE id IN (?) ORDER BY id;`, []string{}))
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, queryIDs(`SELECT id FROM in_users WHERE id NOT IN (?) ORDER BY id;`, []int64{}))
+
+	// Prepare still stores slices as JSON
+	stmt, err = conn.Prepare(ctx, `SELECT tags FROM in_users WHERE id = ?;`, 1)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	_, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, "[\"a\",\"b\"]\n", stmt.GetText("tags"))
+}
+
+func TestBatchInsert(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	// with 3 columns a single statement fits 333 rows (999 variables)
+	testCases := []struct {
+		rows int
+	}{
+		{1},
+		{333},
+		{334},
+		{666},
+		{1000},
+	}
+
+	for _, tc := range testCases {
+		err = conn.ExecScript(`
+			DROP TABLE IF EXISTS batch_users;
+			CREATE TABLE batch_users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);
+		`)
+		assert.NoError(t, err)
+
+		rows := make([][]any, 0, tc.rows)
+		for i := range tc.rows {
+			rows = append(rows, []any{i + 1, fmt.Sprintf("user-%d", i+1), i})
+		}
+
+		result, err := conn.BatchInsert(ctx, `INSERT INTO batch_users (id, name, age) VALUES`, rows)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(tc.rows), result.RowsAffected)
+		assert.Equal(t, int64(tc.rows), result.LastInsertID)
+
+		stmt, err := conn.Prepare(ctx, `SELECT COUNT(*) AS count, MAX(name) AS name FROM batch_users WHERE id = ?;`, tc.rows)
+		assert.NoError(t, err)
+		_, err = stmt.Step()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), stmt.GetInt64("count"))
+		assert.Equal(t, fmt.Sprintf("user-%d", tc.rows), stmt.GetText("name"))
+		stmt.Reset()
+	}
+
+	_, err = conn.BatchInsert(ctx, `INSERT INTO batch_users (id, name, age) VALUES`, [][]any{{1, "a", 1}, {2, "b"}})
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
+
+func TestExecMany(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS many_users (id INTEGER PRIMARY KEY, name TEXT, score INTEGER);`)
+	assert.NoError(t, err)
+
+	argSets := make([][]any, 0, 1000)
+	for i := range 1000 {
+		argSets = append(argSets, []any{i + 1, fmt.Sprintf("user-%d", i+1)})
+	}
+
+	err = conn.ExecMany(ctx, `INSERT INTO many_users (id, name, score) VALUES (?, ?, 0);`, argSets)
+	assert.NoError(t, err)
+
+	updates := make([][]any, 0, 1000)
+	for i := range 1000 {
+		updates = append(updates, []any{i * 2, i + 1})
+	}
+
+	err = conn.ExecMany(ctx, `UPDATE many_users SET score = ? WHERE id = ?;`, updates)
+	assert.NoError(t, err)
+
+	stmt, err := conn.Prepare(ctx, `SELECT COUNT(*) AS count, SUM(score) AS total FROM many_users;`)
+	assert.NoError(t, err)
+	_, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), stmt.GetInt64("count"))
+	assert.Equal(t, int64(999*1000), stmt.GetInt64("total"))
+	stmt.Reset()
+
+	// a failing set rolls back the whole batch
+	err = conn.ExecMany(ctx, `INSERT INTO many_users (id, name, score) VALUES (?, ?, 0);`, [][]any{
+		{1001, "new"},
+		{1, "duplicate"},
+	})
+	assert.ErrorIs(t, err, sqlite.ErrExecSQL)
+
+	stmt, err = conn.Prepare(ctx, `SELECT COUNT(*) AS count FROM many_users;`)
+	assert.NoError(t, err)
+	_, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), stmt.GetInt64("count"))
+	stmt.Reset()
+}
+
+func TestQueryRow(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS row_users (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO row_users (id, name) VALUES (1, 'john');
+	`)
+	assert.NoError(t, err)
+
+	scanName := func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("name"), nil
+	}
+
+	name, err := sqlite.QueryRow(ctx, conn, scanName, `SELECT name FROM row_users WHERE id = ?;`, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "john", name)
+
+	name, err = sqlite.QueryRow(ctx, conn, scanName, `SELECT name FROM row_users WHERE id = ?;`, 2)
+	assert.ErrorIs(t, err, sqlite.ErrNotFound)
+	assert.True(t, sqlite.IsNotFound(err))
+	assert.Equal(t, "", name)
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS query_users (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO query_users (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c');
+	`)
+	assert.NoError(t, err)
+
+	var names []string
+	for stmt, err := range conn.Query(ctx, `SELECT name FROM query_users WHERE id > ? ORDER BY id;`, 1) {
+		assert.NoError(t, err)
+		names = append(names, stmt.GetText("name"))
+	}
+	assert.Equal(t, []string{"b", "c"}, names)
+
+	// breaking early resets the statement
+	for range conn.Query(ctx, `SELECT name FROM query_users;`) {
+		break
+	}
+
+	for _, err := range conn.Query(ctx, `SELECT name FROM table_does_not_exist;`) {
+		assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+	}
+}
+
+func TestQueryLogger(t *testing.T) {
+	ctx := context.Background()
+
+	type entry struct {
+		sql string
+		dur time.Duration
+		err error
+	}
+
+	var entries []entry
+
+	db, err := sqlite.New(
+		ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(1),
+		sqlite.WithQueryLogger(func(ctx context.Context, sql string, dur time.Duration, err error) {
+			entries = append(entries, entry{sql, dur, err})
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS logged_users (name TEXT);`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO logged_users (name) VALUES (?);`, "john")
+	assert.NoError(t, err)
+
+	for _, err := range conn.Query(ctx, `SELECT name FROM logged_users;`) {
+		assert.NoError(t, err)
+	}
+
+	err = conn.Exec(ctx, `INSERT INTO table_does_not_exist (name) VALUES (?);`, "john")
+	assert.Error(t, err)
+
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "INSERT INTO logged_users (name) VALUES ('john');", entries[1].sql)
+	assert.Equal(t, "SELECT name FROM logged_users;", entries[2].sql)
+	assert.NoError(t, entries[2].err)
+	assert.ErrorIs(t, entries[3].err, sqlite.ErrPrepareSQL)
+
+	for _, entry := range entries {
+		assert.GreaterOrEqual(t, entry.dur, time.Duration(0))
+	}
+}
+
+func TestCount(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS count_users (name TEXT, age INTEGER);
+		DELETE FROM count_users;
+		INSERT INTO count_users (name, age) VALUES ('john', 30), ('jane', 17), ('joe', 45);
+	`)
+	assert.NoError(t, err)
+
+	count, err := conn.Count(ctx, "count_users", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	count, err = conn.Count(ctx, "main.count_users", "age > ?", 18)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	_, err = conn.Count(ctx, "count_users; DROP TABLE count_users", "")
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
+
+func TestExists(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS exists_users (email TEXT);
+		DELETE FROM exists_users;
+		INSERT INTO exists_users (email) VALUES ('john@example.com');
+	`)
+	assert.NoError(t, err)
+
+	ok, err := conn.Exists(ctx, `SELECT 1 FROM exists_users WHERE email = ?;`, "john@example.com")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = conn.Exists(ctx, `SELECT 1 FROM exists_users WHERE email = ?`, "jane@example.com")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSavepoint(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS savepoint_items (name TEXT);
+		DELETE FROM savepoint_items;
+	`)
+	assert.NoError(t, err)
+
+	errInner := errors.New("inner failed")
+
+	inner := func() (err error) {
+		defer conn.Savepoint("inner")(&err)
+		assert.Equal(t, 2, conn.Depth())
+
+		err = conn.Exec(ctx, `INSERT INTO savepoint_items (name) VALUES (?);`, "inner")
+		if err != nil {
+			return err
+		}
+
+		return errInner
+	}
+
+	outer := func() (err error) {
+		defer conn.Savepoint("outer")(&err)
+		assert.Equal(t, 1, conn.Depth())
+
+		err = conn.Exec(ctx, `INSERT INTO savepoint_items (name) VALUES (?);`, "outer")
+		if err != nil {
+			return err
+		}
+
+		// the inner failure only undoes the inner insert
+		assert.ErrorIs(t, inner(), errInner)
+		assert.Equal(t, 1, conn.Depth())
+
+		return nil
+	}
+
+	assert.NoError(t, outer())
+	assert.Equal(t, 0, conn.Depth())
+
+	var names []string
+	for stmt, err := range conn.Query(ctx, `SELECT name FROM savepoint_items;`) {
+		assert.NoError(t, err)
+		names = append(names, stmt.GetText("name"))
+	}
+	assert.Equal(t, []string{"outer"}, names)
+
+	// a panic rolls back too
+	assert.Panics(t, func() {
+		var err error
+		defer conn.Savepoint("panicking")(&err)
+
+		_ = conn.Exec(ctx, `INSERT INTO savepoint_items (name) VALUES (?);`, "panic")
+		panic("boom")
+	})
+	assert.Equal(t, 0, conn.Depth())
+
+	count, err := conn.Count(ctx, "savepoint_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	var invalid error
+	conn.Savepoint("bad name")(&invalid)
+	assert.ErrorIs(t, invalid, sqlite.ErrPrepareSQL)
+}
+
+func TestSaveRollsBackOnPanic(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.Exec(ctx, `CREATE TABLE save_items (name TEXT);`)
+	assert.NoError(t, err)
+
+	insert := func(name string, fail bool) (err error) {
+		defer conn.Save()(&err)
+
+		err = conn.Exec(ctx, `INSERT INTO save_items (name) VALUES (?);`, name)
+		if err != nil {
+			return err
+		}
+
+		if fail {
+			panic("boom")
+		}
+
+		return nil
+	}
+
+	var recovered any
+	func() {
+		defer func() {
+			recovered = recover()
+		}()
+		_ = insert("panicking", true)
+	}()
+	assert.Equal(t, "boom", recovered)
+	assert.Equal(t, 0, conn.Depth())
+
+	assert.NoError(t, insert("committed", false))
+
+	var names []string
+	for stmt, err := range conn.Query(ctx, `SELECT name FROM save_items;`) {
+		assert.NoError(t, err)
+		names = append(names, stmt.GetText("name"))
+	}
+	assert.Equal(t, []string{"committed"}, names)
+}
+
+func TestWithoutForeignKeys(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithForeignKeys(true))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		DROP TABLE IF EXISTS fk_children;
+		DROP TABLE IF EXISTS fk_parents;
+		CREATE TABLE fk_parents (id INTEGER PRIMARY KEY);
+		CREATE TABLE fk_children (id INTEGER PRIMARY KEY, parent_id INTEGER NOT NULL REFERENCES fk_parents (id));
+	`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO fk_children (id, parent_id) VALUES (1, 1);`)
+	assert.Error(t, err)
+
+	err = conn.WithoutForeignKeys(func() error {
+		return conn.Exec(ctx, `INSERT INTO fk_children (id, parent_id) VALUES (1, 1);`)
+	})
+	assert.NoError(t, err)
+
+	foreignKeyViolations := func() int {
+		var count int
+		for _, err := range conn.Query(ctx, `PRAGMA foreign_key_check;`) {
+			assert.NoError(t, err)
+			count++
+		}
+		return count
+	}
+
+	enabled, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (int64, error) {
+		return stmt.ColumnInt64(0), nil
+	}, `PRAGMA foreign_keys;`)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), enabled)
+	assert.Equal(t, 1, foreignKeyViolations())
+
+	err = conn.Exec(ctx, `INSERT INTO fk_parents (id) VALUES (1);`)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, foreignKeyViolations())
+
+	t.Run("in transaction", func(t *testing.T) {
+		err := func() (err error) {
+			defer conn.Savepoint("fk_tx")(&err)
+
+			return conn.WithoutForeignKeys(func() error {
+				return nil
+			})
+		}()
+		assert.ErrorIs(t, err, sqlite.ErrForeignKeysInTx)
+	})
+}
+
+func TestWithForeignKeysDisabled(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithForeignKeys(false))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		DROP TABLE IF EXISTS fk_off_children;
+		DROP TABLE IF EXISTS fk_off_parents;
+		CREATE TABLE fk_off_parents (id INTEGER PRIMARY KEY);
+		CREATE TABLE fk_off_children (id INTEGER PRIMARY KEY, parent_id INTEGER NOT NULL REFERENCES fk_off_parents (id));
+	`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO fk_off_children (id, parent_id) VALUES (1, 1);`)
+	assert.NoError(t, err)
+
+	// off is also the default
+	defaultDB, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		defaultDB.Close()
+	})
+
+	defaultConn, err := defaultDB.Conn(ctx)
+	assert.NoError(t, err)
+	defer defaultConn.Done()
+
+	enabled, err := sqlite.QueryRow(ctx, defaultConn, func(stmt *sqlite.Stmt) (int64, error) {
+		return stmt.ColumnInt64(0), nil
+	}, `PRAGMA foreign_keys;`)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), enabled)
+}
+
+func TestWithTempTable(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	tempTables := func(conn *sqlite.Conn) int64 {
+		count, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (int64, error) {
+			return stmt.GetInt64("count"), nil
+		}, `SELECT COUNT(*) AS count FROM temp.sqlite_master WHERE type = 'table';`)
+		assert.NoError(t, err)
+		return count
+	}
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.WithTempTable(ctx, `CREATE TEMP TABLE scratch_ids (id INTEGER PRIMARY KEY);`, func() error {
+			err := conn.Exec(ctx, `INSERT INTO scratch_ids (id) VALUES (1), (2), (3);`)
+			if err != nil {
+				return err
+			}
+
+			count, err := conn.Count(ctx, "scratch_ids", "")
+			assert.Equal(t, int64(3), count)
+			return err
+		})
+	})
+	assert.NoError(t, err)
+
+	// the pool has a single connection, so this is the same one
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		assert.Equal(t, int64(0), tempTables(conn))
+
+		fail := errors.New("fail")
+		err := conn.WithTempTable(ctx, `CREATE TEMPORARY TABLE scratch_ids (id INTEGER PRIMARY KEY);`, func() error {
+			return fail
+		})
+		assert.ErrorIs(t, err, fail)
+		assert.Equal(t, int64(0), tempTables(conn))
+
+		assert.Panics(t, func() {
+			conn.WithTempTable(ctx, `CREATE TEMP TABLE scratch_ids (id INTEGER PRIMARY KEY);`, func() error {
+				panic("boom")
+			})
+		})
+		assert.Equal(t, int64(0), tempTables(conn))
+
+		// an existing temp table is not dropped
+		err = conn.Exec(ctx, `CREATE TEMP TABLE kept (id INTEGER);`)
+		assert.NoError(t, err)
+		err = conn.WithTempTable(ctx, `CREATE TEMP TABLE IF NOT EXISTS kept (id INTEGER);`, func() error {
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), tempTables(conn))
+
+		err = conn.WithTempTable(ctx, `CREATE TABLE scratch_ids (id INTEGER);`, func() error {
+			return nil
+		})
+		assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestRebind(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE rebind_items (name TEXT, qty INTEGER, price REAL, active INTEGER, data BLOB);`)
+	assert.NoError(t, err)
+
+	stmt, err := conn.Prepare(ctx, `INSERT INTO rebind_items (name, qty, price, active, data) VALUES (?, ?, ?, ?, ?);`)
+	assert.NoError(t, err)
+
+	argSets := [][]any{
+		{"apple", 3, 1.25, true, []byte{0x01}},
+		{"pear", int64(7), float32(0.5), false, nil},
+		{"plum", uint8(1), 2, true},
+	}
+	for _, args := range argSets {
+		err = conn.Rebind(stmt, args...)
+		assert.NoError(t, err)
+
+		_, err = stmt.Step()
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, stmt.Reset())
+
+	stmt, err = conn.Prepare(ctx, `SELECT name, qty, price, active, data FROM rebind_items ORDER BY rowid;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	rows, err := sqlite.ScanAllMaps(stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]any{
+		{"name": "apple", "qty": int64(3), "price": 1.25, "active": int64(1), "data": []byte{0x01}},
+		{"name": "pear", "qty": int64(7), "price": 0.5, "active": int64(0), "data": nil},
+		// bindings left out by the last call are cleared, not kept
+		{"name": "plum", "qty": int64(1), "price": 2.0, "active": int64(1), "data": nil},
+	}, rows)
+}
+
+func TestNumberedPlaceholders(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE numbered_pairs (a TEXT, b TEXT, n INTEGER);
+		INSERT INTO numbered_pairs (a, b, n) VALUES ('x', 'y', 1), ('y', 'x', 2), ('y', 'z', 3), ('x', 'x', 4);
+	`)
+	assert.NoError(t, err)
+
+	var ns []int64
+	for stmt, err := range conn.Query(ctx, `SELECT n FROM numbered_pairs WHERE a = ?1 OR b = ?1 ORDER BY n;`, "x") {
+		assert.NoError(t, err)
+		ns = append(ns, stmt.GetInt64("n"))
+	}
+	assert.Equal(t, []int64{1, 2, 4}, ns)
+
+	// ?2 is used before ?1, values still bind by number
+	count, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (int64, error) {
+		return stmt.GetInt64("count"), nil
+	}, `SELECT COUNT(*) AS count FROM numbered_pairs WHERE n > ?2 AND (a = ?1 OR b = ?1);`, "x", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	err = conn.Exec(ctx, `SELECT * FROM numbered_pairs WHERE a = ?1 OR b = ?3;`, "x", "y", "z")
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+	assert.ErrorContains(t, err, "?2 is not used")
+
+	err = conn.Exec(ctx, `SELECT * FROM numbered_pairs WHERE a = ?1 OR b = ?2;`, "x")
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+	assert.ErrorContains(t, err, "1 values for 2 numbered placeholders")
+}
+
+func TestExecReturning(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE returning_users (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			created_at INTEGER NOT NULL DEFAULT (unixepoch())
+		);
+	`)
+	assert.NoError(t, err)
+
+	before := time.Now().Add(-time.Second)
+
+	var ids []int64
+	var createdAt []time.Time
+	err = conn.ExecReturning(ctx, `INSERT INTO returning_users (name) VALUES (?), (?) RETURNING id, created_at;`, func(stmt *sqlite.Stmt) error {
+		ids = append(ids, stmt.GetInt64("id"))
+		createdAt = append(createdAt, sqlite.LoadTime(stmt, "created_at"))
+		return nil
+	}, "john", "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, ids)
+	assert.Len(t, createdAt, 2)
+	for _, at := range createdAt {
+		assert.WithinRange(t, at, before, time.Now())
+	}
+
+	// scan errors are returned
+	fail := errors.New("fail")
+	err = conn.ExecReturning(ctx, `UPDATE returning_users SET name = upper(name) RETURNING name;`, func(stmt *sqlite.Stmt) error {
+		return fail
+	})
+	assert.ErrorIs(t, err, fail)
+
+	var deleted []string
+	err = conn.ExecReturning(ctx, `DELETE FROM returning_users WHERE id = ? RETURNING name;`, func(stmt *sqlite.Stmt) error {
+		deleted = append(deleted, stmt.GetText("name"))
+		return nil
+	}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"JANE"}, deleted)
+}
+
+func TestUpsert(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE upsert_users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, name TEXT, logins INTEGER);`)
+	assert.NoError(t, err)
+
+	loadUser := func() (string, int64) {
+		stmt, err := conn.Prepare(ctx, `SELECT name, logins FROM upsert_users WHERE email = ?;`, "ada@example.com")
+		assert.NoError(t, err)
+		defer stmt.Reset()
+
+		hasRow, err := stmt.Step()
+		assert.NoError(t, err)
+		assert.True(t, hasRow)
+
+		return stmt.GetText("name"), stmt.GetInt64("logins")
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		result, err := conn.Upsert(ctx, "upsert_users", map[string]any{
+			"email":  "ada@example.com",
+			"name":   "Ada",
+			"logins": 1,
+		}, []string{"email"}, []string{"name", "logins"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), result.RowsAffected)
+		assert.Equal(t, int64(1), result.LastInsertID)
+
+		name, logins := loadUser()
+		assert.Equal(t, "Ada", name)
+		assert.Equal(t, int64(1), logins)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		result, err := conn.Upsert(ctx, "upsert_users", map[string]any{
+			"email":  "ada@example.com",
+			"name":   "Ada Lovelace",
+			"logins": 2,
+		}, []string{"email"}, []string{"logins"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), result.RowsAffected)
+
+		name, logins := loadUser()
+		assert.Equal(t, "Ada", name)
+		assert.Equal(t, int64(2), logins)
+
+		count, err := conn.Count(ctx, "upsert_users", "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("do nothing", func(t *testing.T) {
+		result, err := conn.Upsert(ctx, "upsert_users", map[string]any{
+			"email": "ada@example.com",
+			"name":  "Someone else",
+		}, []string{"email"}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), result.RowsAffected)
+
+		name, _ := loadUser()
+		assert.Equal(t, "Ada", name)
+	})
+
+	t.Run("invalid identifiers", func(t *testing.T) {
+		_, err := conn.Upsert(ctx, "upsert_users; DROP TABLE x", map[string]any{"email": "a"}, []string{"email"}, nil)
+		assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+		_, err = conn.Upsert(ctx, "upsert_users", map[string]any{"email = 1 --": "a"}, []string{"email"}, nil)
+		assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+		_, err = conn.Upsert(ctx, "upsert_users", map[string]any{"email": "a"}, []string{"email"}, []string{"name)"})
+		assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+	})
+}
+
+func TestBeginImmediate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(filepath.Join(t.TempDir(), "immediate.db")), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE immediate_counters (id INTEGER PRIMARY KEY, value INTEGER);
+			INSERT INTO immediate_counters (id, value) VALUES (1, 0);
+		`)
+	})
+	assert.NoError(t, err)
+
+	// read, wait a bit, then write: two deferred transactions doing this at
+	// once would fail with SQLITE_BUSY, immediate ones take turns
+	increment := func() (err error) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Done()
+
+		end, err := conn.BeginImmediate()
+		if err != nil {
+			return err
+		}
+		defer end(&err)
+
+		value, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (int64, error) {
+			return stmt.ColumnInt64(0), nil
+		}, `SELECT value FROM immediate_counters WHERE id = 1;`)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		return conn.Exec(ctx, `UPDATE immediate_counters SET value = ? WHERE id = 1;`, value+1)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = increment()
+		}()
+	}
+	wg.Wait()
+
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		value, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (int64, error) {
+			return stmt.ColumnInt64(0), nil
+		}, `SELECT value FROM immediate_counters WHERE id = 1;`)
+		assert.Equal(t, int64(2), value)
+		return err
+	})
+	assert.NoError(t, err)
+
+	t.Run("rollback", func(t *testing.T) {
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer conn.Done()
+
+		err = func() (err error) {
+			end, err := conn.BeginImmediate()
+			if err != nil {
+				return err
+			}
+			defer end(&err)
+
+			err = conn.Exec(ctx, `UPDATE immediate_counters SET value = 100 WHERE id = 1;`)
+			if err != nil {
+				return err
+			}
+
+			return errors.New("changed my mind")
+		}()
+		assert.EqualError(t, err, "changed my mind")
+
+		count, err := conn.Count(ctx, "immediate_counters", "value = 100")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+	})
+}
+
+func TestDeleteBatched(t *testing.T) {
+	ctx := context.Background()
+
+	var deletes int
+
+	db, err := sqlite.New(
+		ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(1),
+		sqlite.WithQueryLogger(func(ctx context.Context, sql string, dur time.Duration, err error) {
+			if strings.HasPrefix(sql, "DELETE") {
+				deletes++
+			}
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE events (id INTEGER PRIMARY KEY, kind TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 40)
+		INSERT INTO events (id, kind) SELECT i, CASE WHEN i <= 25 THEN 'old' ELSE 'new' END FROM n;
+	`)
+	assert.NoError(t, err)
+
+	total, err := conn.DeleteBatched(ctx, "events", "kind = ?", []any{"old"}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), total)
+	assert.Equal(t, 4, deletes) // 10, 10, 5 and the empty one that ends it
+
+	count, err := conn.Count(ctx, "events", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), count)
+
+	total, err = conn.DeleteBatched(ctx, "events", "kind = ?", []any{"old"}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+
+	total, err = conn.DeleteBatched(ctx, "events", "", nil, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), total)
+
+	_, err = conn.DeleteBatched(ctx, "events; DROP TABLE events", "", nil, 10)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	_, err = conn.DeleteBatched(ctx, "events", "", nil, 0)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"ella.to/logger"
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
@@ -28,10 +33,41 @@ type FunctionImpl = sqlite.FunctionImpl
 type Context = sqlite.Context
 type Value = sqlite.Value
 type AggregateFunction = sqlite.AggregateFunction
+type OpenFlags = sqlite.OpenFlags
+type Blob = sqlite.Blob
+type ColumnType = sqlite.ColumnType
+
+const (
+	TypeInteger = sqlite.TypeInteger
+	TypeFloat   = sqlite.TypeFloat
+	TypeText    = sqlite.TypeText
+	TypeBlob    = sqlite.TypeBlob
+	TypeNull    = sqlite.TypeNull
+)
+
+const (
+	OpenReadOnly  = sqlite.OpenReadOnly
+	OpenReadWrite = sqlite.OpenReadWrite
+	OpenCreate    = sqlite.OpenCreate
+	OpenURI       = sqlite.OpenURI
+	OpenMemory    = sqlite.OpenMemory
+	OpenNoMutex   = sqlite.OpenNoMutex
+	OpenFullMutex = sqlite.OpenFullMutex
+	OpenWAL       = sqlite.OpenWAL
+)
 
 type ConnPrepareFunc func(*Conn) error
 
+// QueryLoggerFunc receives every executed query, rendered with ShowSql,
+// with how long it took and the error it returned if any
+type QueryLoggerFunc func(ctx context.Context, sql string, dur time.Duration, err error)
+
 var IntegerValue = sqlite.IntegerValue
+var FloatValue = sqlite.FloatValue
+var TextValue = sqlite.TextValue
+
+// defaultPoolSize is the size sqlitex uses when no pool size is set
+const defaultPoolSize = 10
 
 func Sql(sql string, values ...any) string {
 	return fmt.Sprintf(sql, values...)
@@ -40,54 +76,306 @@ func Sql(sql string, values ...any) string {
 // Database struct which holds pool of connection
 type Database struct {
 	stringConn    string
-	pool          *sqlitex.Pool
+	pool          *pool
 	size          int
 	prepareConnFn ConnPrepareFunc
 	fns           map[string]*FunctionImpl
+	collations    map[string]func(a, b string) int
+	timeEncoding  TimeEncoding
+	queryLogger   QueryLoggerFunc
+	queryStats    QueryStatsFunc
+	attachments   []attachment
+	openFlags     OpenFlags
+	queryTimeout  time.Duration
+	checks        []func(*Conn) error
+	logger        *slog.Logger
+	metrics       MetricsCollector
+	tracer        Tracer
+
+	foreignKeys        bool
+	initScripts        []string
+	vfs                string
+	sharedCache        bool
+	autoCheckpoint     time.Duration
+	autoCheckpointMode CheckpointMode
+	maxConnLifetime    time.Duration
+	onConnError        func(error)
+	optimizeOnClose    bool
+	pageSize           int
+	autoVacuum         string
+	slowAcquire        time.Duration
+	readers            int
+	readPool           *pool
+
+	backgroundCtx    context.Context
+	cancelBackground context.CancelFunc
+	background       sync.WaitGroup
+
+	mu    sync.Mutex
+	inUse map[*Conn]struct{}
 }
 
 // Conn returns one connection from connection pool
 // NOTE: make sure to call Done() to put the connection back to the pool
 // usually right after this call, you should call defer conn.Done()
 func (db *Database) Conn(ctx context.Context) (*Conn, error) {
-	conn, err := db.pool.Take(ctx)
+	return db.ConnLabeled(ctx, "")
+}
+
+// ConnLabeled works like Conn but tags the connection with a label describing
+// what it's used for, e.g. "order-service.place". The label shows up in Stats
+// and in the warning about connections that were never returned, which makes
+// a leak easy to track down.
+func (db *Database) ConnLabeled(ctx context.Context, label string) (*Conn, error) {
+	return db.take(ctx, db.pool, label)
+}
+
+// take gets a connection from p, which is the pool or the read pool
+func (db *Database) take(ctx context.Context, p *pool, label string) (*Conn, error) {
+	start := time.Now()
+	conn, err := p.Take(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Conn{
-		conn: conn,
-		put:  db.put,
-	}, nil
+	wait := time.Since(start)
+	slow := db.slowAcquire > 0 && wait > db.slowAcquire
+	if slow {
+		db.log().WarnContext(ctx, "slow connection acquire", "wait", wait, "label", label, "poolSize", db.size)
+	}
+	db.metrics.ConnAcquired(wait, slow)
+
+	c := &Conn{
+		conn:    conn,
+		db:      db,
+		put:     func(c *Conn) { db.put(p, c) },
+		label:   label,
+		takenAt: time.Now(),
+	}
+
+	db.mu.Lock()
+	db.inUse[c] = struct{}{}
+	inUse := len(db.inUse)
+	db.mu.Unlock()
+
+	db.metrics.PoolSnapshot(inUse, db.size)
+
+	return c, nil
+}
+
+// Ping checks that the pool can hand out a working connection by running
+// SELECT 1 on it. It waits for a free connection only as long as ctx allows,
+// so a readiness probe with a short deadline fails fast when the pool is
+// exhausted.
+func (db *Database) Ping(ctx context.Context) error {
+	conn, err := db.ConnLabeled(ctx, "ping")
+	if err != nil {
+		return err
+	}
+	defer conn.Done()
+
+	return conn.Exec(ctx, `SELECT 1;`)
 }
 
+// ConnStats describes a connection that is currently checked out of the pool
+type ConnStats struct {
+	Label   string
+	TakenAt time.Time
+}
+
+type Stats struct {
+	Size  int
+	InUse []ConnStats
+}
+
+// Stats returns the pool size and the connections that are checked out right
+// now, oldest first
+func (db *Database) Stats() Stats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stats := Stats{
+		Size:  db.size,
+		InUse: make([]ConnStats, 0, len(db.inUse)),
+	}
+
+	for conn := range db.inUse {
+		stats.InUse = append(stats.InUse, ConnStats{
+			Label:   conn.label,
+			TakenAt: conn.takenAt,
+		})
+	}
+
+	sort.Slice(stats.InUse, func(i, j int) bool {
+		return stats.InUse[i].TakenAt.Before(stats.InUse[j].TakenAt)
+	})
+
+	return stats
+}
+
+// Exec takes a connection, runs fn with it and puts it back to the pool.
+// The ctx passed to fn carries the connection (see WithConn), so a nested
+// Exec on the same database reuses it instead of waiting for a second one,
+// which would deadlock once the pool is exhausted.
 func (db *Database) Exec(ctx context.Context, fn func(ctx context.Context, conn *Conn) error) error {
+	if conn, ok := ConnFromContext(ctx); ok && conn.db == db {
+		return fn(ctx, conn)
+	}
+
 	conn, err := db.Conn(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Done()
 
-	return fn(ctx, conn)
+	return fn(WithConn(ctx, conn), conn)
 }
 
-func (db *Database) put(conn *Conn) {
-	db.pool.Put(conn.conn)
+// ExecTx works like Exec but runs fn within a savepoint, which is released
+// if fn returns nil and rolled back if it returns an error or panics. Nested
+// calls reuse the connection and get their own savepoint, so an inner
+// failure only undoes the inner work when the outer fn handles the error.
+func (db *Database) ExecTx(ctx context.Context, fn func(ctx context.Context, conn *Conn) error) error {
+	return db.Exec(ctx, func(ctx context.Context, conn *Conn) (err error) {
+		defer conn.Save()(&err)
+		return fn(ctx, conn)
+	})
+}
+
+type connCtxKey struct{}
+
+// WithConn returns a copy of ctx that carries conn, so helpers deep in the
+// stack can get it back with ConnFromContext instead of taking a *Conn
+// argument. Exec and ExecTx do it for the ctx they pass to fn. The connection
+// is only valid as long as the caller holds it, that is within the fn of Exec
+// or ExecTx, don't keep the returned context around after calling
+// conn.Done().
+func WithConn(ctx context.Context, conn *Conn) context.Context {
+	return context.WithValue(ctx, connCtxKey{}, conn)
+}
+
+// ConnFromContext returns the connection stored by WithConn, if any
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	conn, ok := ctx.Value(connCtxKey{}).(*Conn)
+	return conn, ok
+}
+
+func (db *Database) put(p *pool, conn *Conn) {
+	db.mu.Lock()
+	delete(db.inUse, conn)
+	inUse := len(db.inUse)
+	db.mu.Unlock()
+
+	p.Put(conn.conn)
+	db.metrics.PoolSnapshot(inUse, db.size)
 }
 
 // Close closes all the connections in the pool
 // and returns error if any connection fails to close
 // NOTE: make sure to call this function at the end of your application
+//
+// Close waits for every connection to be returned, the ones that are still
+// checked out are logged with their label. Calling it again returns an
+// error.
 func (db *Database) Close() error {
+	if db.pool.IsClosed() {
+		return db.pool.Close()
+	}
+
+	for _, conn := range db.Stats().InUse {
+		db.log().WarnContext(
+			context.Background(),
+			"connection not returned to the pool",
+			"label", conn.Label,
+			"held_for", time.Since(conn.TakenAt).String(),
+		)
+	}
+
+	db.stopBackground()
+
+	if db.optimizeOnClose && db.openFlags&OpenReadOnly == 0 {
+		err := db.Optimize(context.Background())
+		if err != nil {
+			db.log().Warn("failed to optimize database on close", "error", err)
+		}
+	}
+
+	if db.readPool != nil {
+		if err := db.readPool.Close(); err != nil {
+			db.pool.Close()
+			return err
+		}
+	}
+
 	return db.pool.Close()
 }
 
+// goBackground runs fn in a goroutine until Close, the context passed to fn
+// is canceled when Close is called and Close waits for fn to return
+func (db *Database) goBackground(fn func(ctx context.Context)) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.cancelBackground == nil {
+		db.backgroundCtx, db.cancelBackground = context.WithCancel(context.Background())
+	}
+
+	db.background.Add(1)
+	go func() {
+		defer db.background.Done()
+		fn(db.backgroundCtx)
+	}()
+}
+
+func (db *Database) stopBackground() {
+	db.mu.Lock()
+	cancel := db.cancelBackground
+	db.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		db.background.Wait()
+	}
+}
+
+// log returns the logger set by WithLogger, slog.Default() otherwise
+func (db *Database) log() *slog.Logger {
+	if db.logger != nil {
+		return db.logger
+	}
+	return slog.Default()
+}
+
 type OptionFunc func(context.Context, *Database) error
 
+// WithLogger routes the package's logs, e.g. prepared sql at debug level or
+// connections that were never returned to the pool, to l instead of
+// slog.Default(). Set it first so the other options log to it as well.
+func WithLogger(l *slog.Logger) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.logger = l
+		return nil
+	}
+}
+
+// memoryDatabases numbers the in-memory databases opened by WithMemory and
+// WithAttach
+var memoryDatabases atomic.Int64
+
+// WithMemory opens an in-memory database. Every Database gets its own one,
+// named uniquely, and all its pooled connections share it. It always uses a
+// shared cache, whatever WithSharedCache says, since that's the only way for
+// the connections to see the same in-memory database.
 func WithMemory() OptionFunc {
-	return WithStringConn("file::memory:?mode=memory&cache=shared")
+	return func(ctx context.Context, db *Database) error {
+		name := fmt.Sprintf("memdb-%d", memoryDatabases.Add(1))
+		return WithStringConn("file:"+name+"?mode=memory&cache=shared")(ctx, db)
+	}
 }
 
+// WithFile opens the database file at path, creating it and its parent
+// directories if needed. Every connection has its own private cache unless
+// WithSharedCache(true) is given.
 func WithFile(path string) OptionFunc {
 	return func(ctx context.Context, db *Database) error {
 		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
@@ -95,14 +383,30 @@ func WithFile(path string) OptionFunc {
 			return err
 		}
 
-		return WithStringConn("file:"+path+"?cache=shared")(ctx, db)
+		return WithDSN(DSNOptions{Path: path})(ctx, db)
+	}
+}
+
+// WithSharedCache makes the pooled connections share one page cache, by adding
+// cache=shared to the connection URI unless it already sets a cache mode.
+// It's off by default.
+//
+// Shared cache is discouraged with WAL: connections sharing a cache take
+// table level locks on each other instead of relying on WAL's snapshots, so
+// a reader can fail with SQLITE_LOCKED while a writer holds the table, where
+// private caches would let both run concurrently. It only pays off when
+// memory is tight. WithMemory always uses a shared cache.
+func WithSharedCache(enabled bool) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.sharedCache = enabled
+		return nil
 	}
 }
 
 func WithStringConn(stringConn string) OptionFunc {
 	return func(ctx context.Context, db *Database) error {
 		if db.stringConn != "" {
-			logger.Warn(ctx, "stringConn changed", "old", db.stringConn, "new", stringConn)
+			db.log().WarnContext(ctx, "stringConn changed", "old", db.stringConn, "new", stringConn)
 		}
 		db.stringConn = stringConn
 		return nil
@@ -116,6 +420,22 @@ func WithPoolSize(size int) OptionFunc {
 	}
 }
 
+// WithMaxConnLifetime closes a connection that has been open for longer than
+// d when it's returned to the pool with Done, and opens a fresh one in its
+// place, like SetConnMaxLifetime of database/sql. Whatever state the old
+// connection had, e.g. temp tables or cached statements, is gone. Zero, the
+// default, keeps connections open until Close.
+func WithMaxConnLifetime(d time.Duration) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.maxConnLifetime = d
+		return nil
+	}
+}
+
+// WithConnPrepareFunc sets fn to run on every connection the pool opens, so it
+// runs as many times as there are connections. Use it for per connection
+// state such as pragmas, and WithInitScript for one time schema setup or
+// seeding.
 func WithConnPrepareFunc(fn ConnPrepareFunc) OptionFunc {
 	return func(ctx context.Context, db *Database) error {
 		db.prepareConnFn = ConnPrepareFunc(fn)
@@ -123,6 +443,59 @@ func WithConnPrepareFunc(fn ConnPrepareFunc) OptionFunc {
 	}
 }
 
+// WithOnConnError sets fn to be called whenever a pooled connection fails to
+// be prepared, e.g. a failing WithConnPrepareFunc or attachment, with the
+// error it failed with before it's evicted and replaced by a fresh one, or
+// can't be replaced once it reached its lifetime (see WithMaxConnLifetime).
+// The error is still returned to whoever asked for the connection, fn is
+// meant for logging and alerting. It must not use the database.
+func WithOnConnError(fn func(err error)) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.onConnError = fn
+		return nil
+	}
+}
+
+// WithOptimizeOnClose runs PRAGMA optimize, see Database.Optimize, on one
+// connection when the database is closed, as SQLite recommends for long
+// running applications. A failure is logged and doesn't stop Close. It's
+// skipped for read-only databases.
+func WithOptimizeOnClose() OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.optimizeOnClose = true
+		return nil
+	}
+}
+
+// WithInitScript runs sql once, on a single connection, right after the pool
+// is created and before New returns. Its statements run one at a time within
+// a savepoint like RunScript, so a failing script leaves nothing behind and
+// New returns its error. Unlike WithConnPrepareFunc, it doesn't run again for
+// the other connections of the pool. Init scripts run in the order they are
+// given.
+func WithInitScript(sql string) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.initScripts = append(db.initScripts, sql)
+		return nil
+	}
+}
+
+// WithInitScriptFS is like WithInitScript but reads the script from the file
+// at path in fsys, for example an embed.FS.
+func WithInitScriptFS(fsys fs.FS, path string) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		db.initScripts = append(db.initScripts, string(content))
+		return nil
+	}
+}
+
+// WithFunctions registers custom SQL functions, keyed by name, on every
+// pooled connection before the function set by WithConnPrepareFunc runs.
+// Set Scalar for a scalar function or MakeAggregate for an aggregate one.
 func WithFunctions(fns map[string]*FunctionImpl) OptionFunc {
 	return func(ctx context.Context, db *Database) error {
 		db.fns = fns
@@ -130,16 +503,226 @@ func WithFunctions(fns map[string]*FunctionImpl) OptionFunc {
 	}
 }
 
+// WithCollation registers a collating sequence called name on every pooled
+// connection, next to the functions of WithFunctions, so it can be used
+// anywhere in the pool:
+//
+//	SELECT name FROM users ORDER BY name COLLATE unicode_nocase;
+//
+// cmp returns a negative number if a sorts before b, a positive one if after
+// and zero if they are equal, and must always give the same answer for the
+// same strings. Indexes and columns declared with the collation need it
+// registered on every connection that touches them. It can be given several
+// times for different names.
+func WithCollation(name string, cmp func(a, b string) int) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if db.collations == nil {
+			db.collations = make(map[string]func(a, b string) int)
+		}
+		db.collations[name] = cmp
+		return nil
+	}
+}
+
+// WithTimeEncoding sets how time.Time values are bound. The default is
+// TimeSeconds. Use LoadTimeAs with the same encoding to read them back.
+func WithTimeEncoding(enc TimeEncoding) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.timeEncoding = enc
+		return nil
+	}
+}
+
+// WithQueryLogger calls fn after each query run through Conn.Exec,
+// Conn.ExecMany, Conn.Query and QueryRow, regardless of the slog level.
+// Use it to build slow query logs or feed your own tracing.
+func WithQueryLogger(fn QueryLoggerFunc) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.queryLogger = fn
+		return nil
+	}
+}
+
+// QueryStats splits the time a query took between preparing its statement,
+// binding included, and stepping through it. For Conn.Query, stepping
+// includes the time spent in the loop body.
+type QueryStats struct {
+	SQL             string // rendered with ShowSql
+	PrepareDuration time.Duration
+	StepDuration    time.Duration
+	Err             error
+}
+
+// QueryStatsFunc receives the QueryStats of every executed query
+type QueryStatsFunc func(ctx context.Context, stats QueryStats)
+
+// WithQueryStats calls fn after each query run through Conn.Exec,
+// Conn.ExecMany, Conn.Query and QueryRow like WithQueryLogger, but with the
+// prepare and step phases timed apart, which tells whether a slow query is
+// slow to compile or to run. Both can be set.
+func WithQueryStats(fn QueryStatsFunc) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.queryStats = fn
+		return nil
+	}
+}
+
+// WithQueryTimeout bounds every Conn.Exec, Conn.ExecMany, Conn.Query and
+// QueryRow call to d, unless the passed context already has an earlier
+// deadline. A query running past it is interrupted and its error wraps
+// context.DeadlineExceeded. Statements returned by Prepare are stepped by the
+// caller and aren't covered. Zero, the default, disables the timeout.
+func WithQueryTimeout(d time.Duration) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.queryTimeout = d
+		return nil
+	}
+}
+
+// WithOpenFlags sets the flags every pooled connection is opened with,
+// instead of the default OpenReadWrite|OpenCreate|OpenWAL|OpenURI. OpenURI is
+// added when needed by WithMemory and WithFile, which use URI filenames.
+//
+// With OpenReadOnly the WAL journal mode and foreign keys pragmas are not
+// set, since a read-only connection can't change the journal mode; an
+// existing database already in WAL mode stays that way. OpenReadOnly can't
+// be combined with OpenReadWrite or OpenCreate, nor with WithMemory as the
+// database would always be empty.
+func WithOpenFlags(flags OpenFlags) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if flags&OpenReadOnly != 0 && flags&(OpenReadWrite|OpenCreate) != 0 {
+			return fmt.Errorf("open flags: read-only can't be combined with read-write or create")
+		}
+
+		db.openFlags = flags
+		return nil
+	}
+}
+
+// WithReadOnlyFile opens the existing database at path read-only, e.g. a
+// pre-built database shipped with the application. Unlike WithFile, no
+// directory is created and it fails if the file doesn't exist instead of
+// creating an empty database. Any write returns an SQLITE_READONLY error.
+func WithReadOnlyFile(path string) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("read-only database: %w", err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("read-only database: %s is a directory", path)
+		}
+
+		err = WithDSN(DSNOptions{Path: path, Mode: "ro"})(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		return WithOpenFlags(OpenReadOnly)(ctx, db)
+	}
+}
+
+// WithForeignKeys turns foreign key enforcement on or off for every pooled
+// connection. It's off by default, like in SQLite itself. See
+// Conn.WithoutForeignKeys to turn it off only for a while.
+func WithForeignKeys(enabled bool) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.foreignKeys = enabled
+		return nil
+	}
+}
+
+// WithPageSize sets the page size, in bytes, of a new database. It must be a
+// power of two between 512 and 65536. SQLite only applies it before the
+// first write, and never once the database is in WAL mode, so it has no
+// effect on an existing database short of a VACUUM in rollback journal mode.
+func WithPageSize(bytes int) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if bytes < 512 || bytes > 65536 || bytes&(bytes-1) != 0 {
+			return fmt.Errorf("page size must be a power of two between 512 and 65536, got %d", bytes)
+		}
+		db.pageSize = bytes
+		return nil
+	}
+}
+
+// AutoVacuumMode selects how SQLite gives back the pages freed by deletes,
+// see WithAutoVacuum
+type AutoVacuumMode int
+
+const (
+	AutoVacuumNone        AutoVacuumMode = iota // freed pages are reused but the file never shrinks
+	AutoVacuumFull                              // the file is truncated on every commit
+	AutoVacuumIncremental                       // freed pages are kept until PRAGMA incremental_vacuum
+)
+
+func (mode AutoVacuumMode) String() string {
+	switch mode {
+	case AutoVacuumNone:
+		return "NONE"
+	case AutoVacuumFull:
+		return "FULL"
+	case AutoVacuumIncremental:
+		return "INCREMENTAL"
+	default:
+		return fmt.Sprintf("AutoVacuumMode(%d)", int(mode))
+	}
+}
+
+// WithAutoVacuum sets the auto_vacuum mode of a new database. SQLite only
+// applies it before the first table is created; switching an existing
+// database to or from AutoVacuumNone takes a VACUUM.
+func WithAutoVacuum(mode AutoVacuumMode) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		switch mode {
+		case AutoVacuumNone, AutoVacuumFull, AutoVacuumIncremental:
+		default:
+			return fmt.Errorf("unknown auto vacuum mode %s", mode)
+		}
+		db.autoVacuum = mode.String()
+		return nil
+	}
+}
+
+type attachment struct {
+	schema string
+	path   string
+}
+
+// WithAttach attaches the database at path to every pooled connection under
+// schemaName, so its tables can be used as schemaName.table in queries and
+// joined with the main database. The attachment happens before the function
+// set by WithConnPrepareFunc runs, so it can reference the attached schema.
+//
+// ":memory:" would give each connection its own private database, so it is
+// turned into a shared in-memory database, named uniquely like WithMemory
+// does, which all connections of the pool see and no other Database does.
+func WithAttach(schemaName, path string) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if !isIdentifierPart(schemaName) {
+			return fmt.Errorf("invalid attach schema name %q", schemaName)
+		}
+
+		if path == ":memory:" {
+			path = fmt.Sprintf("file:memdb-%d?mode=memory&cache=shared", memoryDatabases.Add(1))
+		} else if !strings.HasPrefix(path, "file:") {
+			err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+			if err != nil {
+				return err
+			}
+		}
+
+		db.attachments = append(db.attachments, attachment{schema: schemaName, path: path})
+		return nil
+	}
+}
+
 // New creates a sqlite database
 func New(ctx context.Context, opts ...OptionFunc) (*Database, error) {
-	pragma := strings.TrimSpace(`
-		PRAGMA foreign_keys = ON;
-		PRAGMA journal_mode = WAL;
-		PRAGMA cache_size = -2000;  -- Use negative value for KB size (here, 2MB)
-		PRAGMA temp_store = MEMORY;
-	`)
-
-	db := &Database{}
+	db := &Database{
+		inUse:   make(map[*Conn]struct{}),
+		metrics: NoopMetrics{},
+	}
 	for _, opt := range opts {
 		err := opt(ctx, db)
 		if err != nil {
@@ -147,24 +730,88 @@ func New(ctx context.Context, opts ...OptionFunc) (*Database, error) {
 		}
 	}
 
-	pool, err := sqlitex.NewPool(
+	if db.vfs != "" {
+		err := checkVFS(db.vfs)
+		if err != nil {
+			return nil, err
+		}
+		db.stringConn = withURIParam(db.stringConn, "vfs", db.vfs)
+	}
+
+	if db.sharedCache {
+		db.stringConn = withURIParam(db.stringConn, "cache", "shared")
+	}
+
+	foreignKeys := `PRAGMA foreign_keys = OFF;`
+	if db.foreignKeys {
+		foreignKeys = `PRAGMA foreign_keys = ON;`
+	}
+
+	// NOTE: pragmas run one by one outside of any transaction, foreign_keys
+	// and journal_mode are silently ignored inside one (ExecScript wraps
+	// the script in a savepoint)
+	pragmas := []string{
+		foreignKeys,
+		`PRAGMA journal_mode = WAL;`,
+		`PRAGMA cache_size = -2000;`, // Use negative value for KB size (here, 2MB)
+		`PRAGMA temp_store = MEMORY;`,
+	}
+
+	// page_size and auto_vacuum only take effect on an empty database, and
+	// page_size not at all once it's in WAL mode, so they go first
+	if db.autoVacuum != "" {
+		pragmas = slices.Insert(pragmas, 0, "PRAGMA auto_vacuum = "+db.autoVacuum+";")
+	}
+	if db.pageSize != 0 {
+		pragmas = slices.Insert(pragmas, 0, fmt.Sprintf("PRAGMA page_size = %d;", db.pageSize))
+	}
+
+	if db.openFlags != 0 && strings.HasPrefix(db.stringConn, "file:") {
+		db.openFlags |= OpenURI
+	}
+
+	flags := db.openFlags
+	if flags == 0 {
+		flags = OpenReadWrite | OpenCreate | OpenWAL | OpenURI
+	}
+
+	if db.size < 1 {
+		db.size = defaultPoolSize
+	}
+
+	// OpenWAL switches to WAL as the connection opens, before page_size and
+	// auto_vacuum had a chance to run, the journal_mode pragma does it instead
+	if (db.pageSize != 0 || db.autoVacuum != "") && flags&OpenWAL != 0 {
+		flags &^= OpenWAL
+		db.openFlags = flags
+	}
+
+	err := validateDSN(db.stringConn, flags, db.size)
+	if err != nil {
+		return nil, err
+	}
+
+	// a mode=ro connection can't set the WAL journal mode, it's opened like
+	// WithReadOnlyFile does so the pragmas that need writing are skipped
+	if uriParam(db.stringConn, "mode") == "ro" && flags&OpenReadOnly == 0 {
+		db.log().WarnContext(ctx, "mode=ro connection string without OpenReadOnly, opening read-only", "stringConn", db.stringConn)
+		db.openFlags = OpenReadOnly | OpenURI
+	}
+
+	if db.openFlags&OpenReadOnly != 0 {
+		pragmas = readOnlyPragmas(pragmas)
+	}
+
+	pool, err := newPool(
 		db.stringConn,
 		sqlitex.PoolOptions{
-			Flags:    0,
-			PoolSize: db.size,
-			PrepareConn: func(conn *sqlite.Conn) error {
-				err := sqlitex.ExecScript(conn, pragma)
-				if err != nil {
-					return err
-				}
-
-				if db.prepareConnFn != nil {
-					return db.prepareConnFn(&Conn{conn: conn, put: func(conn *Conn) {}})
-				}
-
-				return nil
-			},
+			Flags:       db.openFlags,
+			PoolSize:    db.size,
+			PrepareConn: db.prepareConn(pragmas),
 		},
+		db.maxConnLifetime,
+		db.log,
+		db.connError,
 	)
 	if err != nil {
 		return nil, err
@@ -172,17 +819,163 @@ func New(ctx context.Context, opts ...OptionFunc) (*Database, error) {
 
 	db.pool = pool
 
+	err = db.runChecks(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	for _, script := range db.initScripts {
+		err = RunScript(ctx, db, script)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	if db.readers > 0 {
+		db.readPool, err = newPool(
+			db.stringConn,
+			sqlitex.PoolOptions{
+				Flags:       OpenReadOnly | OpenURI,
+				PoolSize:    db.readers,
+				PrepareConn: db.prepareConn(readOnlyPragmas(pragmas)),
+			},
+			db.maxConnLifetime,
+			db.log,
+			db.connError,
+		)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("read pool: %w", err)
+		}
+	}
+
+	if db.autoCheckpoint > 0 {
+		err = db.startAutoCheckpoint(ctx)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
 
-func RunScript(ctx context.Context, db *Database, sql string) error {
+// prepareConn returns the function preparing every new connection of a pool:
+// pragmas, attachments, functions, collations and WithConnPrepareFunc
+func (db *Database) prepareConn(pragmas []string) sqlitex.ConnPrepareFunc {
+	return func(conn *sqlite.Conn) error {
+		for _, pragma := range pragmas {
+			err := sqlitex.ExecuteTransient(conn, pragma, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, attachment := range db.attachments {
+			err := sqlitex.ExecuteTransient(conn, `ATTACH DATABASE ? AS ?;`, &sqlitex.ExecOptions{
+				Args: []any{attachment.path, attachment.schema},
+			})
+			if err != nil {
+				return fmt.Errorf("attach %s: %w", attachment.schema, err)
+			}
+		}
+
+		for name, fn := range db.fns {
+			err := conn.CreateFunction(name, fn)
+			if err != nil {
+				return err
+			}
+		}
+
+		for name, cmp := range db.collations {
+			err := conn.SetCollation(name, cmp)
+			if err != nil {
+				return fmt.Errorf("collation %s: %w", name, err)
+			}
+		}
+
+		if db.prepareConnFn != nil {
+			return db.prepareConnFn(&Conn{conn: conn, db: db, put: func(conn *Conn) {}})
+		}
+
+		return nil
+	}
+}
+
+// readOnlyPragmas drops the pragmas a read-only connection can't run: nothing
+// is ever written, journal_mode, page_size and auto_vacuum can't be changed
+// and there are no foreign keys to enforce
+func readOnlyPragmas(pragmas []string) []string {
+	return slices.DeleteFunc(slices.Clone(pragmas), func(pragma string) bool {
+		return strings.HasPrefix(pragma, "PRAGMA journal_mode") ||
+			strings.HasPrefix(pragma, "PRAGMA foreign_keys") ||
+			strings.HasPrefix(pragma, "PRAGMA page_size") ||
+			strings.HasPrefix(pragma, "PRAGMA auto_vacuum")
+	})
+}
+
+// connError calls the callback set by WithOnConnError, if any
+func (db *Database) connError(err error) {
+	if db.onConnError != nil {
+		db.onConnError(err)
+	}
+}
+
+// runChecks runs the checks registered by options, e.g. WithJSONFunctions,
+// on one connection of the pool
+func (db *Database) runChecks(ctx context.Context) error {
+	if len(db.checks) == 0 {
+		return nil
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Done()
+
+	for _, check := range db.checks {
+		err = check(conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunScript runs every statement of sql, see SplitScript, one at a time
+// within a savepoint, so either all of them apply or none. It stops between
+// statements once ctx is done, and a statement still running at that point
+// is interrupted.
+func RunScript(ctx context.Context, db *Database, sql string) (err error) {
+	stmts, err := SplitScript(sql)
+	if err != nil {
+		return err
+	}
+
 	conn, err := db.Conn(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Done()
 
-	return sqlitex.ExecScript(conn.conn, sql)
+	defer conn.Save()(&err)
+
+	for _, stmt := range stmts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err = conn.Exec(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func RunScriptFiles(ctx context.Context, db *Database, path string) error {
@@ -224,3 +1017,47 @@ func RunScriptFiles(ctx context.Context, db *Database, path string) error {
 
 	return nil
 }
+
+// RunScriptTree runs every .sql file under root, subdirectories included, one
+// script at a time in lexical order of their path relative to root, e.g.
+// 01_schema.sql, 02_seed/01_users.sql, 02_seed/02_orders.sql, 03_views.sql.
+func RunScriptTree(ctx context.Context, db *Database, root string) error {
+	var sqlFiles []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(path) != ".sql" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		sqlFiles = append(sqlFiles, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(sqlFiles)
+
+	for _, sqlFile := range sqlFiles {
+		sql, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(sqlFile)))
+		if err != nil {
+			return err
+		}
+
+		err = RunScript(ctx, db, string(sql))
+		if err != nil {
+			return fmt.Errorf("%s: %w", sqlFile, err)
+		}
+	}
+
+	return nil
+}
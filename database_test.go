@@ -0,0 +1,940 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+// countCtxItems stands for a helper deep in the stack that only gets a ctx
+func countCtxItems(ctx context.Context) (int64, bool, error) {
+	conn, ok := sqlite.ConnFromContext(ctx)
+	if !ok {
+		return 0, false, nil
+	}
+
+	count, err := conn.Count(ctx, "ctx_items", "")
+	return count, true, err
+}
+
+func TestConnFromContext(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	_, ok, err := countCtxItems(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	err = db.ExecTx(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		got, ok := sqlite.ConnFromContext(ctx)
+		assert.True(t, ok)
+		assert.Same(t, conn, got)
+
+		err := conn.ExecScript(`
+			CREATE TABLE ctx_items (name TEXT);
+			INSERT INTO ctx_items (name) VALUES ('a'), ('b');
+		`)
+		if err != nil {
+			return err
+		}
+
+		// the helper runs on the same connection, so it sees the rows that
+		// aren't committed yet
+		count, ok, err := countCtxItems(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, int64(2), count)
+		return err
+	})
+	assert.NoError(t, err)
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	count, ok, err := countCtxItems(sqlite.WithConn(ctx, conn))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestExecReusesConnFromContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`CREATE TABLE IF NOT EXISTS reuse_items (name TEXT);`)
+	})
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, readConn *sqlite.Conn) error {
+		stmt, err := readConn.Prepare(ctx, `SELECT COUNT(*) AS count FROM reuse_items;`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Reset()
+
+		_, err = stmt.Step()
+		if err != nil {
+			return err
+		}
+
+		// the only connection of the pool is held here, a nested Exec must reuse it
+		return db.Exec(ctx, func(ctx context.Context, writeConn *sqlite.Conn) error {
+			assert.Same(t, readConn, writeConn)
+			return writeConn.Exec(ctx, `INSERT INTO reuse_items (name) VALUES (?);`, "item")
+		})
+	})
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		stmt, err := conn.Prepare(ctx, `SELECT COUNT(*) AS count FROM reuse_items;`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Reset()
+
+		_, err = stmt.Step()
+		assert.Equal(t, int64(1), stmt.GetInt64("count"))
+		return err
+	})
+	assert.NoError(t, err)
+}
+
+func TestConnLabeled(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	stats := db.Stats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Empty(t, stats.InUse)
+
+	leaked, err := db.ConnLabeled(ctx, "order-service.place")
+	assert.NoError(t, err)
+	assert.Equal(t, "order-service.place", leaked.Label())
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	conn.Done()
+
+	stats = db.Stats()
+	assert.Len(t, stats.InUse, 1)
+	assert.Equal(t, "order-service.place", stats.InUse[0].Label)
+	assert.False(t, stats.InUse[0].TakenAt.IsZero())
+
+	leaked.Done()
+	assert.Empty(t, db.Stats().InUse)
+}
+
+// syncBuffer is a bytes.Buffer safe to log to from one goroutine while the
+// test reads it from another
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCloseTwice(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithReadWriteSplit(1), sqlite.WithOptimizeOnClose())
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Close())
+	assert.ErrorContains(t, db.Close(), "already closed")
+
+	_, err = db.Conn(ctx)
+	assert.Error(t, err)
+}
+
+func TestCloseReportsLeakedLabels(t *testing.T) {
+	ctx := context.Background()
+
+	var logs syncBuffer
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(2),
+		sqlite.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	assert.NoError(t, err)
+
+	leaked, err := db.ConnLabeled(ctx, "order-service.place")
+	assert.NoError(t, err)
+
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+
+	// Close reports the connection it's waiting for, with its label
+	for !strings.Contains(logs.String(), "connection not returned to the pool") {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Contains(t, logs.String(), "label=order-service.place")
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the connection was")
+	default:
+	}
+
+	leaked.Done()
+	assert.NoError(t, <-closed)
+}
+
+func TestWithAttach(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(
+		ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(2),
+		sqlite.WithAttach("refdata", ":memory:"),
+		sqlite.WithConnPrepareFunc(func(conn *sqlite.Conn) error {
+			return conn.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS refdata.countries (code TEXT PRIMARY KEY, name TEXT);`)
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	writeConn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer writeConn.Done()
+
+	readConn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer readConn.Done()
+
+	err = writeConn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS attach_users (name TEXT, country TEXT);
+		INSERT INTO refdata.countries (code, name) VALUES ('CA', 'Canada');
+		INSERT INTO attach_users (name, country) VALUES ('john', 'CA');
+	`)
+	assert.NoError(t, err)
+
+	// the attached in-memory database is shared by every pooled connection
+	name, err := sqlite.QueryRow(ctx, readConn, func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("name"), nil
+	}, `
+		SELECT c.name AS name FROM attach_users u
+		JOIN refdata.countries c ON c.code = u.country
+		WHERE u.name = ?;
+	`, "john")
+	assert.NoError(t, err)
+	assert.Equal(t, "Canada", name)
+
+	// another database attaching the same schema name gets its own copy
+	other, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithAttach("refdata", ":memory:"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		other.Close()
+	})
+
+	otherConn, err := other.Conn(ctx)
+	assert.NoError(t, err)
+	defer otherConn.Done()
+
+	exists, err := otherConn.Exists(ctx, `SELECT 1 FROM refdata.sqlite_master WHERE name = 'countries'`)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestWithOpenFlagsReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "readonly.db")
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE readonly_items (name TEXT);
+			INSERT INTO readonly_items (name) VALUES ('item');
+		`)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	db, err = sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithPoolSize(1), sqlite.WithOpenFlags(sqlite.OpenReadOnly))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		count, err := conn.Count(ctx, "readonly_items", "")
+		assert.Equal(t, int64(1), count)
+		return err
+	})
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.Exec(ctx, `INSERT INTO readonly_items (name) VALUES (?);`, "other")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "SQLITE_READONLY", sqlite.ErrCode(err).ToPrimary().String())
+
+	_, err = sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithOpenFlags(sqlite.OpenReadOnly))
+	assert.Error(t, err)
+
+	_, err = sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithOpenFlags(sqlite.OpenReadOnly|sqlite.OpenCreate))
+	assert.Error(t, err)
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithQueryTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	const endless = `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c;`
+
+	start := time.Now()
+	err = conn.Exec(ctx, endless)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	for _, err := range conn.Query(ctx, endless) {
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+
+	// the connection is usable again once the timeout is over
+	err = conn.Exec(ctx, `SELECT 1;`)
+	assert.NoError(t, err)
+}
+
+func TestWithQueryTimeoutCallerDeadline(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithQueryTimeout(10*time.Second))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	const endless = `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c;`
+
+	// the caller's deadline is shorter than the query timeout and wins
+	queryCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = conn.Exec(queryCtx, endless)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	err = conn.Exec(ctx, `SELECT 1;`)
+	assert.NoError(t, err)
+}
+
+func TestWithLogger(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	db, err := sqlite.New(ctx, sqlite.WithLogger(log), sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+
+	conn, err := db.ConnLabeled(ctx, "logger-test")
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `SELECT ?;`, "hello")
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `msg="prepare sql" sql="SELECT 'hello';"`)
+
+	// the connection is never returned, Close reports it
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.Done()
+	}()
+	assert.NoError(t, db.Close())
+	assert.Contains(t, buf.String(), `msg="connection not returned to the pool" label=logger-test`)
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	assert.NoError(t, db.Ping(ctx))
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	// the only connection is held, the probe gives up at its deadline
+	probeCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = db.Ping(probeCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWithReadOnlyFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fixture.db")
+
+	fixture, err := sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+
+	err = fixture.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE cities (name TEXT);
+			INSERT INTO cities (name) VALUES ('Toronto'), ('Vancouver');
+		`)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, fixture.Close())
+
+	db, err := sqlite.New(ctx, sqlite.WithReadOnlyFile(path), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		count, err := conn.Count(ctx, "cities", "")
+		assert.Equal(t, int64(2), count)
+		return err
+	})
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.Exec(ctx, `INSERT INTO cities (name) VALUES (?);`, "Montreal")
+	})
+	assert.Equal(t, "SQLITE_READONLY", sqlite.ErrCode(err).ToPrimary().String())
+
+	missing := filepath.Join(dir, "missing", "fixture.db")
+	_, err = sqlite.New(ctx, sqlite.WithReadOnlyFile(missing))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoDirExists(t, filepath.Dir(missing))
+}
+
+func TestRunScriptTree(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+
+	files := map[string]string{
+		"01_schema.sql":          `CREATE TABLE IF NOT EXISTS tree_log (step TEXT); DELETE FROM tree_log; INSERT INTO tree_log VALUES ('01_schema');`,
+		"02_seed/01_users.sql":   `INSERT INTO tree_log VALUES ('02_seed/01_users');`,
+		"02_seed/02_orders.sql":  `INSERT INTO tree_log VALUES ('02_seed/02_orders');`,
+		"02_seed/deep/01.sql":    `INSERT INTO tree_log VALUES ('02_seed/deep/01');`,
+		"02_seed/notes.txt":      `not sql`,
+		"03_views.sql":           `INSERT INTO tree_log VALUES ('03_views');`,
+		"10_later/01_extras.sql": `INSERT INTO tree_log VALUES ('10_later/01_extras');`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = sqlite.RunScriptTree(ctx, db, root)
+	assert.NoError(t, err)
+
+	var steps []string
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		for stmt, err := range conn.Query(ctx, `SELECT step FROM tree_log ORDER BY rowid;`) {
+			if err != nil {
+				return err
+			}
+			steps = append(steps, stmt.GetText("step"))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"01_schema",
+		"02_seed/01_users",
+		"02_seed/02_orders",
+		"02_seed/deep/01",
+		"03_views",
+		"10_later/01_extras",
+	}, steps)
+}
+
+func TestExecPropagatesError(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	errFailed := errors.New("failed")
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE IF NOT EXISTS exec_tx_items (name TEXT);
+			DELETE FROM exec_tx_items;
+		`)
+	})
+	assert.NoError(t, err)
+
+	insert := func(ctx context.Context, conn *sqlite.Conn, name string) {
+		assert.NoError(t, conn.Exec(ctx, `INSERT INTO exec_tx_items (name) VALUES (?);`, name))
+	}
+
+	err = db.ExecTx(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		insert(ctx, conn, "rolled back")
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+
+	assert.Panics(t, func() {
+		_ = db.ExecTx(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+			insert(ctx, conn, "panicked")
+			panic("boom")
+		})
+	})
+
+	err = db.ExecTx(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		insert(ctx, conn, "outer")
+
+		// the inner savepoint is undone, the outer one keeps its work
+		err := db.ExecTx(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+			insert(ctx, conn, "inner")
+			return errFailed
+		})
+		assert.ErrorIs(t, err, errFailed)
+
+		return nil
+	})
+	assert.NoError(t, err)
+
+	var names []string
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		for stmt, err := range conn.Query(ctx, `SELECT name FROM exec_tx_items;`) {
+			if err != nil {
+				return err
+			}
+			names = append(names, stmt.GetText("name"))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"outer"}, names)
+}
+
+func TestWithInitScript(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := fstest.MapFS{
+		"seed.sql": {Data: []byte(`INSERT INTO init_settings (key, value) VALUES ('theme', 'dark');`)},
+	}
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithFile(filepath.Join(t.TempDir(), "init.db")),
+		sqlite.WithPoolSize(3),
+		sqlite.WithInitScript(`CREATE TABLE init_settings (key TEXT PRIMARY KEY, value TEXT);`),
+		sqlite.WithInitScriptFS(fsys, "seed.sql"),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conns := make([]*sqlite.Conn, 0, 3)
+	for range 3 {
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Done()
+	}
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	count, err := conn.Count(ctx, "init_settings", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	t.Run("failing script", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "broken.db")
+
+		_, err := sqlite.New(ctx,
+			sqlite.WithFile(path),
+			sqlite.WithInitScript(`CREATE TABLE broken (id INTEGER); INSERT INTO missing VALUES (1);`),
+		)
+		assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+		db, err := sqlite.New(ctx, sqlite.WithFile(path))
+		assert.NoError(t, err)
+		defer db.Close()
+
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer conn.Done()
+
+		tables, err := conn.Tables(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, tables)
+	})
+}
+
+func TestWithMemorySharedAcrossPool(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	first, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer first.Done()
+
+	second, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer second.Done()
+
+	err = first.ExecScript(`
+		CREATE TABLE memory_items (name TEXT);
+		INSERT INTO memory_items (name) VALUES ('one');
+	`)
+	assert.NoError(t, err)
+
+	count, err := second.Count(ctx, "memory_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	t.Run("isolated from other databases", func(t *testing.T) {
+		other, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+		assert.NoError(t, err)
+		defer other.Close()
+
+		conn, err := other.Conn(ctx)
+		assert.NoError(t, err)
+		defer conn.Done()
+
+		tables, err := conn.Tables(ctx)
+		assert.NoError(t, err)
+		assert.NotContains(t, tables, "memory_items")
+	})
+}
+
+func TestWithMaxConnLifetime(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithFile(filepath.Join(t.TempDir(), "lifetime.db")),
+		sqlite.WithPoolSize(1),
+		sqlite.WithMaxConnLifetime(time.Millisecond),
+		sqlite.WithConnPrepareFunc(func(conn *sqlite.Conn) error {
+			return conn.Exec(ctx, `CREATE TEMP TABLE IF NOT EXISTS session (name TEXT);`)
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO temp.session (name) VALUES ('stale');`)
+	assert.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	conn.Done()
+
+	conn, err = db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	// the connection was recycled, so the row is gone but the new one
+	// was prepared again
+	count, err := conn.Count(ctx, "temp.session", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestWithOnConnError(t *testing.T) {
+	ctx := context.Background()
+
+	failure := errors.New("bad prepare")
+	var reported []error
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(1),
+		sqlite.WithConnPrepareFunc(func(conn *sqlite.Conn) error {
+			return failure
+		}),
+		sqlite.WithOnConnError(func(err error) {
+			reported = append(reported, err)
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	_, err = db.Conn(ctx)
+	assert.ErrorIs(t, err, failure)
+	assert.Len(t, reported, 1)
+	assert.ErrorIs(t, reported[0], failure)
+
+	// a connection that failed to be prepared is evicted, its replacement
+	// doesn't carry over what was done before the failure
+	reported = nil
+	prepared := 0
+
+	evicted, err := sqlite.New(ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(1),
+		sqlite.WithConnPrepareFunc(func(conn *sqlite.Conn) error {
+			prepared++
+			if prepared > 1 {
+				return nil
+			}
+			err := conn.Exec(ctx, `CREATE TEMP TABLE half_prepared (id INTEGER);`)
+			if err != nil {
+				return err
+			}
+			return failure
+		}),
+		sqlite.WithOnConnError(func(err error) {
+			reported = append(reported, err)
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		evicted.Close()
+	})
+
+	_, err = evicted.Conn(ctx)
+	assert.ErrorIs(t, err, failure)
+	assert.Len(t, reported, 1)
+	assert.ErrorIs(t, reported[0], failure)
+
+	conn, err := evicted.Conn(ctx)
+	assert.NoError(t, err)
+	err = conn.Exec(ctx, `SELECT * FROM half_prepared;`)
+	assert.ErrorContains(t, err, "no such table")
+	conn.Done()
+
+	// a connection that can't be replaced once its lifetime is over
+	dir := filepath.Join(t.TempDir(), "data")
+	reported = nil
+
+	recycled, err := sqlite.New(ctx,
+		sqlite.WithFile(filepath.Join(dir, "lifetime.db")),
+		sqlite.WithPoolSize(1),
+		sqlite.WithMaxConnLifetime(time.Millisecond),
+		sqlite.WithOnConnError(func(err error) {
+			reported = append(reported, err)
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		recycled.Close()
+	})
+
+	conn, err = recycled.Conn(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.RemoveAll(dir))
+	time.Sleep(5 * time.Millisecond)
+	conn.Done()
+
+	assert.Len(t, reported, 1)
+	assert.ErrorContains(t, reported[0], "recycle sqlite connection")
+
+	// the old connection is kept
+	conn, err = recycled.Conn(ctx)
+	assert.NoError(t, err)
+	conn.Done()
+}
+
+func TestWithQueryStats(t *testing.T) {
+	ctx := context.Background()
+
+	var stats []sqlite.QueryStats
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithQueryStats(func(ctx context.Context, s sqlite.QueryStats) {
+		stats = append(stats, s)
+	}))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.Exec(ctx, `CREATE TABLE stats_items (name TEXT);`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO stats_items (name) VALUES (?);`, "one")
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `SELECT * FROM missing_table;`)
+	assert.Error(t, err)
+
+	assert.Len(t, stats, 3)
+	assert.Equal(t, `INSERT INTO stats_items (name) VALUES ('one');`, stats[1].SQL)
+	for _, s := range stats {
+		assert.GreaterOrEqual(t, s.PrepareDuration, time.Duration(0))
+		assert.GreaterOrEqual(t, s.StepDuration, time.Duration(0))
+	}
+	assert.NoError(t, stats[1].Err)
+	assert.ErrorIs(t, stats[2].Err, sqlite.ErrPrepareSQL)
+}
+
+func TestWithPageSizeAndAutoVacuum(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithFile(filepath.Join(t.TempDir(), "pages.db")),
+		sqlite.WithPoolSize(2),
+		sqlite.WithPageSize(8192),
+		sqlite.WithAutoVacuum(sqlite.AutoVacuumIncremental),
+		sqlite.WithInitScript(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);`),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	pragma := func(name string) (value string) {
+		for stmt, err := range conn.Query(ctx, `PRAGMA `+name+`;`) {
+			assert.NoError(t, err)
+			value = stmt.ColumnText(0)
+		}
+		return value
+	}
+
+	assert.Equal(t, "8192", pragma("page_size"))
+	assert.Equal(t, "2", pragma("auto_vacuum")) // INCREMENTAL
+	assert.Equal(t, "wal", pragma("journal_mode"))
+
+	_, err = sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPageSize(1000))
+	assert.Error(t, err)
+
+	_, err = sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithAutoVacuum(sqlite.AutoVacuumMode(7)))
+	assert.Error(t, err)
+}
+
+func TestWithReadWriteSplit(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithFile(filepath.Join(t.TempDir(), "split.db")),
+		sqlite.WithPoolSize(1),
+		sqlite.WithReadWriteSplit(2),
+		sqlite.WithInitScript(`CREATE TABLE split_items (id INTEGER PRIMARY KEY, name TEXT);`),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	writer, err := db.ConnFor(ctx, `INSERT INTO split_items (name) VALUES (?);`)
+	assert.NoError(t, err)
+	defer writer.Done()
+
+	err = writer.Exec(ctx, `INSERT INTO split_items (name) VALUES (?);`, "a")
+	assert.NoError(t, err)
+
+	// the only regular connection is taken, reads still get one
+	reader, err := db.ConnFor(ctx, `/* count */ SELECT COUNT(*) FROM split_items;`)
+	assert.NoError(t, err)
+	defer reader.Done()
+
+	count, err := reader.Count(ctx, "split_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	err = reader.Exec(ctx, `INSERT INTO split_items (name) VALUES (?);`, "b")
+	assert.Error(t, err)
+
+	// writes wait for the regular connection
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = db.ConnFor(timeoutCtx, `WITH x AS (SELECT 1) DELETE FROM split_items;`)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithReadWriteSplit(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,234 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// ErrUnknownVFS is returned by New when the VFS given to WithVFS is not
+// registered
+var ErrUnknownVFS = errors.New("database vfs is not registered")
+
+// ErrInvalidDSN is returned by New when the connection string is malformed
+// or combines settings that can't work together
+var ErrInvalidDSN = errors.New("database connection string is invalid")
+
+// DSNOptions describes a SQLite URI filename, see WithDSN
+type DSNOptions struct {
+	// Path of the database file, it's escaped as needed. Use ":memory:" with
+	// Mode "memory" or an empty Path for an in-memory database.
+	Path string
+	// Mode is one of "ro", "rw", "rwc" or "memory", empty leaves it to the
+	// open flags
+	Mode string
+	// Cache is "shared" or "private", empty uses SQLite's default
+	Cache string
+	// Immutable tells SQLite the file can't change, not even by another
+	// process, so no locking or change detection is done
+	Immutable bool
+	// VFS selects the virtual file system by name
+	VFS string
+	// Shared is a shorthand for Cache "shared"
+	Shared bool
+}
+
+// String returns the URI filename, e.g. file:my%20data.db?cache=shared
+func (o DSNOptions) String() string {
+	query := url.Values{}
+	if o.Mode != "" {
+		query.Set("mode", o.Mode)
+	}
+	if o.Cache != "" {
+		query.Set("cache", o.Cache)
+	} else if o.Shared {
+		query.Set("cache", "shared")
+	}
+	if o.Immutable {
+		query.Set("immutable", "1")
+	}
+	if o.VFS != "" {
+		query.Set("vfs", o.VFS)
+	}
+
+	dsn := "file:" + escapeDSNPath(o.Path)
+	if len(query) > 0 {
+		dsn += "?" + query.Encode()
+	}
+
+	return dsn
+}
+
+func (o DSNOptions) validate() error {
+	switch o.Mode {
+	case "", "ro", "rw", "rwc", "memory":
+	default:
+		return fmt.Errorf("dsn: unknown mode %q", o.Mode)
+	}
+
+	switch o.Cache {
+	case "", "shared", "private":
+	default:
+		return fmt.Errorf("dsn: unknown cache %q", o.Cache)
+	}
+
+	if o.Shared && o.Cache == "private" {
+		return fmt.Errorf("dsn: shared can't be combined with a private cache")
+	}
+
+	if o.Path == "" && o.Mode != "memory" {
+		return fmt.Errorf("dsn: path is required unless mode is memory")
+	}
+
+	return nil
+}
+
+// escapeDSNPath percent-encodes everything in path that has a meaning in a
+// URI, e.g. '?', '#', '%' and spaces, keeping '/' and ':' as they are
+func escapeDSNPath(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			sb.WriteByte(c)
+		case strings.IndexByte("-._~/:", c) >= 0:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// WithDSN opens the database described by opts, a typed alternative to
+// hand-crafting a connection string for WithStringConn. The path is escaped,
+// so file names with spaces, '?' or '#' work as expected.
+func WithDSN(opts DSNOptions) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		err := opts.validate()
+		if err != nil {
+			return err
+		}
+
+		return WithStringConn(opts.String())(ctx, db)
+	}
+}
+
+// WithVFS opens every connection with the virtual file system registered
+// under name, by adding vfs=<name> to the connection URI set by WithFile,
+// WithMemory, WithDSN or WithStringConn. A plain path given to WithStringConn
+// is turned into a URI.
+//
+// The VFS must be registered with SQLite before New is called, for example by
+// the package that implements it or with sqlite3_vfs_register through the
+// modernc.org/sqlite/lib bindings zombiezen.com/go/sqlite is built on. New
+// returns ErrUnknownVFS if no VFS with that name exists.
+func WithVFS(name string) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.vfs = name
+		return nil
+	}
+}
+
+// checkVFS reports whether a VFS called name is registered, by opening a
+// throwaway in-memory connection with it since SQLite looks up the VFS
+// before anything else
+func checkVFS(name string) error {
+	probe := DSNOptions{Path: "vfs-probe", Mode: "memory", VFS: name}
+
+	conn, err := sqlite.OpenConn(probe.String(), OpenReadWrite|OpenURI|OpenMemory)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrUnknownVFS, name)
+	}
+
+	return conn.Close()
+}
+
+// withURIParam adds key=value to the query of dsn, turning a plain path into
+// a URI filename first. dsn is returned as is if it already sets key.
+func withURIParam(dsn string, key string, value string) string {
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + escapeDSNPath(dsn)
+	}
+
+	path, query, found := strings.Cut(dsn, "?")
+	if !found {
+		return path + "?" + key + "=" + url.QueryEscape(value)
+	}
+
+	for _, param := range strings.Split(query, "&") {
+		if k, _, _ := strings.Cut(param, "="); k == key {
+			return dsn
+		}
+	}
+
+	return dsn + "&" + key + "=" + url.QueryEscape(value)
+}
+
+// validateDSN catches the connection strings that SQLite would accept but
+// that don't do what was meant, e.g. URI parameters on a plain path, which
+// end up in the file name, or a private in-memory database shared by a pool.
+// flags are the open flags with the defaults applied.
+func validateDSN(dsn string, flags OpenFlags, poolSize int) error {
+	if dsn == "" {
+		return fmt.Errorf("%w: no database given, use WithFile, WithMemory or WithStringConn", ErrInvalidDSN)
+	}
+
+	path, rawQuery, hasQuery := strings.Cut(dsn, "?")
+
+	if !strings.HasPrefix(dsn, "file:") {
+		if hasQuery {
+			return fmt.Errorf("%w: %q has parameters but no file: prefix, they would become part of the file name", ErrInvalidDSN, dsn)
+		}
+		return nil
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", ErrInvalidDSN, dsn, err)
+	}
+
+	mode := query.Get("mode")
+	switch mode {
+	case "", "ro", "rw", "rwc", "memory":
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidDSN, mode)
+	}
+
+	cache := query.Get("cache")
+	switch cache {
+	case "", "shared", "private":
+	default:
+		return fmt.Errorf("%w: unknown cache %q", ErrInvalidDSN, cache)
+	}
+
+	memory := mode == "memory" || path == "file::memory:"
+	readOnly := flags&OpenReadOnly != 0
+
+	switch {
+	case memory && cache != "shared" && poolSize > 1:
+		return fmt.Errorf("%w: in-memory database without cache=shared, each of the %d connections would get its own database", ErrInvalidDSN, poolSize)
+	case memory && readOnly:
+		return fmt.Errorf("%w: read-only can't be used with an in-memory database", ErrInvalidDSN)
+	case (mode == "rw" || mode == "rwc") && readOnly:
+		return fmt.Errorf("%w: mode=%s can't be used with OpenReadOnly", ErrInvalidDSN, mode)
+	}
+
+	return nil
+}
+
+// uriParam returns the value of key in the query of a URI filename, or ""
+func uriParam(dsn string, key string) string {
+	if !strings.HasPrefix(dsn, "file:") {
+		return ""
+	}
+
+	_, rawQuery, _ := strings.Cut(dsn, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	return query.Get(key)
+}
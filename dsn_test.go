@@ -0,0 +1,248 @@
+package sqlite_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDSNOptions(t *testing.T) {
+	testCases := []struct {
+		opts     sqlite.DSNOptions
+		expected string
+	}{
+		{sqlite.DSNOptions{Path: "/data/app.db", Shared: true}, "file:/data/app.db?cache=shared"},
+		{sqlite.DSNOptions{Path: "/data/my app?.db"}, "file:/data/my%20app%3F.db"},
+		{sqlite.DSNOptions{Path: "/data/100%#1.db", Mode: "ro", Immutable: true}, "file:/data/100%25%231.db?immutable=1&mode=ro"},
+		{sqlite.DSNOptions{Path: ":memory:", Mode: "memory", Cache: "shared"}, "file::memory:?cache=shared&mode=memory"},
+		{sqlite.DSNOptions{Path: "app.db", VFS: "unix-none"}, "file:app.db?vfs=unix-none"},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.opts.String())
+	}
+}
+
+func TestWithDSNSpecialPaths(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	for _, name := range []string{"my data.db", "what?.db", "hash#tag.db", "100%.db"} {
+		path := filepath.Join(dir, "sub dir", name)
+
+		db, err := sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithPoolSize(1))
+		assert.NoError(t, err, name)
+
+		err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+			return conn.ExecScript(`CREATE TABLE dsn_items (name TEXT);`)
+		})
+		assert.NoError(t, err, name)
+		assert.NoError(t, db.Close())
+
+		// the database ends up exactly at path
+		assert.FileExists(t, path)
+
+		db, err = sqlite.New(ctx, sqlite.WithDSN(sqlite.DSNOptions{Path: path, Mode: "ro"}), sqlite.WithPoolSize(1))
+		assert.NoError(t, err, name)
+
+		err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+			tables, err := conn.Tables(ctx)
+			assert.Equal(t, []string{"dsn_items"}, tables)
+			return err
+		})
+		assert.NoError(t, err, name)
+		assert.NoError(t, db.Close())
+	}
+
+	_, err := sqlite.New(ctx, sqlite.WithDSN(sqlite.DSNOptions{Path: "app.db", Mode: "readonly"}))
+	assert.Error(t, err)
+
+	_, err = sqlite.New(ctx, sqlite.WithDSN(sqlite.DSNOptions{Path: "app.db", Shared: true, Cache: "private"}))
+	assert.Error(t, err)
+}
+
+func TestWithVFS(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vfs.db")
+
+	// memdb keeps the database in memory whatever the path is
+	db, err := sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithVFS("memdb"), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE vfs_items (name TEXT);
+		INSERT INTO vfs_items (name) VALUES ('one');
+	`)
+	assert.NoError(t, err)
+
+	count, err := conn.Count(ctx, "vfs_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithVFS("does-not-exist"))
+		assert.ErrorIs(t, err, sqlite.ErrUnknownVFS)
+	})
+}
+
+func TestWithSharedCache(t *testing.T) {
+	ctx := context.Background()
+
+	// with read_uncommitted a connection sharing the cache sees the
+	// uncommitted rows of another one, a private cache never does
+	uncommittedRows := func(t *testing.T, opts ...sqlite.OptionFunc) int64 {
+		opts = append(opts, sqlite.WithFile(filepath.Join(t.TempDir(), "cache.db")), sqlite.WithPoolSize(2))
+
+		db, err := sqlite.New(ctx, opts...)
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			db.Close()
+		})
+
+		writer, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer writer.Done()
+
+		reader, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer reader.Done()
+
+		err = writer.ExecScript(`CREATE TABLE cache_items (name TEXT);`)
+		assert.NoError(t, err)
+
+		err = reader.Exec(ctx, `PRAGMA read_uncommitted = 1;`)
+		assert.NoError(t, err)
+
+		var count int64
+		err = func() (err error) {
+			defer writer.Savepoint("cache_write")(&err)
+
+			err = writer.Exec(ctx, `INSERT INTO cache_items (name) VALUES ('pending');`)
+			if err != nil {
+				return err
+			}
+
+			count, err = reader.Count(ctx, "cache_items", "")
+			return err
+		}()
+		assert.NoError(t, err)
+
+		return count
+	}
+
+	t.Run("private by default", func(t *testing.T) {
+		assert.Equal(t, int64(0), uncommittedRows(t))
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		assert.Equal(t, int64(1), uncommittedRows(t, sqlite.WithSharedCache(true)))
+	})
+}
+
+func TestNewRejectsMalformedDSN(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+
+	testCases := []struct {
+		name  string
+		opts  []sqlite.OptionFunc
+		error string
+	}{
+		{
+			name:  "no database",
+			opts:  []sqlite.OptionFunc{sqlite.WithPoolSize(1)},
+			error: "no database given",
+		},
+		{
+			name:  "parameters without file prefix",
+			opts:  []sqlite.OptionFunc{sqlite.WithStringConn(path + "?mode=ro")},
+			error: "no file: prefix",
+		},
+		{
+			name:  "memory without shared cache",
+			opts:  []sqlite.OptionFunc{sqlite.WithStringConn("file:app?mode=memory"), sqlite.WithPoolSize(2)},
+			error: "without cache=shared",
+		},
+		{
+			name:  "unknown mode",
+			opts:  []sqlite.OptionFunc{sqlite.WithStringConn("file:" + path + "?mode=readonly")},
+			error: `unknown mode "readonly"`,
+		},
+		{
+			name:  "unknown cache",
+			opts:  []sqlite.OptionFunc{sqlite.WithStringConn("file:" + path + "?cache=public")},
+			error: `unknown cache "public"`,
+		},
+		{
+			name:  "bad escape",
+			opts:  []sqlite.OptionFunc{sqlite.WithStringConn("file:" + path + "?vfs=%zz")},
+			error: "invalid URL escape",
+		},
+		{
+			name:  "read-only memory",
+			opts:  []sqlite.OptionFunc{sqlite.WithMemory(), sqlite.WithOpenFlags(sqlite.OpenReadOnly)},
+			error: "in-memory database",
+		},
+		{
+			name:  "read-write mode with read-only flags",
+			opts:  []sqlite.OptionFunc{sqlite.WithStringConn("file:" + path + "?mode=rwc"), sqlite.WithOpenFlags(sqlite.OpenReadOnly | sqlite.OpenURI)},
+			error: "mode=rwc can't be used with OpenReadOnly",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := sqlite.New(ctx, tc.opts...)
+			if !assert.ErrorIs(t, err, sqlite.ErrInvalidDSN) {
+				db.Close()
+				return
+			}
+			assert.ErrorContains(t, err, tc.error)
+		})
+	}
+
+	// a private in-memory database is fine with a single connection
+	db, err := sqlite.New(ctx, sqlite.WithStringConn("file:app?mode=memory"), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+}
+
+func TestNewReadOnlyModeDSN(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(path), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`CREATE TABLE dsn_items (name TEXT);`)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	// mode=ro without OpenReadOnly is opened read-only instead of failing on
+	// the WAL pragma
+	db, err = sqlite.New(ctx, sqlite.WithStringConn("file:"+path+"?mode=ro"), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.Exec(ctx, `INSERT INTO dsn_items (name) VALUES (?);`, "item")
+	})
+	assert.Error(t, err)
+}
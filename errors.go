@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"errors"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// ResultCode is the SQLite result code carried by an error, see ErrCode
+type ResultCode = sqlite.ResultCode
+
+// ErrCode returns the SQLite result code of err, unwrapping the errors
+// returned by this package. It returns sqlite.ResultError if err doesn't come
+// from SQLite and sqlite.ResultOK if err is nil.
+func ErrCode(err error) ResultCode {
+	return sqlite.ErrCode(err)
+}
+
+// IsNotFound reports whether err is ErrNotFound
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsBusy reports whether err is an SQLITE_BUSY or SQLITE_LOCKED error,
+// meaning another connection holds a conflicting lock and the operation
+// can be retried
+func IsBusy(err error) bool {
+	switch ErrCode(err).ToPrimary() {
+	case sqlite.ResultBusy, sqlite.ResultLocked:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsConstraintViolation reports whether err is any constraint violation,
+// e.g. UNIQUE, NOT NULL, CHECK or FOREIGN KEY
+func IsConstraintViolation(err error) bool {
+	return ErrCode(err).ToPrimary() == sqlite.ResultConstraint
+}
+
+// IsUniqueViolation reports whether err is a UNIQUE or PRIMARY KEY
+// constraint violation
+func IsUniqueViolation(err error) bool {
+	switch ErrCode(err) {
+	case sqlite.ResultConstraintUnique, sqlite.ResultConstraintPrimaryKey:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsForeignKeyViolation reports whether err is a FOREIGN KEY
+// constraint violation, which SQLite only reports once enforcement is
+// turned on, see WithForeignKeys
+func IsForeignKeyViolation(err error) bool {
+	return ErrCode(err) == sqlite.ResultConstraintForeignKey
+}
+
+// IsIOError reports whether err comes from the storage rather than the SQL,
+// e.g. SQLITE_FULL when the disk is full, SQLITE_IOERR or SQLITE_CANTOPEN
+func IsIOError(err error) bool {
+	switch ErrCode(err).ToPrimary() {
+	case sqlite.ResultFull, sqlite.ResultIOErr, sqlite.ResultCantOpen:
+		return true
+	default:
+		return false
+	}
+}
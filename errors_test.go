@@ -0,0 +1,105 @@
+package sqlite_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClassification(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithForeignKeys(true))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS class_teams (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE);
+		CREATE TABLE IF NOT EXISTS class_players (
+			id INTEGER PRIMARY KEY,
+			team_id INTEGER NOT NULL REFERENCES class_teams (id),
+			age INTEGER CHECK (age > 0)
+		);
+		INSERT INTO class_teams (id, name) VALUES (1, 'red');
+	`)
+	assert.NoError(t, err)
+
+	t.Run("unique", func(t *testing.T) {
+		err := conn.Exec(ctx, `INSERT INTO class_teams (id, name) VALUES (?, ?);`, 2, "red")
+		assert.True(t, sqlite.IsUniqueViolation(err))
+		assert.True(t, sqlite.IsConstraintViolation(err))
+		assert.False(t, sqlite.IsForeignKeyViolation(err))
+		assert.False(t, sqlite.IsBusy(err))
+	})
+
+	t.Run("primary key", func(t *testing.T) {
+		err := conn.Exec(ctx, `INSERT INTO class_teams (id, name) VALUES (?, ?);`, 1, "blue")
+		assert.True(t, sqlite.IsUniqueViolation(err))
+		assert.True(t, sqlite.IsConstraintViolation(err))
+	})
+
+	t.Run("foreign key", func(t *testing.T) {
+		err := conn.Exec(ctx, `INSERT INTO class_players (id, team_id, age) VALUES (?, ?, ?);`, 1, 42, 20)
+		assert.True(t, sqlite.IsForeignKeyViolation(err))
+		assert.True(t, sqlite.IsConstraintViolation(err))
+		assert.False(t, sqlite.IsUniqueViolation(err))
+	})
+
+	t.Run("check", func(t *testing.T) {
+		err := conn.Exec(ctx, `INSERT INTO class_players (id, team_id, age) VALUES (?, ?, ?);`, 1, 1, -1)
+		assert.True(t, sqlite.IsConstraintViolation(err))
+		assert.False(t, sqlite.IsUniqueViolation(err))
+		assert.False(t, sqlite.IsForeignKeyViolation(err))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		assert.True(t, sqlite.IsNotFound(fmt.Errorf("loading user: %w", sqlite.ErrNotFound)))
+		assert.False(t, sqlite.IsNotFound(err))
+	})
+}
+
+func TestErrorClassificationBusy(t *testing.T) {
+	ctx := context.Background()
+
+	stringConn := "file:" + filepath.Join(t.TempDir(), "busy.db")
+
+	db1, err := sqlite.New(ctx, sqlite.WithStringConn(stringConn), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db1.Close()
+	})
+
+	db2, err := sqlite.New(ctx, sqlite.WithStringConn(stringConn), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db2.Close()
+	})
+
+	locker, err := db2.Conn(ctx)
+	assert.NoError(t, err)
+	defer locker.Done()
+
+	assert.NoError(t, locker.Exec(ctx, `CREATE TABLE IF NOT EXISTS busy_items (name TEXT);`))
+	assert.NoError(t, locker.Exec(ctx, `BEGIN IMMEDIATE;`))
+	defer locker.Exec(ctx, `ROLLBACK;`)
+
+	conn, err := db1.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	assert.NoError(t, conn.ExecScript(`PRAGMA busy_timeout = 10;`))
+
+	err = conn.Exec(ctx, `INSERT INTO busy_items (name) VALUES (?);`, "item")
+	assert.True(t, sqlite.IsBusy(err))
+	assert.False(t, sqlite.IsConstraintViolation(err))
+}
@@ -0,0 +1,360 @@
+package sqlite
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WriteRowsJSON steps through the remaining rows of stmt and writes them to w
+// as a JSON array of objects keyed by column name, in column order. BLOBs are
+// base64 encoded and NULLs written as null. Rows are written as they are
+// read, so the result set never has to fit in memory. The statement is not
+// reset.
+func WriteRowsJSON(w io.Writer, stmt *Stmt) error {
+	count := stmt.ColumnCount()
+
+	keys := make([][]byte, count)
+	for i := range count {
+		key, err := json.Marshal(stmt.ColumnName(i))
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+
+	for n := 0; ; n++ {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrExecSQL, err)
+		}
+
+		if !hasRow {
+			break
+		}
+
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+
+		bw.WriteByte('{')
+		for i := range count {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+
+			value, err := json.Marshal(columnValue(stmt, i))
+			if err != nil {
+				return fmt.Errorf("column %s: %w", stmt.ColumnName(i), err)
+			}
+
+			bw.Write(keys[i])
+			bw.WriteByte(':')
+			bw.Write(value)
+		}
+		bw.WriteByte('}')
+	}
+
+	bw.WriteByte(']')
+
+	return bw.Flush()
+}
+
+// csvImportChunk is how many CSV records ImportCSV inserts per BatchInsert
+const csvImportChunk = 500
+
+// ExportCSV runs sql and writes its rows to w as CSV, with a header row of
+// column names. NULLs are written as empty cells and BLOBs base64 encoded.
+func ExportCSV(ctx context.Context, conn *Conn, w io.Writer, sql string, args ...any) error {
+	ctx, done := conn.withQueryTimeout(ctx)
+	defer done()
+
+	stmt, err := conn.Prepare(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer stmt.Reset()
+
+	cw := csv.NewWriter(w)
+
+	// the header is written even when there are no rows
+	record := make([]string, stmt.ColumnCount())
+	for i := range record {
+		record[i] = stmt.ColumnName(i)
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return stepError(ctx, err)
+		}
+
+		if !hasRow {
+			break
+		}
+
+		for i := range record {
+			switch value := columnValue(stmt, i).(type) {
+			case nil:
+				record[i] = ""
+			case int64:
+				record[i] = strconv.FormatInt(value, 10)
+			case float64:
+				record[i] = strconv.FormatFloat(value, 'g', -1, 64)
+			case string:
+				record[i] = value
+			case []byte:
+				record[i] = base64.StdEncoding.EncodeToString(value)
+			}
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ImportCSV reads CSV from r, whose first row holds column names, and inserts
+// every other row into table using BatchInsert. All rows are inserted within
+// one savepoint, so a bad row leaves the table untouched. Cells are bound as
+// text and SQLite coerces them according to the column affinity, empty cells
+// are inserted as NULL.
+func ImportCSV(ctx context.Context, conn *Conn, table string, r io.Reader) (err error) {
+	if !isIdentifier(table) {
+		return fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, column := range header {
+		if !isIdentifierPart(column) {
+			return fmt.Errorf("%w: invalid column name %q", ErrPrepareSQL, column)
+		}
+	}
+
+	sql := "INSERT INTO " + table + " (" + strings.Join(header, ", ") + ") VALUES"
+
+	defer conn.Save()(&err)
+
+	rows := make([][]any, 0, csvImportChunk)
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+
+		_, err := conn.BatchInsert(ctx, sql, rows)
+		if err != nil {
+			return err
+		}
+
+		rows = rows[:0]
+		return nil
+	}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		row := make([]any, len(record))
+		for i, cell := range record {
+			if cell != "" {
+				row[i] = cell
+			}
+		}
+		rows = append(rows, row)
+
+		if len(rows) == csvImportChunk {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Dump writes the whole database to w as an SQL script that rebuilds it, a
+// portable and readable alternative to Backup: first every table followed by
+// INSERT statements for its rows, then indexes, triggers and views. It can be
+// loaded into an empty database with RunScript. The output is streamed and
+// read from a single snapshot, so writers can keep going meanwhile.
+//
+// Foreign keys are deferred to the end of the script, so rows can be
+// inserted in any order. Internal sqlite_ tables are skipped, except for the
+// AUTOINCREMENT counters of sqlite_sequence. Virtual tables, e.g. FTS5, are
+// restored through their shadow tables, like the sqlite3 shell's .dump does.
+// Rowids are only kept when they are the INTEGER PRIMARY KEY.
+func (db *Database) Dump(ctx context.Context, w io.Writer) error {
+	return db.Exec(ctx, func(ctx context.Context, conn *Conn) (err error) {
+		defer conn.Save()(&err)
+
+		bw := bufio.NewWriter(w)
+		bw.WriteString("PRAGMA defer_foreign_keys = ON;\n")
+
+		shadow := make(map[string]bool)
+		for stmt, err := range conn.Query(ctx, `SELECT name FROM pragma_table_list WHERE schema = 'main' AND type = 'shadow';`) {
+			if err != nil {
+				return err
+			}
+			shadow[stmt.GetText("name")] = true
+		}
+
+		type object struct {
+			typ, name, sql string
+		}
+
+		var tables, others []object
+		for stmt, err := range conn.Query(ctx, `SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY rowid;`) {
+			if err != nil {
+				return err
+			}
+
+			obj := object{typ: stmt.GetText("type"), name: stmt.GetText("name"), sql: stmt.GetText("sql")}
+			if obj.typ == "table" {
+				tables = append(tables, obj)
+			} else {
+				others = append(others, obj)
+			}
+		}
+
+		for _, table := range tables {
+			switch {
+			case table.name == "sqlite_sequence":
+				continue
+			case strings.HasPrefix(table.name, "sqlite_"):
+				continue
+			case shadow[table.name]:
+				// created by its virtual table, which also adds a few rows
+				err = dumpRows(ctx, conn, bw, table.name, "INSERT OR REPLACE INTO")
+			case strings.HasPrefix(strings.ToUpper(table.sql), "CREATE VIRTUAL TABLE"):
+				// its content lives in the shadow tables
+				_, err = fmt.Fprintf(bw, "%s;\n", table.sql)
+			default:
+				_, err = fmt.Fprintf(bw, "%s;\n", table.sql)
+				if err == nil {
+					err = dumpRows(ctx, conn, bw, table.name, "INSERT INTO")
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if slices.ContainsFunc(tables, func(table object) bool { return table.name == "sqlite_sequence" }) {
+			bw.WriteString("DELETE FROM sqlite_sequence;\n")
+			err = dumpRows(ctx, conn, bw, "sqlite_sequence", "INSERT INTO")
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, obj := range others {
+			if _, err := fmt.Fprintf(bw, "%s;\n", obj.sql); err != nil {
+				return err
+			}
+		}
+
+		return bw.Flush()
+	})
+}
+
+// dumpRows writes one `<insert> "table" (...) VALUES (...);` line per row of
+// table. Generated and hidden columns are left out, SQLite fills them in.
+func dumpRows(ctx context.Context, conn *Conn, bw *bufio.Writer, table string, insert string) error {
+	var columns []string
+	for stmt, err := range conn.Query(ctx, `SELECT name FROM pragma_table_xinfo(?) WHERE hidden = 0 ORDER BY cid;`, table) {
+		if err != nil {
+			return err
+		}
+		columns = append(columns, quoteIdentifier(stmt.GetText("name")))
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	list := strings.Join(columns, ", ")
+	prefix := insert + " " + quoteIdentifier(table) + " (" + list + ") VALUES ("
+
+	for stmt, err := range conn.Query(ctx, `SELECT `+list+` FROM `+quoteIdentifier(table)+`;`) {
+		if err != nil {
+			return err
+		}
+
+		bw.WriteString(prefix)
+		for i := range columns {
+			if i > 0 {
+				bw.WriteString(", ")
+			}
+			bw.WriteString(dumpLiteral(columnValue(stmt, i)))
+		}
+		if _, err := bw.WriteString(");\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dumpLiteral renders a column value as an SQL literal that reads back as the
+// same value and type
+func dumpLiteral(value any) string {
+	f, ok := value.(float64)
+	if !ok {
+		return sqlLiteral(TimeSeconds, value)
+	}
+
+	switch {
+	case math.IsInf(f, 1):
+		return "1e999"
+	case math.IsInf(f, -1):
+		return "-1e999"
+	}
+
+	// keep it a REAL, 2.0 must not come back as the INTEGER 2
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// quoteIdentifier quotes name as an SQL identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,216 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRowsJSON(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		DROP TABLE IF EXISTS json_export_items;
+		CREATE TABLE json_export_items (id INTEGER PRIMARY KEY, name TEXT, price REAL, image BLOB);
+		INSERT INTO json_export_items (id, name, price, image) VALUES
+			(1, 'apple "red"', 1.25, x'68656c6c6f'),
+			(2, NULL, 3, NULL);
+	`)
+	assert.NoError(t, err)
+
+	stmt, err := conn.Prepare(ctx, `SELECT id, name, price, image FROM json_export_items ORDER BY id;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	var sb strings.Builder
+	err = sqlite.WriteRowsJSON(&sb, stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"id":1,"name":"apple \"red\"","price":1.25,"image":"aGVsbG8="},{"id":2,"name":null,"price":3,"image":null}]`, sb.String())
+
+	stmt, err = conn.Prepare(ctx, `SELECT id FROM json_export_items WHERE id > ?;`, 10)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	sb.Reset()
+	err = sqlite.WriteRowsJSON(&sb, stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, `[]`, sb.String())
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		DROP TABLE IF EXISTS csv_source;
+		DROP TABLE IF EXISTS csv_target;
+		CREATE TABLE csv_source (id INTEGER PRIMARY KEY, name TEXT, price REAL, note TEXT);
+		CREATE TABLE csv_target (id INTEGER PRIMARY KEY, name TEXT, price REAL, note TEXT);
+		INSERT INTO csv_source (id, name, price, note) VALUES
+			(1, 'apple', 1.25, 'red, "crisp"'),
+			(2, 'pear', 3, NULL),
+			(3, 'plum', 0.5, 'multi
+line');
+	`)
+	assert.NoError(t, err)
+
+	var exported strings.Builder
+	err = sqlite.ExportCSV(ctx, conn, &exported, `SELECT id, name, price, note FROM csv_source ORDER BY id;`)
+	assert.NoError(t, err)
+	assert.Equal(t, "id,name,price,note\n1,apple,1.25,\"red, \"\"crisp\"\"\"\n2,pear,3,\n3,plum,0.5,\"multi\nline\"\n", exported.String())
+
+	err = sqlite.ImportCSV(ctx, conn, "csv_target", strings.NewReader(exported.String()))
+	assert.NoError(t, err)
+
+	var reexported strings.Builder
+	err = sqlite.ExportCSV(ctx, conn, &reexported, `SELECT id, name, price, note FROM csv_target ORDER BY id;`)
+	assert.NoError(t, err)
+	assert.Equal(t, exported.String(), reexported.String())
+
+	// an empty result still has its header
+	var empty strings.Builder
+	err = sqlite.ExportCSV(ctx, conn, &empty, `SELECT id, name FROM csv_source WHERE id > ?;`, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "id,name\n", empty.String())
+
+	// values keep their affinity once imported
+	price, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (float64, error) {
+		return stmt.GetFloat("price"), nil
+	}, `SELECT price FROM csv_target WHERE typeof(price) = 'real' AND id = ?;`, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.25, price)
+
+	// a bad row rolls back the whole import
+	err = sqlite.ImportCSV(ctx, conn, "csv_target", strings.NewReader("id,name\n10,kiwi\n1,duplicate\n"))
+	assert.Error(t, err)
+
+	count, err := conn.Count(ctx, "csv_target", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = sqlite.RunScript(ctx, db, `
+		PRAGMA defer_foreign_keys = ON;
+		CREATE TABLE dump_users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, score REAL, avatar BLOB, manager_id INTEGER REFERENCES dump_users (id));
+		CREATE TABLE dump_posts (
+			id INTEGER PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES dump_users (id),
+			title TEXT,
+			title_length INTEGER GENERATED ALWAYS AS (length(title))
+		);
+		CREATE INDEX dump_posts_user ON dump_posts (user_id);
+		CREATE VIEW dump_user_posts AS SELECT u.name, p.title FROM dump_users u JOIN dump_posts p ON p.user_id = u.id;
+		CREATE TRIGGER dump_users_rename AFTER UPDATE OF name ON dump_users BEGIN
+			UPDATE dump_posts SET title = title || '!' WHERE user_id = new.id;
+		END;
+
+		-- manager_id points forward
+		INSERT INTO dump_users (id, name, score, avatar, manager_id) VALUES (1, 'O''Brien', 2.0, x'deadbeef', 3);
+		INSERT INTO dump_users (id, name, score, avatar, manager_id) VALUES (2, 'multi
+line; name', NULL, NULL, NULL);
+		INSERT INTO dump_users (id, name, score, avatar, manager_id) VALUES (3, 'boss', 1.5e300, x'', NULL);
+		DELETE FROM dump_users WHERE id = 2;
+		INSERT INTO dump_posts (user_id, title) VALUES (1, 'hello'), (3, 'world');
+	`)
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return sqlite.CreateFTS5(ctx, conn, "dump_posts_fts", []string{"title"}, "dump_posts")
+	})
+	assert.NoError(t, err)
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.Exec(ctx, `INSERT INTO dump_posts (user_id, title) VALUES (?, ?);`, 3, "searchable words")
+	})
+	assert.NoError(t, err)
+
+	var dump bytes.Buffer
+	err = db.Dump(ctx, &dump)
+	assert.NoError(t, err)
+	assert.Contains(t, dump.String(), `INSERT INTO "dump_users" ("id", "name", "score", "avatar", "manager_id") VALUES (1, 'O''Brien', 2.0, x'deadbeef', 3);`)
+
+	restored, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		restored.Close()
+	})
+
+	err = sqlite.RunScript(ctx, restored, dump.String())
+	assert.NoError(t, err)
+
+	// dumping the copy gives the same script back
+	var again bytes.Buffer
+	err = restored.Dump(ctx, &again)
+	assert.NoError(t, err)
+	assert.Equal(t, dump.String(), again.String())
+
+	rows := func(db *sqlite.Database, sql string) []map[string]any {
+		var rows []map[string]any
+		err := db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+			stmt, err := conn.Prepare(ctx, sql)
+			if err != nil {
+				return err
+			}
+			defer stmt.Reset()
+
+			rows, err = sqlite.ScanAllMaps(stmt)
+			return err
+		})
+		assert.NoError(t, err)
+		return rows
+	}
+
+	for _, sql := range []string{
+		`SELECT * FROM dump_users ORDER BY id;`,
+		`SELECT * FROM dump_posts ORDER BY id;`,
+		`SELECT * FROM dump_user_posts ORDER BY title;`,
+		`SELECT * FROM sqlite_sequence;`,
+		`SELECT type, name, sql FROM sqlite_master ORDER BY name;`,
+		`SELECT rowid FROM dump_posts_fts WHERE dump_posts_fts MATCH 'searchable';`,
+	} {
+		assert.Equal(t, rows(db, sql), rows(restored, sql), sql)
+	}
+
+	assert.Equal(t, []map[string]any{{"rowid": int64(3)}}, rows(restored, `SELECT rowid FROM dump_posts_fts WHERE dump_posts_fts MATCH 'searchable';`))
+	assert.Equal(t, []map[string]any{{"seq": int64(3)}}, rows(restored, `SELECT seq FROM sqlite_sequence WHERE name = 'dump_users';`))
+	assert.Equal(t, []map[string]any{{"type": "real"}}, rows(restored, `SELECT typeof(score) AS type FROM dump_users WHERE id = 1;`))
+
+	// the trigger works in the copy
+	err = restored.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.Exec(ctx, `UPDATE dump_users SET name = 'boss2' WHERE id = 3;`)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]any{{"title": "world!"}, {"title": "searchable words!"}}, rows(restored, `SELECT title FROM dump_posts WHERE user_id = 3 ORDER BY id;`))
+}
@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrFTS5Unavailable is returned by CreateFTS5 when SQLite was built without
+// the FTS5 extension
+var ErrFTS5Unavailable = errors.New("database fts5 extension is not available")
+
+// CreateFTS5 creates the FTS5 table name indexing columns of contentTable, as
+// an external content table so the text isn't stored twice. Triggers on
+// contentTable keep the index in sync on insert, update and delete, and rows
+// already in contentTable are indexed right away. Use Conn.Search to query it.
+func CreateFTS5(ctx context.Context, conn *Conn, name string, columns []string, contentTable string) error {
+	if !isIdentifierPart(name) || !isIdentifierPart(contentTable) {
+		return fmt.Errorf("%w: invalid fts5 or content table name %q, %q", ErrPrepareSQL, name, contentTable)
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("%w: fts5 table %s needs at least one column", ErrPrepareSQL, name)
+	}
+	for _, column := range columns {
+		if !isIdentifierPart(column) {
+			return fmt.Errorf("%w: invalid fts5 column name %q", ErrPrepareSQL, column)
+		}
+	}
+
+	enabled, err := QueryRow(ctx, conn, func(stmt *Stmt) (bool, error) {
+		return stmt.ColumnBool(0), nil
+	}, `SELECT sqlite_compileoption_used('ENABLE_FTS5');`)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrFTS5Unavailable
+	}
+
+	cols := strings.Join(columns, ", ")
+	newCols := "new." + strings.Join(columns, ", new.")
+	oldCols := "old." + strings.Join(columns, ", old.")
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s', content_rowid='rowid');\n", name, cols, contentTable)
+	fmt.Fprintf(&sb, "CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN\n", name, contentTable)
+	fmt.Fprintf(&sb, "\tINSERT INTO %s (rowid, %s) VALUES (new.rowid, %s);\nEND;\n", name, cols, newCols)
+	fmt.Fprintf(&sb, "CREATE TRIGGER %s_ad AFTER DELETE ON %s BEGIN\n", name, contentTable)
+	fmt.Fprintf(&sb, "\tINSERT INTO %s (%s, rowid, %s) VALUES ('delete', old.rowid, %s);\nEND;\n", name, name, cols, oldCols)
+	fmt.Fprintf(&sb, "CREATE TRIGGER %s_au AFTER UPDATE ON %s BEGIN\n", name, contentTable)
+	fmt.Fprintf(&sb, "\tINSERT INTO %s (%s, rowid, %s) VALUES ('delete', old.rowid, %s);\n", name, name, cols, oldCols)
+	fmt.Fprintf(&sb, "\tINSERT INTO %s (rowid, %s) VALUES (new.rowid, %s);\nEND;\n", name, cols, newCols)
+	fmt.Fprintf(&sb, "INSERT INTO %s (%s) VALUES ('rebuild');\n", name, name)
+
+	return conn.ExecScript(sb.String())
+}
+
+// Search runs an FTS5 query against table, created with CreateFTS5, and
+// returns the matching rows best first. Every indexed column is selected
+// along with rowid and the bm25 score as rank, lower being more relevant.
+// The caller steps the returned statement and resets it when done.
+func (c *Conn) Search(ctx context.Context, table, query string) (*Stmt, error) {
+	if !isIdentifierPart(table) {
+		return nil, fmt.Errorf("%w: invalid fts5 table name %q", ErrPrepareSQL, table)
+	}
+
+	return c.Prepare(ctx, fmt.Sprintf(`SELECT rowid, *, bm25(%s) AS rank FROM %s WHERE %s MATCH ? ORDER BY rank;`, table, table, table), query)
+}
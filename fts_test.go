@@ -0,0 +1,66 @@
+package sqlite_test
+
+import (
+	"context"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateFTS5(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		DROP TABLE IF EXISTS fts_posts_search;
+		DROP TABLE IF EXISTS fts_posts;
+		CREATE TABLE fts_posts (id INTEGER PRIMARY KEY, title TEXT, body TEXT);
+		INSERT INTO fts_posts (title, body) VALUES ('Gardening', 'How to grow tomatoes in a small garden');
+	`)
+	assert.NoError(t, err)
+
+	err = sqlite.CreateFTS5(ctx, conn, "fts_posts_search", []string{"title", "body"}, "fts_posts")
+	assert.NoError(t, err)
+
+	err = conn.ExecScript(`
+		INSERT INTO fts_posts (title, body) VALUES ('Cooking', 'A tomato sauce recipe');
+		INSERT INTO fts_posts (title, body) VALUES ('Tomatoes', 'Tomatoes, tomatoes everywhere');
+		INSERT INTO fts_posts (title, body) VALUES ('Travel', 'Notes from a trip');
+		UPDATE fts_posts SET body = 'Tomatoes in the sauce' WHERE title = 'Cooking';
+		DELETE FROM fts_posts WHERE title = 'Travel';
+	`)
+	assert.NoError(t, err)
+
+	search := func(query string) []string {
+		stmt, err := conn.Search(ctx, "fts_posts_search", query)
+		assert.NoError(t, err)
+		defer stmt.Reset()
+
+		var titles []string
+		for {
+			hasRow, err := stmt.Step()
+			assert.NoError(t, err)
+			if !hasRow {
+				break
+			}
+			titles = append(titles, stmt.GetText("title"))
+		}
+		return titles
+	}
+
+	titles := search("tomatoes")
+	assert.ElementsMatch(t, []string{"Tomatoes", "Gardening", "Cooking"}, titles)
+	assert.Equal(t, "Tomatoes", titles[0])
+	assert.Equal(t, []string{"Cooking"}, search("sauce"))
+	assert.Empty(t, search("trip"))
+}
@@ -0,0 +1,16 @@
+package sqlite
+
+// NewScalarFunc builds the FunctionImpl of a scalar function taking nArgs
+// arguments, -1 for any number, to be passed to WithFunctions. Only
+// deterministic functions, which always return the same result for the same
+// arguments, can be used in indexes, generated columns and CHECK constraints,
+// so they are also allowed outside of top level statements (AllowIndirect).
+// Non-deterministic functions can only be called directly from queries.
+func NewScalarFunc(nArgs int, deterministic bool, fn func(ctx Context, args []Value) (Value, error)) *FunctionImpl {
+	return &FunctionImpl{
+		NArgs:         nArgs,
+		Scalar:        fn,
+		Deterministic: deterministic,
+		AllowIndirect: deterministic,
+	}
+}
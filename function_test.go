@@ -0,0 +1,200 @@
+package sqlite_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+// geometricMean is an aggregate computing the geometric mean of its argument
+type geometricMean struct {
+	logSum float64
+	count  int64
+}
+
+func (g *geometricMean) Step(ctx sqlite.Context, args []sqlite.Value) error {
+	g.logSum += math.Log(args[0].Float())
+	g.count++
+	return nil
+}
+
+func (g *geometricMean) WindowInverse(ctx sqlite.Context, args []sqlite.Value) error {
+	g.logSum -= math.Log(args[0].Float())
+	g.count--
+	return nil
+}
+
+func (g *geometricMean) WindowValue(ctx sqlite.Context) (sqlite.Value, error) {
+	if g.count == 0 {
+		return sqlite.Value{}, nil
+	}
+	return sqlite.FloatValue(math.Exp(g.logSum / float64(g.count))), nil
+}
+
+func (g *geometricMean) Finalize(ctx sqlite.Context) {}
+
+func TestWithFunctionsAggregate(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(
+		ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(2),
+		sqlite.WithFunctions(map[string]*sqlite.FunctionImpl{
+			"geomean": {
+				NArgs:         1,
+				Deterministic: true,
+				MakeAggregate: func(ctx sqlite.Context) (sqlite.AggregateFunction, error) {
+					return new(geometricMean), nil
+				},
+			},
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE IF NOT EXISTS geomean_values (value REAL);
+			DELETE FROM geomean_values;
+			INSERT INTO geomean_values (value) VALUES (2), (8), (4);
+		`)
+	})
+	assert.NoError(t, err)
+
+	geomean := func(conn *sqlite.Conn) float64 {
+		value, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (float64, error) {
+			return stmt.GetFloat("mean"), nil
+		}, `SELECT geomean(value) AS mean FROM geomean_values;`)
+		assert.NoError(t, err)
+		return value
+	}
+
+	// every pooled connection has the aggregate registered
+	first, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	second, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 4.0, geomean(first), 1e-9)
+	assert.InDelta(t, 4.0, geomean(second), 1e-9)
+
+	first.Done()
+	second.Done()
+
+	// and keeps it across checkouts
+	for range 4 {
+		err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+			assert.InDelta(t, 4.0, geomean(conn), 1e-9)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+}
+
+func TestNewScalarFunc(t *testing.T) {
+	ctx := context.Background()
+
+	normalize := func(ctx sqlite.Context, args []sqlite.Value) (sqlite.Value, error) {
+		return sqlite.TextValue(strings.ToLower(strings.TrimSpace(args[0].Text()))), nil
+	}
+
+	db, err := sqlite.New(
+		ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(1),
+		sqlite.WithFunctions(map[string]*sqlite.FunctionImpl{
+			"normalize":        sqlite.NewScalarFunc(1, true, normalize),
+			"normalize_unsafe": sqlite.NewScalarFunc(1, false, normalize),
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		DROP TABLE IF EXISTS scalar_users;
+		CREATE TABLE scalar_users (email TEXT);
+		CREATE UNIQUE INDEX scalar_users_email ON scalar_users (normalize(email));
+	`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO scalar_users (email) VALUES (?);`, "John@Example.com")
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO scalar_users (email) VALUES (?);`, "  john@example.COM ")
+	assert.True(t, sqlite.IsUniqueViolation(err))
+
+	err = conn.ExecScript(`CREATE INDEX scalar_users_unsafe ON scalar_users (normalize_unsafe(email));`)
+	assert.Error(t, err)
+}
+
+func TestWithCollation(t *testing.T) {
+	ctx := context.Background()
+
+	// folds case for any script, not only ASCII like NOCASE
+	unicodeNocase := func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(2),
+		sqlite.WithCollation("unicode_nocase", unicodeNocase),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE collation_words (word TEXT);
+			INSERT INTO collation_words (word) VALUES ('Émile'), ('éclair'), ('Zoé'), ('apple');
+		`)
+	})
+	assert.NoError(t, err)
+
+	words := func(conn *sqlite.Conn, collation string) []string {
+		var words []string
+		for stmt, err := range conn.Query(ctx, `SELECT word FROM collation_words ORDER BY word COLLATE `+collation+`;`) {
+			assert.NoError(t, err)
+			words = append(words, stmt.GetText("word"))
+		}
+		return words
+	}
+
+	// both connections of the pool have it
+	conn1, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn1.Done()
+
+	conn2, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn2.Done()
+
+	for _, conn := range []*sqlite.Conn{conn1, conn2} {
+		assert.Equal(t, []string{"apple", "Zoé", "éclair", "Émile"}, words(conn, "unicode_nocase"))
+	}
+
+	// NOCASE only folds ASCII, so É sorts before é whatever follows
+	assert.Equal(t, []string{"apple", "Zoé", "Émile", "éclair"}, words(conn1, "NOCASE"))
+
+	count, err := sqlite.QueryRow(ctx, conn1, func(stmt *sqlite.Stmt) (int64, error) {
+		return stmt.GetInt64("count"), nil
+	}, `SELECT COUNT(*) AS count FROM collation_words WHERE word = 'ÉCLAIR' COLLATE unicode_nocase;`)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
@@ -31,21 +31,29 @@ func calculateDerivedPosition(latitude, longitude, distance, bearing float64) (l
 	return lat, lon
 }
 
-func CreateCondSQL(latitude, longitude, distance float64) string {
+// boundingBox returns the box around latitude, longitude that contains every
+// point within distance meters, with a 10% margin
+func boundingBox(latitude, longitude, distance float64) (minLat, maxLat, minLon, maxLon float64) {
 	const mult float64 = 1.1
 
+	maxLat, _ = calculateDerivedPosition(latitude, longitude, mult*distance, 0)
+	_, maxLon = calculateDerivedPosition(latitude, longitude, mult*distance, 90)
+	minLat, _ = calculateDerivedPosition(latitude, longitude, mult*distance, 180)
+	_, minLon = calculateDerivedPosition(latitude, longitude, mult*distance, 270)
+
+	return minLat, maxLat, minLon, maxLon
+}
+
+func CreateCondSQL(latitude, longitude, distance float64) string {
 	var sb strings.Builder
 
 	if latitude != 0 && longitude != 0 {
-		latitude1, _ := calculateDerivedPosition(latitude, longitude, mult*distance, 0)
-		_, longitude2 := calculateDerivedPosition(latitude, longitude, mult*distance, 90)
-		latitude3, _ := calculateDerivedPosition(latitude, longitude, mult*distance, 180)
-		_, longitude4 := calculateDerivedPosition(latitude, longitude, mult*distance, 270)
-
-		sb.WriteString(fmt.Sprintf("(latitude > %.6f AND ", latitude3))
-		sb.WriteString(fmt.Sprintf("latitude < %.6f AND ", latitude1))
-		sb.WriteString(fmt.Sprintf("longitude < %.6f AND ", longitude2))
-		sb.WriteString(fmt.Sprintf("longitude > %.6f)", longitude4))
+		minLat, maxLat, minLon, maxLon := boundingBox(latitude, longitude, distance)
+
+		sb.WriteString(fmt.Sprintf("(latitude > %.6f AND ", minLat))
+		sb.WriteString(fmt.Sprintf("latitude < %.6f AND ", maxLat))
+		sb.WriteString(fmt.Sprintf("longitude < %.6f AND ", maxLon))
+		sb.WriteString(fmt.Sprintf("longitude > %.6f)", minLon))
 	}
 
 	if sb.Len() == 0 {
@@ -55,6 +63,26 @@ func CreateCondSQL(latitude, longitude, distance float64) string {
 	return sb.String()
 }
 
+// BoundingBoxCond works like CreateCondSQL but returns the bounds as args
+// instead of inlining them, so they keep their full precision and the
+// statement text stays the same whatever the point, which lets the statement
+// cache reuse it:
+//
+//	where, args := sqlite.BoundingBoxCond(lat, lon, 500)
+//	conn.Query(ctx, `SELECT * FROM places WHERE `+where, args...)
+//
+// The args are the minimum and maximum latitude, then longitude. Like
+// CreateCondSQL, a zero latitude or longitude gives "1=1" without args.
+func BoundingBoxCond(latitude, longitude, distance float64) (clause string, args []any) {
+	if latitude == 0 || longitude == 0 {
+		return "1=1", nil
+	}
+
+	minLat, maxLat, minLon, maxLon := boundingBox(latitude, longitude, distance)
+
+	return "latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", []any{minLat, maxLat, minLon, maxLon}
+}
+
 func CreateDistanceSQL(latitude, longitude float64) string {
 	var sb strings.Builder
 
@@ -1,9 +1,12 @@
 package sqlite_test
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateSqlCond(t *testing.T) {
@@ -14,3 +17,61 @@ func TestCreateSqlCond(t *testing.T) {
 		t.Errorf("expect '%s' but got this '%s'", expected, cond)
 	}
 }
+
+func TestBoundingBoxCond(t *testing.T) {
+	const lat, lon, distance = 43.6532, -79.3832, 1000
+
+	clause, args := sqlite.BoundingBoxCond(lat, lon, distance)
+	assert.Equal(t, "latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", clause)
+	assert.Len(t, args, 4)
+
+	// same bounds as the literals inlined by CreateCondSQL
+	var minLat, maxLat, minLon, maxLon float64
+	_, err := fmt.Sscanf(sqlite.CreateCondSQL(lat, lon, distance),
+		"(latitude > %f AND latitude < %f AND longitude < %f AND longitude > %f)",
+		&minLat, &maxLat, &maxLon, &minLon,
+	)
+	assert.NoError(t, err)
+
+	for i, want := range []float64{minLat, maxLat, minLon, maxLon} {
+		assert.InDelta(t, want, args[i], 1e-6)
+	}
+	assert.Less(t, args[0], lat)
+	assert.Greater(t, args[1], lat)
+	assert.Less(t, args[2], lon)
+	assert.Greater(t, args[3], lon)
+
+	clause, args = sqlite.BoundingBoxCond(0, lon, distance)
+	assert.Equal(t, "1=1", clause)
+	assert.Nil(t, args)
+
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE places (name TEXT, latitude REAL, longitude REAL);
+		INSERT INTO places (name, latitude, longitude) VALUES
+			('city hall', 43.6534, -79.3841),
+			('union station', 43.6453, -79.3806),
+			('ottawa', 45.4215, -75.6972);
+	`)
+	assert.NoError(t, err)
+
+	clause, args = sqlite.BoundingBoxCond(lat, lon, distance)
+
+	var names []string
+	for stmt, err := range conn.Query(ctx, `SELECT name FROM places WHERE `+clause+` ORDER BY name;`, args...) {
+		assert.NoError(t, err)
+		names = append(names, stmt.GetText("name"))
+	}
+	assert.Equal(t, []string{"city hall", "union station"}, names)
+}
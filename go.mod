@@ -3,7 +3,6 @@ module ella.to/sqlite
 go 1.23.0
 
 require (
-	ella.to/logger v0.0.3
 	github.com/stretchr/testify v1.9.0
 	zombiezen.com/go/sqlite v1.4.0
 )
@@ -16,7 +15,6 @@ require (
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
-	github.com/rs/xid v1.6.0 // indirect
 	golang.org/x/exp v0.0.0-20240909161429-701f63a606c0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
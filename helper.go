@@ -1,24 +1,187 @@
 package sqlite
 
 import (
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"zombiezen.com/go/sqlite"
 )
 
+// LoadTime reads a time.Time stored with the default TimeSeconds encoding
 func LoadTime(stmt *Stmt, key string) time.Time {
 	value := stmt.GetInt64(key)
 	return time.Unix(value, 0).UTC()
 }
 
+// LoadTimeAs reads a time.Time stored with the given encoding,
+// see WithTimeEncoding and Time
+func LoadTimeAs(stmt *Stmt, key string, enc TimeEncoding) (time.Time, error) {
+	return enc.load(stmt, key)
+}
+
+// LoadDuration reads a time.Duration stored as integer nanoseconds
+func LoadDuration(stmt *Stmt, key string) time.Duration {
+	return time.Duration(stmt.GetInt64(key))
+}
+
 func LoadBool(stmt *Stmt, key string) bool {
 	return stmt.GetInt64(key) == 1
 }
 
+// LoadEnum reads an enum stored as an INTEGER, or as TEXT for string based
+// ones, and converts it to T, e.g.
+//
+//	type Color int
+//
+//	color := sqlite.LoadEnum[Color](stmt, "color")
+//
+// Named integer and string types are bound by their underlying kind, so
+// they round-trip as is, even when they implement fmt.Stringer.
+func LoadEnum[T ~int | ~int64 | ~uint | ~string](stmt *Stmt, col string) T {
+	var value T
+
+	rv := reflect.ValueOf(&value).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(stmt.GetText(col))
+	case reflect.Uint:
+		rv.SetUint(uint64(stmt.GetInt64(col)))
+	default:
+		rv.SetInt(stmt.GetInt64(col))
+	}
+
+	return value
+}
+
+// isNull reports whether col is SQL NULL in the current row, a missing
+// column is treated as NULL too
+func isNull(stmt *Stmt, col string) bool {
+	idx := stmt.ColumnIndex(col)
+	return idx < 0 || stmt.ColumnIsNull(idx)
+}
+
+// LoadNullString reads a TEXT column, Valid is false when it's NULL
+func LoadNullString(stmt *Stmt, col string) sql.NullString {
+	if isNull(stmt, col) {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: stmt.GetText(col), Valid: true}
+}
+
+// LoadNullInt64 reads an INTEGER column, Valid is false when it's NULL
+func LoadNullInt64(stmt *Stmt, col string) sql.NullInt64 {
+	if isNull(stmt, col) {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: stmt.GetInt64(col), Valid: true}
+}
+
+// LoadNullFloat64 reads a REAL column, Valid is false when it's NULL
+func LoadNullFloat64(stmt *Stmt, col string) sql.NullFloat64 {
+	if isNull(stmt, col) {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: stmt.GetFloat(col), Valid: true}
+}
+
+// LoadNullTime reads a time stored with the default TimeSeconds encoding like
+// LoadTime does, Valid is false when it's NULL
+func LoadNullTime(stmt *Stmt, col string) sql.NullTime {
+	if isNull(stmt, col) {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: LoadTime(stmt, col), Valid: true}
+}
+
+// Null is a T that can also be NULL, for any T, without going through a
+// pointer or the sql.NullXxx types:
+//
+//	conn.Exec(ctx, `UPDATE users SET age = ? WHERE id = ?;`, sqlite.Null[int]{}, id)
+//
+// It binds NULL if Valid is false and Value as usual otherwise. Read it back
+// with LoadNull.
+type Null[T any] struct {
+	Valid bool
+	Value T
+}
+
+// NullOf returns a valid Null holding value
+func NullOf[T any](value T) Null[T] {
+	return Null[T]{Valid: true, Value: value}
+}
+
+// nullable is implemented by every Null[T], so bindValue can unwrap them
+type nullable interface {
+	nullValue() any
+}
+
+func (n Null[T]) nullValue() any {
+	if !n.Valid {
+		return nil
+	}
+	return n.Value
+}
+
+// LoadNull reads col as a Null[T], Valid is false when it's NULL. Strings,
+// numbers, bools, []byte, time.Duration and time.Time (stored with the
+// default TimeSeconds encoding) are read like the LoadXxx helpers do, any
+// other T is decoded from JSON, the way slices and maps are bound. A value
+// that can't be decoded reads as NULL.
+func LoadNull[T any](stmt *Stmt, col string) Null[T] {
+	var n Null[T]
+	if isNull(stmt, col) {
+		return n
+	}
+
+	switch v := any(&n.Value).(type) {
+	case *time.Time:
+		*v = LoadTime(stmt, col)
+		n.Valid = true
+		return n
+	case *time.Duration:
+		*v = LoadDuration(stmt, col)
+		n.Valid = true
+		return n
+	}
+
+	rv := reflect.ValueOf(&n.Value).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(stmt.GetText(col))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(stmt.GetInt64(col))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		rv.SetUint(uint64(stmt.GetInt64(col)))
+	case reflect.Float32, reflect.Float64:
+		rv.SetFloat(stmt.GetFloat(col))
+	case reflect.Bool:
+		rv.SetBool(LoadBool(stmt, col))
+	default:
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, stmt.GetLen(col))
+			stmt.GetBytes(col, buf)
+			rv.SetBytes(buf)
+			break
+		}
+
+		if json.Unmarshal([]byte(stmt.GetText(col)), &n.Value) != nil {
+			return Null[T]{}
+		}
+	}
+
+	n.Valid = true
+	return n
+}
+
 func LoadJsonMap[T any](stmt *Stmt, col string) (map[string]T, error) {
 	var mapper map[string]T
 	err := json.NewDecoder(stmt.GetReader(col)).Decode(&mapper)
@@ -41,6 +204,52 @@ func LoadJsonArray[T any](stmt *Stmt, col string) ([]T, error) {
 	return array, nil
 }
 
+// LoadJson decodes any JSON value stored in col into T. It works for scalars
+// (int, string, bool, ...), slices, maps and structs alike. An empty column
+// returns the zero value of T.
+func LoadJson[T any](stmt *Stmt, col string) (T, error) {
+	var value T
+	err := json.NewDecoder(stmt.GetReader(col)).Decode(&value)
+	// NOTE: we need to check for io.EOF because json.NewDecoder returns io.EOF when the input is empty
+	// this is not an error, we can just return the zero value
+	if err != nil && !errors.Is(err, io.EOF) {
+		return value, err
+	}
+	return value, nil
+}
+
+// GetJSONInto decodes the JSON value in col into dst, meant for the result of
+// json_extract and friends:
+//
+//	SELECT json_extract(data, '$.address.city') AS city FROM users;
+//
+// json_extract returns objects and arrays as JSON text but strings without
+// their quotes, so a TEXT value that isn't valid JSON for dst is decoded as a
+// JSON string instead. A NULL or empty column, e.g. a missing path, leaves
+// dst untouched.
+func GetJSONInto[T any](stmt *Stmt, col string, dst *T) error {
+	if isNull(stmt, col) {
+		return nil
+	}
+
+	text := stmt.GetText(col)
+	if text == "" {
+		return nil
+	}
+
+	err := json.Unmarshal([]byte(text), dst)
+	if err == nil || stmt.ColumnType(stmt.ColumnIndex(col)) != sqlite.TypeText {
+		return err
+	}
+
+	quoted, _ := json.Marshal(text)
+	if json.Unmarshal(quoted, dst) == nil {
+		return nil
+	}
+
+	return err
+}
+
 // Placeholders returns a string of ? separated by commas
 func Placeholders(count int) string {
 	var sb strings.Builder
@@ -75,46 +284,282 @@ func GroupPlaceholders(numRows, numCols int) string {
 	return sb.String()
 }
 
+// ExpandSlices rewrites every `?` whose value is a slice into one placeholder
+// per element and flattens the slice into the returned values, so
+//
+//	ExpandSlices(`SELECT * FROM users WHERE id IN (?) AND age > ?`, []int{1, 2, 3}, 18)
+//
+// returns `SELECT * FROM users WHERE id IN (?, ?, ?) AND age > ?` and
+// [1, 2, 3, 18]. Byte slices ([]byte, json.RawMessage) are blobs and are never
+// expanded. Placeholders inside string literals and comments are ignored.
+//
+// An empty slice becomes `SELECT 1 WHERE 0`, so `id IN (?)` turns into
+// `id IN (SELECT 1 WHERE 0)`, which matches nothing, and `NOT IN` everything.
+// It's meant for IN lists only.
+//
+// Values are matched to plain `?` placeholders in order, so numbered (?NNN)
+// and named (:name, @name, $name) parameters can't be used along with a slice:
+// it fails with ErrPrepareSQL instead of shifting their numbers.
+func ExpandSlices(sql string, values ...any) (string, []any, error) {
+	if slices.ContainsFunc(values, isExpandable) {
+		if param := numberedOrNamedParam(sql); param != "" {
+			return "", nil, fmt.Errorf("%w: %s can't be used along with slice values, use plain ? placeholders", ErrPrepareSQL, param)
+		}
+	}
+
+	var sb strings.Builder
+	expanded := make([]any, 0, len(values))
+
+	pos := 0
+	for i, value := range values {
+		idx := nextPlaceholder(sql, pos)
+		if idx == -1 {
+			return "", nil, fmt.Errorf("%w: %d values but only %d placeholders", ErrPrepareSQL, len(values), i)
+		}
+
+		sb.WriteString(sql[pos:idx])
+		pos = idx + 1
+
+		if !isExpandable(value) {
+			sb.WriteString("?")
+			expanded = append(expanded, value)
+			continue
+		}
+
+		rv := reflect.ValueOf(value)
+		if rv.Len() == 0 {
+			// IN () is a syntax error, an empty subquery is not
+			sb.WriteString(emptyInList)
+			continue
+		}
+
+		placeholders(rv.Len(), &sb)
+		for j := 0; j < rv.Len(); j++ {
+			expanded = append(expanded, rv.Index(j).Interface())
+		}
+	}
+
+	sb.WriteString(sql[pos:])
+
+	return sb.String(), expanded, nil
+}
+
+// emptyInList replaces the placeholder of an empty slice, see ExpandSlices
+const emptyInList = "SELECT 1 WHERE 0"
+
+func isExpandable(value any) bool {
+	if value == nil {
+		return false
+	}
+	typ := reflect.TypeOf(value)
+	return typ.Kind() == reflect.Slice && typ.Elem().Kind() != reflect.Uint8
+}
+
+// numberedOrNamedParam returns the first ?NNN, :name, @name or $name
+// parameter of sql, or "" if it only has plain ? placeholders
+func numberedOrNamedParam(sql string) string {
+	for i := nextParam(sql, 0, "?:@$"); i != -1; i = nextParam(sql, i+1, "?:@$") {
+		// $ can be part of an identifier
+		if sql[i] != '?' && i > 0 && isWordChar(sql[i-1]) {
+			continue
+		}
+
+		end := i + 1
+		if sql[i] == '?' {
+			for end < len(sql) && sql[end] >= '0' && sql[end] <= '9' {
+				end++
+			}
+		} else {
+			for end < len(sql) && isWordChar(sql[end]) {
+				end++
+			}
+		}
+
+		if end > i+1 {
+			return sql[i:end]
+		}
+	}
+
+	return ""
+}
+
+// nextPlaceholder returns the index of the next `?` in sql starting from pos,
+// skipping string literals, quoted identifiers and comments. It returns -1 if
+// there is none.
+func nextPlaceholder(sql string, pos int) int {
+	return nextParam(sql, pos, "?")
+}
+
+// nextParam is nextPlaceholder for any of the bytes in chars
+func nextParam(sql string, pos int, chars string) int {
+	for i := pos; i < len(sql); i++ {
+		if strings.IndexByte(chars, sql[i]) != -1 {
+			return i
+		}
+
+		switch sql[i] {
+		case '\'', '"', '`':
+			end := strings.IndexByte(sql[i+1:], sql[i])
+			if end == -1 {
+				return -1
+			}
+			i += end + 1
+		case '-':
+			if i+1 < len(sql) && sql[i+1] == '-' {
+				end := strings.IndexByte(sql[i:], '\n')
+				if end == -1 {
+					return -1
+				}
+				i += end
+			}
+		case '/':
+			if i+1 < len(sql) && sql[i+1] == '*' {
+				end := strings.Index(sql[i+2:], "*/")
+				if end == -1 {
+					return -1
+				}
+				i += end + 3
+			}
+		}
+	}
+
+	return -1
+}
+
+// ShowSql renders sql with its args inlined as SQL literals, which is handy
+// for logs. Strings are quoted with embedded quotes doubled, blobs are
+// rendered as x'...' hex literals, bools as 1 or 0, slices and maps as
+// quoted JSON and time.Time as Unix seconds (the default TimeEncoding).
 func ShowSql(sql string, args ...any) string {
-	var temp2 []string
+	return showSql(TimeSeconds, sql, args...)
+}
 
-	temp := strings.FieldsFunc(sql, func(r rune) bool {
+func showSql(enc TimeEncoding, sql string, args ...any) string {
+	sql = strings.Join(strings.FieldsFunc(sql, func(r rune) bool {
 		switch r {
 		case '\t', '\n', ' ':
 			return true
 		default:
 			return false
 		}
-	})
-	for _, tmp := range temp {
-		if tmp != "" {
-			temp2 = append(temp2, tmp)
+	}), " ")
+
+	var sb strings.Builder
+
+	pos, next := 0, 0
+	for {
+		idx := nextPlaceholder(sql, pos)
+		if idx == -1 {
+			break
+		}
+
+		sb.WriteString(sql[pos:idx])
+
+		// ?NNN refers to args[NNN-1], a plain ? to the one after the
+		// highest number so far
+		end := idx + 1
+		for end < len(sql) && sql[end] >= '0' && sql[end] <= '9' {
+			end++
+		}
+
+		arg := next
+		if end > idx+1 {
+			n, _ := strconv.Atoi(sql[idx+1 : end])
+			arg = n - 1
+		}
+		next = max(next, arg+1)
+
+		if arg >= 0 && arg < len(args) {
+			sb.WriteString(sqlLiteral(enc, args[arg]))
+		} else {
+			sb.WriteString(sql[idx:end])
 		}
+		pos = end
 	}
 
-	newArgs := []any{}
-	for _, arg := range args {
-		switch v := arg.(type) {
-		case string:
-			arg = fmt.Sprintf("'%s'", v)
-		case time.Time:
-			arg = fmt.Sprintf("%d", v.Unix())
+	sb.WriteString(sql[pos:])
+
+	return sb.String()
+}
+
+// sqlLiteral renders arg the way bindValue would bind it
+func sqlLiteral(enc TimeEncoding, arg any) string {
+	switch v := arg.(type) {
+	case nil:
+		return "NULL"
+	case nullable:
+		return sqlLiteral(enc, v.nullValue())
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10)
+	case time.Time:
+		return sqlLiteral(enc, enc.encode(v))
+	case TimeValue:
+		return sqlLiteral(enc, v.Encoding.encode(v.Time))
+	}
+
+	value := reflect.ValueOf(arg)
+
+	switch value.Kind() {
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return "x'" + hex.EncodeToString(value.Bytes()) + "'"
+		}
+		fallthrough
+	case reflect.Map:
+		b, _ := json.Marshal(arg)
+		return quoteString(string(b))
+	case reflect.String:
+		return quoteString(value.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// named numeric types are bound by kind even if they implement
+		// fmt.Stringer, like an enum
+		return strconv.FormatInt(value.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatInt(int64(value.Uint()), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'g', -1, 64)
+	case reflect.Bool:
+		// bound as 1 or 0, like SQLite stores them
+		if value.Bool() {
+			return "1"
+		}
+		return "0"
+	}
+
+	if v, ok := arg.(fmt.Stringer); ok {
+		return quoteString(v.String())
+	}
+
+	return fmt.Sprintf("%v", arg)
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// isIdentifier reports whether name is safe to put into sql as is, that is
+// made of letters, digits and underscores and not starting with a digit.
+// A single dot is allowed to qualify a table with its schema.
+func isIdentifier(name string) bool {
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return isIdentifierPart(schema) && isIdentifierPart(table)
+	}
+	return isIdentifierPart(name)
+}
+
+func isIdentifierPart(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
 		default:
-			if v == nil {
-				arg = "NULL"
-				break
-			}
-			kind := reflect.TypeOf(v).Kind()
-			if kind == reflect.Slice || kind == reflect.Map {
-				b, _ := json.Marshal(v)
-				arg = fmt.Sprintf("'%v'", string(b))
-				break
-			}
-			arg = fmt.Sprintf("%v", v)
+			return false
 		}
-		newArgs = append(newArgs, arg)
 	}
 
-	format := strings.ReplaceAll(strings.Join(temp2, " "), "?", "%v")
-	return fmt.Sprintf(format, newArgs...)
+	return true
 }
@@ -1,9 +1,14 @@
 package sqlite_test
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestPlaceholders(t *testing.T) {
@@ -48,3 +53,460 @@ func TestGroupPlaceholders(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadJson(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS json_values (name TEXT, value TEXT);`)
+	assert.NoError(t, err)
+
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+
+	values := map[string]string{
+		"int":    `42`,
+		"string": `"hello"`,
+		"bool":   `true`,
+		"array":  `[1, 2, 3]`,
+		"map":    `{"a": 1}`,
+		"struct": `{"x": 1, "y": 2}`,
+		"empty":  ``,
+	}
+
+	for name, value := range values {
+		stmt, err := conn.Prepare(ctx, `INSERT INTO json_values (name, value) VALUES (?, ?);`, name, value)
+		assert.NoError(t, err)
+		_, err = stmt.Step()
+		assert.NoError(t, err)
+	}
+
+	load := func(name string, fn func(stmt *sqlite.Stmt)) {
+		stmt, err := conn.Prepare(ctx, `SELECT value FROM json_values WHERE name = ?;`, name)
+		assert.NoError(t, err)
+		defer stmt.Reset()
+
+		hasRow, err := stmt.Step()
+		assert.NoError(t, err)
+		assert.True(t, hasRow)
+
+		fn(stmt)
+	}
+
+	load("int", func(stmt *sqlite.Stmt) {
+		v, err := sqlite.LoadJson[int](stmt, "value")
+		assert.NoError(t, err)
+		assert.Equal(t, 42, v)
+	})
+
+	load("string", func(stmt *sqlite.Stmt) {
+		v, err := sqlite.LoadJson[string](stmt, "value")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", v)
+	})
+
+	load("bool", func(stmt *sqlite.Stmt) {
+		v, err := sqlite.LoadJson[bool](stmt, "value")
+		assert.NoError(t, err)
+		assert.True(t, v)
+	})
+
+	load("array", func(stmt *sqlite.Stmt) {
+		v, err := sqlite.LoadJson[[]int](stmt, "value")
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3}, v)
+	})
+
+	load("map", func(stmt *sqlite.Stmt) {
+		v, err := sqlite.LoadJson[map[string]int](stmt, "value")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"a": 1}, v)
+	})
+
+	load("struct", func(stmt *sqlite.Stmt) {
+		v, err := sqlite.LoadJson[point](stmt, "value")
+		assert.NoError(t, err)
+		assert.Equal(t, point{X: 1, Y: 2}, v)
+	})
+
+	load("empty", func(stmt *sqlite.Stmt) {
+		v, err := sqlite.LoadJson[int](stmt, "value")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, v)
+	})
+}
+
+func TestExpandSlices(t *testing.T) {
+	testCases := []struct {
+		sql        string
+		values     []any
+		wantSql    string
+		wantValues []any
+	}{
+		{
+			`SELECT * FROM users WHERE id = ?`,
+			[]any{1},
+			`SELECT * FROM users WHERE id = ?`,
+			[]any{1},
+		},
+		{
+			`SELECT * FROM users WHERE id IN (?) AND age > ?`,
+			[]any{[]int{1, 2, 3}, 18},
+			`SELECT * FROM users WHERE id IN (?, ?, ?) AND age > ?`,
+			[]any{1, 2, 3, 18},
+		},
+		{
+			`SELECT * FROM users WHERE name = '?' AND id IN (?) -- ?`,
+			[]any{[]string{"a", "b"}},
+			`SELECT * FROM users WHERE name = '?' AND id IN (?, ?) -- ?`,
+			[]any{"a", "b"},
+		},
+		{
+			`INSERT INTO files (data) VALUES (?)`,
+			[]any{[]byte("blob")},
+			`INSERT INTO files (data) VALUES (?)`,
+			[]any{[]byte("blob")},
+		},
+		{
+			`SELECT * FROM users WHERE id IN (?) AND kind IN (?)`,
+			[]any{[]int64{7, 8}, []string{"admin", "staff"}},
+			`SELECT * FROM users WHERE id IN (?, ?) AND kind IN (?, ?)`,
+			[]any{int64(7), int64(8), "admin", "staff"},
+		},
+		{
+			`SELECT * FROM users WHERE id IN (?) AND age > ?`,
+			[]any{[]string{}, 18},
+			`SELECT * FROM users WHERE id IN (SELECT 1 WHERE 0) AND age > ?`,
+			[]any{18},
+		},
+	}
+
+	for _, tc := range testCases {
+		gotSql, gotValues, err := sqlite.ExpandSlices(tc.sql, tc.values...)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.wantSql, gotSql)
+		assert.Equal(t, tc.wantValues, gotValues)
+	}
+
+	_, _, err := sqlite.ExpandSlices(`SELECT ?`, 1, 2)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	// numbered and named parameters would be shifted by the expansion
+	for _, sql := range []string{
+		`SELECT * FROM users WHERE id IN (?1) AND age > ?`,
+		`SELECT * FROM users WHERE id IN (?) AND age > :age`,
+		`SELECT * FROM users WHERE id IN (?) AND age > @age`,
+		`SELECT * FROM users WHERE id IN (?) AND age > $age`,
+	} {
+		_, _, err = sqlite.ExpandSlices(sql, []int{1, 2}, 3)
+		assert.ErrorIs(t, err, sqlite.ErrPrepareSQL, sql)
+	}
+
+	// without a slice, or when they only look like parameters, they're fine
+	gotSql, _, err := sqlite.ExpandSlices(`SELECT ?1, ?2`, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT ?1, ?2`, gotSql)
+
+	gotSql, _, err = sqlite.ExpandSlices(`SELECT price$usd, ':name' FROM items WHERE id IN (?)`, []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT price$usd, ':name' FROM items WHERE id IN (?, ?)`, gotSql)
+}
+
+func TestShowSql(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		sql  string
+		args []any
+		want string
+	}{
+		{
+			"SELECT *\n\tFROM users\n\tWHERE name = ?",
+			[]any{"O'Brien"},
+			"SELECT * FROM users WHERE name = 'O''Brien'",
+		},
+		{
+			`INSERT INTO users (id, name, age, score, active) VALUES (?, ?, ?, ?, ?)`,
+			[]any{1, nil, uint8(30), 1.5, true},
+			`INSERT INTO users (id, name, age, score, active) VALUES (1, NULL, 30, 1.5, 1)`,
+		},
+		{
+			`INSERT INTO files (data, raw) VALUES (?, ?)`,
+			[]any{[]byte{0xde, 0xad, 0xbe, 0xef}, json.RawMessage(`{}`)},
+			`INSERT INTO files (data, raw) VALUES (x'deadbeef', x'7b7d')`,
+		},
+		{
+			`INSERT INTO docs (data, tags) VALUES (?, ?)`,
+			[]any{map[string]any{"user": map[string]any{"name": "it's"}}, []string{"a"}},
+			`INSERT INTO docs (data, tags) VALUES ('{"user":{"name":"it''s"}}', '["a"]')`,
+		},
+		{
+			`SELECT * FROM events WHERE at > ? AND at < ? AND ttl = ?`,
+			[]any{at, sqlite.Time(at, sqlite.TimeRFC3339), time.Second},
+			`SELECT * FROM events WHERE at > 1704164645 AND at < '2024-01-02T03:04:05Z' AND ttl = 1000000000`,
+		},
+		{
+			`SELECT * FROM users WHERE name LIKE '%?%' AND id = ?`,
+			[]any{7},
+			`SELECT * FROM users WHERE name LIKE '%?%' AND id = 7`,
+		},
+		{
+			`SELECT * FROM users WHERE (a = ?1 OR b = ?1) AND c > ?2 AND d = ?`,
+			[]any{"x", 10, true},
+			`SELECT * FROM users WHERE (a = 'x' OR b = 'x') AND c > 10 AND d = 1`,
+		},
+		{
+			`UPDATE users SET active = ?`,
+			[]any{false},
+			`UPDATE users SET active = 0`,
+		},
+		{
+			`UPDATE items SET color = ?, size = ?, weight = ?`,
+			[]any{Green, Size("large"), float32(0.5)},
+			`UPDATE items SET color = 2, size = 'large', weight = 0.5`,
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, sqlite.ShowSql(tc.sql, tc.args...))
+	}
+}
+
+func TestLoadNull(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	now := time.Now().Truncate(time.Second).UTC()
+
+	type row struct {
+		name    sql.NullString
+		age     sql.NullInt64
+		score   sql.NullFloat64
+		created sql.NullTime
+	}
+
+	scan := func(stmt *sqlite.Stmt) (row, error) {
+		return row{
+			name:    sqlite.LoadNullString(stmt, "name"),
+			age:     sqlite.LoadNullInt64(stmt, "age"),
+			score:   sqlite.LoadNullFloat64(stmt, "score"),
+			created: sqlite.LoadNullTime(stmt, "created"),
+		}, nil
+	}
+
+	zeros, err := sqlite.QueryRow(ctx, conn, scan, `SELECT ? AS name, ? AS age, ? AS score, ? AS created;`, "", 0, 0.0, time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, row{
+		name:    sql.NullString{Valid: true},
+		age:     sql.NullInt64{Valid: true},
+		score:   sql.NullFloat64{Valid: true},
+		created: sql.NullTime{Time: time.Unix(0, 0).UTC(), Valid: true},
+	}, zeros)
+
+	nulls, err := sqlite.QueryRow(ctx, conn, scan, `SELECT NULL AS name, NULL AS age, NULL AS score, NULL AS created;`)
+	assert.NoError(t, err)
+	assert.Equal(t, row{}, nulls)
+
+	values, err := sqlite.QueryRow(ctx, conn, scan, `SELECT ? AS name, ? AS age, ? AS score, ? AS created;`, "john", 30, 1.5, now)
+	assert.NoError(t, err)
+	assert.Equal(t, row{
+		name:    sql.NullString{String: "john", Valid: true},
+		age:     sql.NullInt64{Int64: 30, Valid: true},
+		score:   sql.NullFloat64{Float64: 1.5, Valid: true},
+		created: sql.NullTime{Time: now, Valid: true},
+	}, values)
+}
+
+func TestNull(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE null_items (id INTEGER PRIMARY KEY, name TEXT, qty INTEGER, at INTEGER, tags TEXT);`)
+	assert.NoError(t, err)
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	type item struct {
+		name sqlite.Null[string]
+		qty  sqlite.Null[int]
+		at   sqlite.Null[time.Time]
+		tags sqlite.Null[[]string]
+	}
+
+	items := []item{
+		{sqlite.NullOf("apple"), sqlite.NullOf(3), sqlite.NullOf(at), sqlite.NullOf([]string{"red"})},
+		{},
+		// zero values are not NULL
+		{sqlite.NullOf(""), sqlite.NullOf(0), sqlite.NullOf(time.Unix(0, 0).UTC()), sqlite.NullOf([]string{})},
+	}
+
+	for i, it := range items {
+		err = conn.Exec(ctx, `INSERT INTO null_items (id, name, qty, at, tags) VALUES (?, ?, ?, ?, ?);`, i, it.name, it.qty, it.at, it.tags)
+		assert.NoError(t, err)
+	}
+
+	var got []item
+	for stmt, err := range conn.Query(ctx, `SELECT name, qty, at, tags FROM null_items ORDER BY id;`) {
+		assert.NoError(t, err)
+		got = append(got, item{
+			name: sqlite.LoadNull[string](stmt, "name"),
+			qty:  sqlite.LoadNull[int](stmt, "qty"),
+			at:   sqlite.LoadNull[time.Time](stmt, "at"),
+			tags: sqlite.LoadNull[[]string](stmt, "tags"),
+		})
+	}
+	assert.Equal(t, items, got)
+
+	count, err := conn.Count(ctx, "null_items", "name IS NULL AND qty IS NULL AND at IS NULL AND tags IS NULL")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.Equal(t, "SELECT NULL, 'x'", sqlite.ShowSql("SELECT ?, ?", sqlite.Null[string]{}, sqlite.NullOf("x")))
+}
+
+type Color int
+
+const (
+	Red Color = iota + 1
+	Green
+	Blue
+)
+
+func (c Color) String() string {
+	return [...]string{"unknown", "red", "green", "blue"}[c]
+}
+
+type Size string
+
+func TestLoadEnum(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE shirts (color INTEGER NOT NULL, size TEXT NOT NULL);`)
+	assert.NoError(t, err)
+
+	// Color is a fmt.Stringer but is still stored as an integer
+	err = conn.Exec(ctx, `INSERT INTO shirts (color, size) VALUES (?, ?);`, Blue, Size("XL"))
+	assert.NoError(t, err)
+
+	type shirt struct {
+		color Color
+		size  Size
+	}
+
+	got, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (shirt, error) {
+		assert.Equal(t, int64(3), stmt.GetInt64("color"))
+		return shirt{
+			color: sqlite.LoadEnum[Color](stmt, "color"),
+			size:  sqlite.LoadEnum[Size](stmt, "size"),
+		}, nil
+	}, `SELECT color, size FROM shirts;`)
+	assert.NoError(t, err)
+	assert.Equal(t, shirt{color: Blue, size: "XL"}, got)
+}
+
+func TestGetJSONInto(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS json_profiles (id INTEGER PRIMARY KEY, data TEXT);
+		DELETE FROM json_profiles;
+		INSERT INTO json_profiles (id, data) VALUES (1, '{"address": {"city": "Berlin", "zip": "10115"}, "age": 42, "tags": ["a", "b"]}');
+	`)
+	assert.NoError(t, err)
+
+	extract := func(path string, fn func(stmt *sqlite.Stmt)) {
+		stmt, err := conn.Prepare(ctx, `SELECT json_extract(data, ?) AS value FROM json_profiles WHERE id = 1;`, path)
+		assert.NoError(t, err)
+		defer stmt.Reset()
+
+		hasRow, err := stmt.Step()
+		assert.NoError(t, err)
+		assert.True(t, hasRow)
+
+		fn(stmt)
+	}
+
+	extract("$.address.city", func(stmt *sqlite.Stmt) {
+		var city string
+		assert.NoError(t, sqlite.GetJSONInto(stmt, "value", &city))
+		assert.Equal(t, "Berlin", city)
+	})
+
+	extract("$.address.zip", func(stmt *sqlite.Stmt) {
+		var zip string
+		assert.NoError(t, sqlite.GetJSONInto(stmt, "value", &zip))
+		assert.Equal(t, "10115", zip)
+	})
+
+	extract("$.age", func(stmt *sqlite.Stmt) {
+		var age int
+		assert.NoError(t, sqlite.GetJSONInto(stmt, "value", &age))
+		assert.Equal(t, 42, age)
+	})
+
+	extract("$.tags", func(stmt *sqlite.Stmt) {
+		var tags []string
+		assert.NoError(t, sqlite.GetJSONInto(stmt, "value", &tags))
+		assert.Equal(t, []string{"a", "b"}, tags)
+	})
+
+	extract("$.missing", func(stmt *sqlite.Stmt) {
+		name := "unchanged"
+		assert.NoError(t, sqlite.GetJSONInto(stmt, "value", &name))
+		assert.Equal(t, "unchanged", name)
+	})
+
+	extract("$.address.city", func(stmt *sqlite.Stmt) {
+		var n int
+		assert.Error(t, sqlite.GetJSONInto(stmt, "value", &n))
+	})
+}
@@ -0,0 +1,195 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JsonExtract returns a json_extract expression reading path from col, e.g.
+// JsonExtract("data", "address.city") is json_extract(data, '$.address.city').
+// path may start with $ already.
+func JsonExtract(col, path string) string {
+	return "json_extract(" + col + ", " + quoteString(jsonPath(path)) + ")"
+}
+
+// JsonEach returns a json_each table-valued function over col, to be used in
+// a FROM clause:
+//
+//	sql := "SELECT users.id, tag.value FROM users, " + sqlite.JsonEach("users.tags") + " AS tag;"
+func JsonEach(col string) string {
+	return "json_each(" + col + ")"
+}
+
+func jsonPath(path string) string {
+	if strings.HasPrefix(path, "$") {
+		return path
+	}
+	if strings.HasPrefix(path, "[") {
+		return "$" + path
+	}
+	return "$." + path
+}
+
+// WithJSONFunctions makes New fail with a clear error if SQLite was built
+// without the JSON functions that JsonExtract, JsonEach, PatchJSON and
+// UpdateJsonField rely on, instead of failing at the first query using them.
+func WithJSONFunctions() OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.checks = append(db.checks, func(conn *Conn) error {
+			err := conn.Exec(ctx, `SELECT json('{}');`)
+			if err != nil {
+				return fmt.Errorf("sqlite json functions are not available: %w", err)
+			}
+			return nil
+		})
+		return nil
+	}
+}
+
+// UpdateJsonField sets the value at path inside the JSON stored in col using
+// json_set, leaving the rest of the document untouched. Maps, slices and
+// structs are stored as JSON objects and arrays, bools as true and false and
+// other values as JSON scalars. A Null[T] or a driver.Valuer, e.g.
+// sql.NullString, is unwrapped first, so an invalid one stores a JSON null.
+// where is appended after WHERE and bound with args. table and col must be
+// plain identifiers.
+//
+//	conn.UpdateJsonField(ctx, "users", "data", "address.city", "Vancouver", "id = ?", 1)
+func (c *Conn) UpdateJsonField(ctx context.Context, table, col, path string, value any, where string, args ...any) error {
+	if !isIdentifier(table) {
+		return fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	if !isIdentifierPart(col) {
+		return fmt.Errorf("%w: invalid column name %q", ErrPrepareSQL, col)
+	}
+
+	value, err := jsonFieldValue(value)
+	if err != nil {
+		return err
+	}
+
+	placeholder := "?"
+	if isJsonValue(value) {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+		placeholder = "json(?)"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("UPDATE ")
+	sb.WriteString(table)
+	sb.WriteString(" SET ")
+	sb.WriteString(col)
+	sb.WriteString(" = json_set(")
+	sb.WriteString(col)
+	sb.WriteString(", ?, ")
+	sb.WriteString(placeholder)
+	sb.WriteString(")")
+	if where != "" {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(where)
+	}
+	sb.WriteString(";")
+
+	values := make([]any, 0, len(args)+2)
+	values = append(values, jsonPath(path), value)
+	values = append(values, args...)
+
+	return c.Exec(ctx, sb.String(), values...)
+}
+
+// jsonFieldValue unwraps a Null[T] or a driver.Valuer into the value it
+// stands for, nil when it's NULL
+func jsonFieldValue(value any) (any, error) {
+	if n, ok := value.(nullable); ok {
+		return n.nullValue(), nil
+	}
+
+	valuer, ok := value.(driver.Valuer)
+	if !ok {
+		return value, nil
+	}
+
+	if rv := reflect.ValueOf(valuer); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil, nil
+	}
+
+	return valuer.Value()
+}
+
+// isJsonValue reports whether value has to be marshaled and passed through
+// json(): objects and arrays, and bools which would be bound as 1 or 0. A
+// struct implementing fmt.Stringer, e.g. time.Time, is bound as text like
+// bindValue does.
+func isJsonValue(value any) bool {
+	typ := reflect.TypeOf(value)
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ == reflect.TypeFor[time.Time]() || typ == reflect.TypeFor[TimeValue]() {
+		return false
+	}
+
+	if typ.Kind() == reflect.Struct && reflect.PointerTo(typ).Implements(reflect.TypeFor[fmt.Stringer]()) {
+		return false
+	}
+
+	switch typ.Kind() {
+	case reflect.Map, reflect.Struct, reflect.Array, reflect.Bool:
+		return true
+	case reflect.Slice:
+		return typ.Elem().Kind() != reflect.Uint8
+	default:
+		return false
+	}
+}
+
+// PatchJSON merges patch into the JSON stored in col using SQLite's json_patch
+// (RFC 7396 merge patch), so only the given fields change and the rest of the
+// document is left untouched. A nil value in patch removes that field.
+// whereClause is appended as is, with whereArgs bound after the patch.
+// It returns the number of updated rows. table and col must be plain
+// identifiers.
+func PatchJSON(ctx context.Context, conn *Conn, table, col, whereClause string, patch map[string]any, whereArgs ...any) (int, error) {
+	if !isIdentifier(table) {
+		return 0, fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	if !isIdentifierPart(col) {
+		return 0, fmt.Errorf("%w: invalid column name %q", ErrPrepareSQL, col)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("UPDATE ")
+	sb.WriteString(table)
+	sb.WriteString(" SET ")
+	sb.WriteString(col)
+	sb.WriteString(" = json_patch(")
+	sb.WriteString(col)
+	sb.WriteString(", ?)")
+	if whereClause != "" {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(whereClause)
+	}
+	sb.WriteString(";")
+
+	args := make([]any, 0, len(whereArgs)+1)
+	args = append(args, patch)
+	args = append(args, whereArgs...)
+
+	err := conn.Exec(ctx, sb.String(), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return conn.conn.Changes(), nil
+}
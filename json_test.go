@@ -0,0 +1,177 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchJSON(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS json_docs (id INTEGER PRIMARY KEY, data TEXT);`)
+	assert.NoError(t, err)
+
+	doc := map[string]any{
+		"name": "john",
+		"address": map[string]any{
+			"city":    "Toronto",
+			"country": "Canada",
+		},
+	}
+
+	err = conn.Exec(ctx, `INSERT INTO json_docs (id, data) VALUES (?, ?), (?, ?);`, 1, doc, 2, doc)
+	assert.NoError(t, err)
+
+	n, err := sqlite.PatchJSON(ctx, conn, "json_docs", "data", "id = ?", map[string]any{
+		"address": map[string]any{
+			"city": "Vancouver",
+		},
+	}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	type address struct {
+		City    string `json:"city"`
+		Country string `json:"country"`
+	}
+
+	type document struct {
+		Name    string  `json:"name"`
+		Address address `json:"address"`
+	}
+
+	load := func(id int) document {
+		stmt, err := conn.Prepare(ctx, `SELECT data FROM json_docs WHERE id = ?;`, id)
+		assert.NoError(t, err)
+		defer stmt.Reset()
+
+		hasRow, err := stmt.Step()
+		assert.NoError(t, err)
+		assert.True(t, hasRow)
+
+		doc, err := sqlite.LoadJson[document](stmt, "data")
+		assert.NoError(t, err)
+		return doc
+	}
+
+	assert.Equal(t, document{Name: "john", Address: address{City: "Vancouver", Country: "Canada"}}, load(1))
+	assert.Equal(t, document{Name: "john", Address: address{City: "Toronto", Country: "Canada"}}, load(2))
+
+	_, err = sqlite.PatchJSON(ctx, conn, "json_docs; DROP TABLE json_docs --", "data", "id = ?", map[string]any{"name": "x"}, 1)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	_, err = sqlite.PatchJSON(ctx, conn, "json_docs", "data = NULL, data", "id = ?", map[string]any{"name": "x"}, 1)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
+
+func TestJsonHelpers(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithJSONFunctions())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	assert.Equal(t, "json_extract(data, '$.address.city')", sqlite.JsonExtract("data", "address.city"))
+	assert.Equal(t, "json_extract(data, '$[0]')", sqlite.JsonExtract("data", "[0]"))
+	assert.Equal(t, "json_each(data)", sqlite.JsonEach("data"))
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS json_fields (id INTEGER PRIMARY KEY, data TEXT);
+		DELETE FROM json_fields;
+	`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO json_fields (id, data) VALUES (?, ?);`, 1, map[string]any{
+		"name":    "john",
+		"tags":    []string{"a", "b"},
+		"address": map[string]any{"city": "Toronto"},
+	})
+	assert.NoError(t, err)
+
+	err = conn.UpdateJsonField(ctx, "json_fields", "data", "address.city", "Vancouver", "id = ?", 1)
+	assert.NoError(t, err)
+
+	err = conn.UpdateJsonField(ctx, "json_fields", "data", "$.tags", []string{"c"}, "id = ?", 1)
+	assert.NoError(t, err)
+
+	err = conn.UpdateJsonField(ctx, "json_fields", "data", "age", 30, "id = ?", 1)
+	assert.NoError(t, err)
+
+	err = conn.UpdateJsonField(ctx, "json_fields", "data", "active", true, "id = ?", 1)
+	assert.NoError(t, err)
+
+	err = conn.UpdateJsonField(ctx, "json_fields", "data", "admin", false, "id = ?", 1)
+	assert.NoError(t, err)
+
+	city, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("city"), nil
+	}, `SELECT `+sqlite.JsonExtract("data", "address.city")+` AS city FROM json_fields WHERE id = ?;`, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Vancouver", city)
+
+	var tags []string
+	for stmt, err := range conn.Query(ctx, `SELECT tag.value AS value FROM json_fields, `+sqlite.JsonEach("json_fields.data -> '$.tags'")+` AS tag;`) {
+		assert.NoError(t, err)
+		tags = append(tags, stmt.GetText("value"))
+	}
+	assert.Equal(t, []string{"c"}, tags)
+
+	age, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (int64, error) {
+		return stmt.GetInt64("age"), nil
+	}, `SELECT `+sqlite.JsonExtract("data", "age")+` AS age FROM json_fields WHERE id = ?;`, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), age)
+
+	flags, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("flags"), nil
+	}, `SELECT json_object('active', data -> '$.active', 'admin', data -> '$.admin') AS flags FROM json_fields WHERE id = ?;`, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"active":true,"admin":false}`, flags)
+
+	nulls := []struct {
+		path  string
+		value any
+	}{
+		{"nickname", sqlite.Null[string]{Value: "johnny", Valid: true}},
+		{"manager", sqlite.Null[string]{}},
+		{"team", sql.NullString{String: "core", Valid: true}},
+		{"lead", sql.NullString{}},
+		{"mentor", (*sql.NullString)(nil)},
+	}
+	for _, n := range nulls {
+		err = conn.UpdateJsonField(ctx, "json_fields", "data", n.path, n.value, "id = ?", 1)
+		assert.NoError(t, err, n.path)
+	}
+
+	fields, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("fields"), nil
+	}, `SELECT json_object('nickname', data -> '$.nickname', 'manager', data -> '$.manager', 'team', data -> '$.team', 'lead', data -> '$.lead', 'mentor', data -> '$.mentor') AS fields FROM json_fields WHERE id = ?;`, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"nickname":"johnny","manager":null,"team":"core","lead":null,"mentor":null}`, fields)
+
+	err = conn.UpdateJsonField(ctx, "json_fields; DROP TABLE json_fields --", "data", "age", 1, "id = ?", 1)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	err = conn.UpdateJsonField(ctx, "json_fields", "data = 1, data", "age", 1, "id = ?", 1)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
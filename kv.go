@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"time"
+)
+
+// KV is a simple key-value store kept in one table of the database. Values
+// are stored as JSON, so anything encoding/json handles can be stored.
+//
+//	kv, err := sqlite.NewKV(db, "settings")
+//	err = kv.Set(ctx, "theme", Theme{Dark: true})
+//	theme, err := sqlite.GetKV[Theme](ctx, kv, "theme")
+type KV struct {
+	db    *Database
+	table string
+}
+
+// KVEntry is one key of a KV, see KV.Range
+type KVEntry struct {
+	Key       string
+	Value     json.RawMessage
+	UpdatedAt time.Time
+}
+
+// NewKV returns a KV that keeps its entries in table, creating the table if
+// it doesn't exist yet
+func NewKV(db *Database, table string) (*KV, error) {
+	if !isIdentifier(table) {
+		return nil, fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	err := db.Exec(context.Background(), func(ctx context.Context, conn *Conn) error {
+		return conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (key TEXT PRIMARY KEY, value BLOB, updated_at INTEGER);`)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &KV{db: db, table: table}, nil
+}
+
+// Set stores value, encoded as JSON, under key, replacing any previous value
+func (kv *KV) Set(ctx context.Context, key string, value any) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("kv %s: %w", key, err)
+	}
+
+	return kv.db.Exec(ctx, func(ctx context.Context, conn *Conn) error {
+		return conn.Exec(ctx, `
+			INSERT INTO `+kv.table+` (key, value, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at;`,
+			key, string(b), time.Now().Unix(),
+		)
+	})
+}
+
+// Get decodes the value stored under key into dst. It returns ErrNotFound if
+// there is none.
+func (kv *KV) Get(ctx context.Context, key string, dst any) error {
+	return kv.db.Exec(ctx, func(ctx context.Context, conn *Conn) error {
+		value, err := QueryRow(ctx, conn, func(stmt *Stmt) (string, error) {
+			return stmt.GetText("value"), nil
+		}, `SELECT value FROM `+kv.table+` WHERE key = ?;`, key)
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal([]byte(value), dst)
+		if err != nil {
+			return fmt.Errorf("kv %s: %w", key, err)
+		}
+
+		return nil
+	})
+}
+
+// GetKV returns the value stored under key as a T, see KV.Get
+func GetKV[T any](ctx context.Context, kv *KV, key string) (T, error) {
+	var value T
+	err := kv.Get(ctx, key, &value)
+	return value, err
+}
+
+// Delete removes key, it's not an error if it doesn't exist
+func (kv *KV) Delete(ctx context.Context, key string) error {
+	return kv.db.Exec(ctx, func(ctx context.Context, conn *Conn) error {
+		return conn.Exec(ctx, `DELETE FROM `+kv.table+` WHERE key = ?;`, key)
+	})
+}
+
+// Range returns the entries whose key starts with prefix, ordered by key. An
+// empty prefix returns every entry. A connection is held until the loop ends.
+func (kv *KV) Range(ctx context.Context, prefix string) iter.Seq2[KVEntry, error] {
+	return func(yield func(KVEntry, error) bool) {
+		conn, err := kv.db.Conn(ctx)
+		if err != nil {
+			yield(KVEntry{}, err)
+			return
+		}
+		defer conn.Done()
+
+		// 0xff never shows up in UTF-8, so every key starting with prefix
+		// sorts before prefix + "\xff"
+		for stmt, err := range conn.Query(ctx, `
+			SELECT key, value, updated_at FROM `+kv.table+`
+			WHERE key >= ? AND key < ?
+			ORDER BY key;`,
+			prefix, prefix+"\xff",
+		) {
+			if err != nil {
+				yield(KVEntry{}, err)
+				return
+			}
+
+			entry := KVEntry{
+				Key:       stmt.GetText("key"),
+				Value:     json.RawMessage(stmt.GetText("value")),
+				UpdatedAt: LoadTime(stmt, "updated_at"),
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
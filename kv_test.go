@@ -0,0 +1,95 @@
+package sqlite_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	kv, err := sqlite.NewKV(db, "kv_settings")
+	assert.NoError(t, err)
+
+	type theme struct {
+		Dark  bool   `json:"dark"`
+		Color string `json:"color"`
+	}
+
+	_, err = sqlite.GetKV[theme](ctx, kv, "user:1:theme")
+	assert.ErrorIs(t, err, sqlite.ErrNotFound)
+
+	err = kv.Set(ctx, "user:1:theme", theme{Dark: true, Color: "blue"})
+	assert.NoError(t, err)
+
+	got, err := sqlite.GetKV[theme](ctx, kv, "user:1:theme")
+	assert.NoError(t, err)
+	assert.Equal(t, theme{Dark: true, Color: "blue"}, got)
+
+	// overwrite
+	err = kv.Set(ctx, "user:1:theme", theme{Color: "red"})
+	assert.NoError(t, err)
+
+	got, err = sqlite.GetKV[theme](ctx, kv, "user:1:theme")
+	assert.NoError(t, err)
+	assert.Equal(t, theme{Color: "red"}, got)
+
+	// scalars and slices are JSON too
+	assert.NoError(t, kv.Set(ctx, "user:1:visits", 42))
+	assert.NoError(t, kv.Set(ctx, "user:2:tags", []string{"a", "b"}))
+	assert.NoError(t, kv.Set(ctx, "user:10:name", "jane"))
+
+	visits, err := sqlite.GetKV[int](ctx, kv, "user:1:visits")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, visits)
+
+	var tags []string
+	assert.NoError(t, kv.Get(ctx, "user:2:tags", &tags))
+	assert.Equal(t, []string{"a", "b"}, tags)
+
+	_, err = sqlite.GetKV[int](ctx, kv, "user:2:tags")
+	assert.Error(t, err)
+
+	var keys []string
+	var values []json.RawMessage
+	for entry, err := range kv.Range(ctx, "user:1") {
+		assert.NoError(t, err)
+		assert.False(t, entry.UpdatedAt.IsZero())
+		keys = append(keys, entry.Key)
+		values = append(values, entry.Value)
+	}
+	assert.Equal(t, []string{"user:10:name", "user:1:theme", "user:1:visits"}, keys)
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`"jane"`), json.RawMessage(`{"dark":false,"color":"red"}`), json.RawMessage(`42`)}, values)
+
+	// delete
+	assert.NoError(t, kv.Delete(ctx, "user:1:theme"))
+	assert.NoError(t, kv.Delete(ctx, "missing"))
+
+	_, err = sqlite.GetKV[theme](ctx, kv, "user:1:theme")
+	assert.ErrorIs(t, err, sqlite.ErrNotFound)
+
+	keys = nil
+	for entry, err := range kv.Range(ctx, "") {
+		assert.NoError(t, err)
+		keys = append(keys, entry.Key)
+	}
+	assert.Equal(t, []string{"user:10:name", "user:1:visits", "user:2:tags"}, keys)
+
+	// the table is only created once
+	again, err := sqlite.NewKV(db, "kv_settings")
+	assert.NoError(t, err)
+	assert.NoError(t, again.Get(ctx, "user:1:visits", &visits))
+
+	_, err = sqlite.NewKV(db, "kv; DROP TABLE users")
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
@@ -0,0 +1,186 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+)
+
+// ErrorKind is the category a failed query is counted under, see
+// MetricsCollector
+type ErrorKind string
+
+const (
+	ErrorKindPrepare    ErrorKind = "prepare"
+	ErrorKindBusy       ErrorKind = "busy"
+	ErrorKindConstraint ErrorKind = "constraint"
+	ErrorKindNotFound   ErrorKind = "not_found"
+	ErrorKindCanceled   ErrorKind = "canceled"
+	ErrorKindExec       ErrorKind = "exec"
+)
+
+// errorKind classifies err, the most specific category wins
+func errorKind(err error) ErrorKind {
+	switch {
+	case IsNotFound(err):
+		return ErrorKindNotFound
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindCanceled
+	case IsBusy(err):
+		return ErrorKindBusy
+	case IsConstraintViolation(err):
+		return ErrorKindConstraint
+	case errors.Is(err, ErrPrepareSQL), errors.Is(err, ErrUnknownType):
+		return ErrorKindPrepare
+	default:
+		return ErrorKindExec
+	}
+}
+
+// MetricsCollector receives the package's metrics, see WithMetrics. Its
+// methods are called from every connection and must be safe for concurrent
+// use.
+type MetricsCollector interface {
+	// StatementPrepared is called after Conn.Prepare compiled a statement,
+	// or got it from the connection's cache
+	StatementPrepared(dur time.Duration)
+	// QueryExecuted is called after Conn.Exec, Conn.ExecMany, Conn.Query or
+	// QueryRow succeeded, with the time it took including stepping
+	QueryExecuted(dur time.Duration)
+	// QueryFailed is called when one of them failed
+	QueryFailed(kind ErrorKind)
+	// PoolSnapshot is called whenever a connection is taken from or put
+	// back to the pool
+	PoolSnapshot(inUse, size int)
+	// ConnAcquired is called after Database.Conn got a connection from the
+	// pool, with how long it waited for a free one. slow is set when the wait
+	// went past the threshold of WithSlowAcquireThreshold.
+	ConnAcquired(wait time.Duration, slow bool)
+}
+
+// NoopMetrics is the MetricsCollector used when none is set
+type NoopMetrics struct{}
+
+func (NoopMetrics) StatementPrepared(dur time.Duration)        {}
+func (NoopMetrics) QueryExecuted(dur time.Duration)            {}
+func (NoopMetrics) QueryFailed(kind ErrorKind)                 {}
+func (NoopMetrics) PoolSnapshot(inUse, size int)               {}
+func (NoopMetrics) ConnAcquired(wait time.Duration, slow bool) {}
+
+// ExpvarMetrics is a MetricsCollector publishing its counters with expvar,
+// so they show up on /debug/vars:
+//
+//	{"sqlite": {"queries": 120, "query_ns": 5400000, "prepares": 130,
+//	 "prepare_ns": 900000, "errors": {"busy": 2}, "pool_in_use": 1, "pool_size": 10,
+//	 "acquires": 130, "acquire_wait_ns": 26000, "slow_acquires": 0}}
+//
+// Average durations are query_ns / queries, prepare_ns / prepares and
+// acquire_wait_ns / acquires.
+type ExpvarMetrics struct {
+	vars *expvar.Map
+
+	queries   *expvar.Int
+	queryNs   *expvar.Int
+	prepares  *expvar.Int
+	prepareNs *expvar.Int
+	errors    *expvar.Map
+	poolInUse *expvar.Int
+	poolSize  *expvar.Int
+
+	acquires      *expvar.Int
+	acquireWaitNs *expvar.Int
+	slowAcquires  *expvar.Int
+}
+
+// NewExpvarMetrics publishes the metrics under name. Like expvar.Publish it
+// panics if name is already used, so call it once per name.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		vars:      expvar.NewMap(name),
+		queries:   new(expvar.Int),
+		queryNs:   new(expvar.Int),
+		prepares:  new(expvar.Int),
+		prepareNs: new(expvar.Int),
+		errors:    new(expvar.Map).Init(),
+		poolInUse: new(expvar.Int),
+		poolSize:  new(expvar.Int),
+
+		acquires:      new(expvar.Int),
+		acquireWaitNs: new(expvar.Int),
+		slowAcquires:  new(expvar.Int),
+	}
+
+	m.vars.Set("queries", m.queries)
+	m.vars.Set("query_ns", m.queryNs)
+	m.vars.Set("prepares", m.prepares)
+	m.vars.Set("prepare_ns", m.prepareNs)
+	m.vars.Set("errors", m.errors)
+	m.vars.Set("pool_in_use", m.poolInUse)
+	m.vars.Set("pool_size", m.poolSize)
+	m.vars.Set("acquires", m.acquires)
+	m.vars.Set("acquire_wait_ns", m.acquireWaitNs)
+	m.vars.Set("slow_acquires", m.slowAcquires)
+
+	return m
+}
+
+// Vars returns the published map
+func (m *ExpvarMetrics) Vars() *expvar.Map {
+	return m.vars
+}
+
+func (m *ExpvarMetrics) StatementPrepared(dur time.Duration) {
+	m.prepares.Add(1)
+	m.prepareNs.Add(int64(dur))
+}
+
+func (m *ExpvarMetrics) QueryExecuted(dur time.Duration) {
+	m.queries.Add(1)
+	m.queryNs.Add(int64(dur))
+}
+
+func (m *ExpvarMetrics) QueryFailed(kind ErrorKind) {
+	m.errors.Add(string(kind), 1)
+}
+
+func (m *ExpvarMetrics) PoolSnapshot(inUse, size int) {
+	m.poolInUse.Set(int64(inUse))
+	m.poolSize.Set(int64(size))
+}
+
+func (m *ExpvarMetrics) ConnAcquired(wait time.Duration, slow bool) {
+	m.acquires.Add(1)
+	m.acquireWaitNs.Add(int64(wait))
+	if slow {
+		m.slowAcquires.Add(1)
+	}
+}
+
+// WithMetrics reports query, error and pool metrics to c, use
+// NewExpvarMetrics to publish them with expvar
+func WithMetrics(c MetricsCollector) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if c == nil {
+			c = NoopMetrics{}
+		}
+		db.metrics = c
+		return nil
+	}
+}
+
+// WithSlowAcquireThreshold sets how long Database.Conn can wait for a free
+// connection before the wait counts as slow: it's reported as such to the
+// MetricsCollector and logged as a warning. Slow acquires mean the pool is
+// too small for the load, or connections are held for too long. Zero, the
+// default, never counts a wait as slow.
+func WithSlowAcquireThreshold(d time.Duration) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if d < 0 {
+			return fmt.Errorf("slow acquire threshold must not be negative, got %s", d)
+		}
+		db.slowAcquire = d
+		return nil
+	}
+}
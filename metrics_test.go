@@ -0,0 +1,132 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	metrics := sqlite.NewExpvarMetrics("sqlite_test_metrics")
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(2), sqlite.WithMetrics(metrics))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS metrics_users (email TEXT UNIQUE);`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO metrics_users (email) VALUES (?);`, "john@example.com")
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO metrics_users (email) VALUES (?);`, "john@example.com")
+	assert.Error(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO missing_table (email) VALUES (?);`, "john@example.com")
+	assert.Error(t, err)
+
+	_, err = sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("email"), nil
+	}, `SELECT email FROM metrics_users WHERE email = ?;`, "jane@example.com")
+	assert.ErrorIs(t, err, sqlite.ErrNotFound)
+
+	var snapshot struct {
+		Queries   int64            `json:"queries"`
+		QueryNs   int64            `json:"query_ns"`
+		Prepares  int64            `json:"prepares"`
+		Errors    map[string]int64 `json:"errors"`
+		PoolInUse int64            `json:"pool_in_use"`
+		PoolSize  int64            `json:"pool_size"`
+	}
+
+	read := func() {
+		assert.NoError(t, json.Unmarshal([]byte(metrics.Vars().String()), &snapshot))
+	}
+
+	read()
+	assert.Equal(t, int64(2), snapshot.Queries)
+	assert.Greater(t, snapshot.QueryNs, int64(0))
+	assert.Equal(t, int64(5), snapshot.Prepares)
+	assert.Equal(t, map[string]int64{"constraint": 1, "prepare": 1, "not_found": 1}, snapshot.Errors)
+	assert.Equal(t, int64(1), snapshot.PoolInUse)
+	assert.Equal(t, int64(2), snapshot.PoolSize)
+
+	conn.Done()
+
+	read()
+	assert.Equal(t, int64(0), snapshot.PoolInUse)
+}
+
+func TestConnAcquireWait(t *testing.T) {
+	ctx := context.Background()
+
+	metrics := sqlite.NewExpvarMetrics("sqlite_test_acquire_metrics")
+
+	var logs bytes.Buffer
+
+	db, err := sqlite.New(ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(1),
+		sqlite.WithMetrics(metrics),
+		sqlite.WithSlowAcquireThreshold(10*time.Millisecond),
+		sqlite.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	var snapshot struct {
+		Acquires      int64 `json:"acquires"`
+		AcquireWaitNs int64 `json:"acquire_wait_ns"`
+		SlowAcquires  int64 `json:"slow_acquires"`
+	}
+
+	read := func() {
+		assert.NoError(t, json.Unmarshal([]byte(metrics.Vars().String()), &snapshot))
+	}
+
+	held, err := db.Conn(ctx)
+	assert.NoError(t, err)
+
+	read()
+	assert.Equal(t, int64(1), snapshot.Acquires)
+	assert.Equal(t, int64(0), snapshot.SlowAcquires)
+
+	acquired := make(chan error)
+	go func() {
+		conn, err := db.ConnLabeled(ctx, "waiter")
+		if err == nil {
+			conn.Done()
+		}
+		acquired <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	held.Done()
+	assert.NoError(t, <-acquired)
+
+	read()
+	assert.Equal(t, int64(2), snapshot.Acquires)
+	assert.Equal(t, int64(1), snapshot.SlowAcquires)
+	// the waiter starts waiting a little after the sleep does
+	assert.GreaterOrEqual(t, time.Duration(snapshot.AcquireWaitNs), 25*time.Millisecond)
+	assert.Contains(t, logs.String(), "slow connection acquire")
+	assert.Contains(t, logs.String(), "label=waiter")
+
+	_, err = sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithSlowAcquireThreshold(-time.Second))
+	assert.Error(t, err)
+}
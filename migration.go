@@ -1,13 +1,25 @@
 package sqlite
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io/fs"
 	"path/filepath"
 	"slices"
 	"sort"
+	"strings"
+	"text/template"
+)
 
-	"ella.to/logger"
+var (
+	ErrEmptyMigration = errors.New("migration file has no executable statements")
+	// ErrMigrationIO is returned, wrapped in a MigrationError, when a
+	// migration fails because of the storage, see IsIOError. The failed file
+	// is not recorded, but SQLite may not have been able to roll everything
+	// back, so the database may be partially migrated.
+	ErrMigrationIO = errors.New("migration failed on an i/o error, the database may be partially migrated")
 )
 
 type ReadDirFileFS interface {
@@ -15,14 +27,108 @@ type ReadDirFileFS interface {
 	fs.ReadFileFS
 }
 
+// MigrationError is returned when a specific migration file fails,
+// so the caller can tell which file caused the problem.
+type MigrationError struct {
+	File string
+	Err  error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %s: %s", e.File, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationOrderFunc returns the ordering key of a migration file,
+// for example a version parsed from a `-- version: 20240101` line
+type MigrationOrderFunc func(filename string, content []byte) (int64, error)
+
+// MigrationHookFunc runs around a migration file, see WithMigrationHooks
+type MigrationHookFunc func(ctx context.Context, conn *Conn, filename string) error
+
+type migrationConfig struct {
+	strict  bool
+	orderBy MigrationOrderFunc
+	before  MigrationHookFunc
+	after   MigrationHookFunc
+
+	templateData map[string]any
+}
+
+// migrationTemplateExt is the extension of the migration files rendered with
+// text/template, see WithMigrationTemplateData
+const migrationTemplateExt = ".sql.tmpl"
+
+type MigrationOption func(*migrationConfig)
+
+// WithMigrationOrderBy applies the migration files in the order of the key
+// returned by fn instead of the filename. Files with the same key keep
+// their filename order.
+func WithMigrationOrderBy(fn MigrationOrderFunc) MigrationOption {
+	return func(cfg *migrationConfig) {
+		cfg.orderBy = fn
+	}
+}
+
+// WithMigrationHooks runs before right before each pending migration file and
+// after right after it, either can be nil. Both run on the migration's
+// connection within the same savepoint as the file, so an error from either
+// aborts that migration: nothing it did is kept, the file is not recorded
+// and Migration returns the error wrapped in a MigrationError.
+func WithMigrationHooks(before, after MigrationHookFunc) MigrationOption {
+	return func(cfg *migrationConfig) {
+		cfg.before = before
+		cfg.after = after
+	}
+}
+
+// WithMigrationTemplateData also applies the .sql.tmpl files of the migration
+// directory, rendering each with text/template and data before running it,
+// for values that change between environments:
+//
+//	CREATE TABLE {{.prefix}}users (id INTEGER PRIMARY KEY);
+//
+// They are ordered together with the .sql files and recorded under their own
+// filename. Without this option .sql.tmpl files are ignored.
+func WithMigrationTemplateData(data map[string]any) MigrationOption {
+	return func(cfg *migrationConfig) {
+		cfg.templateData = data
+	}
+}
+
+// WithMigrationStrict makes Migration fail with ErrEmptyMigration when a file
+// contains no executable statements (only whitespace or comments). By default
+// such files only log a warning and are still recorded as applied.
+func WithMigrationStrict() MigrationOption {
+	return func(cfg *migrationConfig) {
+		cfg.strict = true
+	}
+}
+
 // migration calls read each sql files in the migration directory and applies it to the database.
 // It will create a table called migrations_sqlite to keep track of the files that have been applied.
 //
 // Use this function to apply migrations to the database at the start of your application.
 // Make sure each file name is unique and the use either a timestamp or counter to make sure
 // the files are applied in the correct order.
-func Migration(ctx context.Context, db *Database, fs ReadDirFileFS, dir string) error {
-	ctx = logger.Debug(ctx, "applying migrations", "dir", dir)
+//
+// A subdirectory of dir whose name starts with a digit, e.g.
+// migrations/0003_feature/, is a group: its files are applied in name order
+// within a single savepoint, so either all of them apply or none, and the
+// group is recorded under its directory path. Like an applied file, an
+// applied group is never run again: files added to it later are ignored, put
+// them in a new group instead. Other subdirectories, e.g. down/ or
+// fixtures/, are ignored.
+func Migration(ctx context.Context, db *Database, fs ReadDirFileFS, dir string, opts ...MigrationOption) error {
+	db.log().DebugContext(ctx, "applying migrations", "dir", dir)
+
+	var cfg migrationConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	conn, err := db.Conn(ctx)
 	if err != nil {
@@ -30,11 +136,18 @@ func Migration(ctx context.Context, db *Database, fs ReadDirFileFS, dir string)
 	}
 	defer conn.Done()
 
-	sqlFiles, err := sortMigrationFiles(fs, dir)
+	sqlFiles, groups, err := sortMigrationFiles(fs, dir, cfg.templateData != nil)
 	if err != nil {
 		return err
 	}
 
+	if cfg.orderBy != nil {
+		sqlFiles, err = orderMigrationFiles(fs, sqlFiles, groups, cfg.orderBy)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = createMigrationTable(ctx, conn)
 	if err != nil {
 		return err
@@ -48,9 +161,39 @@ func Migration(ctx context.Context, db *Database, fs ReadDirFileFS, dir string)
 	missingMigrations := detectMissingMigrations(alreadyMigratedFiles, sqlFiles)
 
 	for _, sqlFile := range missingMigrations {
-		logger.Debug(ctx, "running migration sql", "file", sqlFile)
+		db.log().DebugContext(ctx, "running migration sql", "file", sqlFile)
+
+		files := []string{sqlFile}
+		if group, ok := groups[sqlFile]; ok {
+			files = group
+		}
 
-		err = setMigrateFile(ctx, conn, sqlFile, fs)
+		migrationFiles := make([]migrationFile, 0, len(files))
+		for _, file := range files {
+			content, err := readMigrationFile(fs, file, &cfg)
+			if err != nil {
+				return err
+			}
+
+			if isEmptyScript(content) {
+				if cfg.strict {
+					return &MigrationError{File: file, Err: ErrEmptyMigration}
+				}
+				db.log().WarnContext(ctx, "migration file has no executable statements", "file", file)
+			}
+
+			migrationFiles = append(migrationFiles, migrationFile{name: file, content: content})
+		}
+
+		err = setMigrateFile(ctx, conn, sqlFile, migrationFiles, &cfg)
+		if IsIOError(err) {
+			var migrationErr *MigrationError
+			if errors.As(err, &migrationErr) {
+				migrationErr.Err = fmt.Errorf("%w: %w", ErrMigrationIO, migrationErr.Err)
+				return migrationErr
+			}
+			return &MigrationError{File: sqlFile, Err: fmt.Errorf("%w: %w", ErrMigrationIO, err)}
+		}
 		if err != nil {
 			return err
 		}
@@ -59,6 +202,29 @@ func Migration(ctx context.Context, db *Database, fs ReadDirFileFS, dir string)
 	return nil
 }
 
+// migrationFile is one file of a pending migration, rendered if it's a
+// template
+type migrationFile struct {
+	name    string
+	content string
+}
+
+func readMigrationFile(fs ReadDirFileFS, filename string, cfg *migrationConfig) (string, error) {
+	content, err := fs.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasSuffix(filename, migrationTemplateExt) {
+		content, err = renderMigrationTemplate(filename, content, cfg.templateData)
+		if err != nil {
+			return "", &MigrationError{File: filename, Err: err}
+		}
+	}
+
+	return string(content), nil
+}
+
 func detectMissingMigrations(alreadyMigratedFiles, sqlFiles []string) []string {
 	var missingMigrations []string
 
@@ -94,8 +260,18 @@ func loadAlreadyMigratedFiles(ctx context.Context, conn *Conn) ([]string, error)
 	return filenames, nil
 }
 
-func setMigrateFile(ctx context.Context, conn *Conn, filename string, fs ReadDirFileFS) (err error) {
-	defer conn.Save(&err)
+// setMigrateFile records filename and runs the content of files within one
+// savepoint, so a failing migration is never recorded. files holds filename
+// itself, or every file of the group when filename is a group directory.
+func setMigrateFile(ctx context.Context, conn *Conn, filename string, files []migrationFile, cfg *migrationConfig) (err error) {
+	defer conn.Save()(&err)
+
+	if cfg.before != nil {
+		err = cfg.before(ctx, conn, filename)
+		if err != nil {
+			return &MigrationError{File: filename, Err: err}
+		}
+	}
 
 	err = func() error {
 		stmt, err := conn.Prepare(ctx, `INSERT INTO migrations_sqlite (filename) VALUES (?);`, filename)
@@ -114,21 +290,34 @@ func setMigrateFile(ctx context.Context, conn *Conn, filename string, fs ReadDir
 		return err
 	}
 
-	content, err := fs.ReadFile(filename)
-	if err != nil {
-		return err
+	for _, file := range files {
+		// NOTE: ExecScript rejects a script without any statement, so empty
+		// files are only recorded
+		if isEmptyScript(file.content) {
+			continue
+		}
+
+		err = conn.ExecScript(file.content)
+		if err != nil && file.name != filename {
+			return &MigrationError{File: file.name, Err: err}
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	err = conn.ExecScript(string(content))
-	if err != nil {
-		return err
+	if cfg.after != nil {
+		err = cfg.after(ctx, conn, filename)
+		if err != nil {
+			return &MigrationError{File: filename, Err: err}
+		}
 	}
 
 	return nil
 }
 
 func createMigrationTable(ctx context.Context, conn *Conn) (err error) {
-	defer conn.Save(&err)
+	defer conn.Save()(&err)
 
 	stmt, err := conn.Prepare(ctx, `CREATE TABLE IF NOT EXISTS migrations_sqlite (filename TEXT PRIMARY KEY);`)
 	if err != nil {
@@ -143,20 +332,54 @@ func createMigrationTable(ctx context.Context, conn *Conn) (err error) {
 	return nil
 }
 
-func sortMigrationFiles(fs ReadDirFileFS, dir string) ([]string, error) {
-	var sqlFiles []string
+// sortMigrationFiles lists the migration files of dir sorted by name. Each
+// group subdirectory of dir, see isMigrationGroup, is listed under its own
+// path and its files, applied together in one savepoint, are returned in
+// groups by path.
+func sortMigrationFiles(fs ReadDirFileFS, dir string, templates bool) ([]string, map[string][]string, error) {
+	sqlFiles, subDirs, err := readMigrationDir(fs, dir, templates)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := make(map[string][]string, len(subDirs))
+	for _, subDir := range subDirs {
+		groupFiles, _, err := readMigrationDir(fs, subDir, templates)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(groupFiles) == 0 {
+			continue
+		}
+
+		groups[subDir] = groupFiles
+		sqlFiles = append(sqlFiles, subDir)
+	}
+
+	sort.Strings(sqlFiles)
 
+	return sqlFiles, groups, nil
+}
+
+// readMigrationDir returns the sorted migration files and the group
+// subdirectories found directly in dir
+func readMigrationDir(fs ReadDirFileFS, dir string, templates bool) (sqlFiles []string, subDirs []string, err error) {
 	files, err := fs.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for _, file := range files {
 		if file.IsDir() {
+			if isMigrationGroup(file.Name()) {
+				subDirs = append(subDirs, filepath.Join(dir, file.Name()))
+			}
 			continue
 		}
 
-		if filepath.Ext(file.Name()) != ".sql" {
+		isTemplate := templates && strings.HasSuffix(file.Name(), migrationTemplateExt)
+		if filepath.Ext(file.Name()) != ".sql" && !isTemplate {
 			continue
 		}
 
@@ -165,5 +388,86 @@ func sortMigrationFiles(fs ReadDirFileFS, dir string) ([]string, error) {
 
 	sort.Strings(sqlFiles)
 
+	return sqlFiles, subDirs, nil
+}
+
+// isMigrationGroup reports whether the subdirectory name is a migration
+// group, which takes a numeric prefix like the files, e.g. 0003_feature
+func isMigrationGroup(name string) bool {
+	return name != "" && name[0] >= '0' && name[0] <= '9'
+}
+
+// orderMigrationFiles sorts sqlFiles by the key orderBy returns for each of
+// them, a group is keyed by its first file
+func orderMigrationFiles(fs ReadDirFileFS, sqlFiles []string, groups map[string][]string, orderBy MigrationOrderFunc) ([]string, error) {
+	keys := make(map[string]int64, len(sqlFiles))
+
+	for _, sqlFile := range sqlFiles {
+		file := sqlFile
+		if group, ok := groups[sqlFile]; ok {
+			file = group[0]
+		}
+
+		content, err := fs.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := orderBy(file, content)
+		if err != nil {
+			return nil, &MigrationError{File: file, Err: err}
+		}
+
+		keys[sqlFile] = key
+	}
+
+	sort.SliceStable(sqlFiles, func(i, j int) bool {
+		return keys[sqlFiles[i]] < keys[sqlFiles[j]]
+	})
+
 	return sqlFiles, nil
 }
+
+// renderMigrationTemplate executes the text/template in content with data,
+// a missing key is an error rather than an empty string
+func renderMigrationTemplate(filename string, content []byte, data map[string]any) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(filename)).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isEmptyScript reports whether sql contains nothing but whitespace,
+// semicolons and comments
+func isEmptyScript(sql string) bool {
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ';':
+			continue
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				return true
+			}
+			i += end
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				return true
+			}
+			i += end + 3
+		default:
+			return false
+		}
+	}
+
+	return true
+}
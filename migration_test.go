@@ -0,0 +1,373 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func createMigrationDB(t *testing.T) *sqlite.Database {
+	t.Helper()
+
+	db, err := sqlite.New(context.Background(), sqlite.WithMemory(), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return db
+}
+
+func countMigrations(t *testing.T, db *sqlite.Database) int64 {
+	t.Helper()
+
+	conn, err := db.Conn(context.Background())
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	stmt, err := conn.Prepare(context.Background(), `SELECT COUNT(*) AS count FROM migrations_sqlite;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	_, err = stmt.Step()
+	assert.NoError(t, err)
+
+	return stmt.GetInt64("count")
+}
+
+func TestMigrationEmptyFile(t *testing.T) {
+	fs := fstest.MapFS{
+		"migrations/001_init.sql":  {Data: []byte(`CREATE TABLE empty_users (id INTEGER PRIMARY KEY);`)},
+		"migrations/002_empty.sql": {Data: []byte("-- nothing to see here\n/* truncated */\n;\n")},
+	}
+
+	t.Run("default warns", func(t *testing.T) {
+		var logs bytes.Buffer
+
+		db, err := sqlite.New(context.Background(),
+			sqlite.WithMemory(),
+			sqlite.WithPoolSize(2),
+			sqlite.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		)
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			db.Close()
+		})
+
+		err = sqlite.Migration(context.Background(), db, fs, "migrations")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), countMigrations(t, db))
+
+		var warnings []string
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, `msg="migration file has no executable statements"`) {
+				warnings = append(warnings, line)
+			}
+		}
+		assert.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "level=WARN")
+		assert.Contains(t, warnings[0], "file=migrations/002_empty.sql")
+	})
+
+	t.Run("strict errors", func(t *testing.T) {
+		db := createMigrationDB(t)
+
+		err := sqlite.Migration(context.Background(), db, fs, "migrations", sqlite.WithMigrationStrict())
+		assert.ErrorIs(t, err, sqlite.ErrEmptyMigration)
+
+		var migrationErr *sqlite.MigrationError
+		assert.True(t, errors.As(err, &migrationErr))
+		assert.Equal(t, "migrations/002_empty.sql", migrationErr.File)
+		assert.Equal(t, int64(1), countMigrations(t, db))
+	})
+}
+
+func TestMigrationOrderBy(t *testing.T) {
+	fs := fstest.MapFS{
+		"migrations/users.sql":       {Data: []byte("-- version: 20240101\nCREATE TABLE ordered_users (id INTEGER PRIMARY KEY, name TEXT);")},
+		"migrations/add_email.sql":   {Data: []byte("-- version: 20240301\nALTER TABLE ordered_users ADD COLUMN email TEXT;")},
+		"migrations/seed_admin.sql":  {Data: []byte("-- version: 20240401\nINSERT INTO ordered_users (name, email) VALUES ('admin', 'admin@example.com');")},
+		"migrations/1_add_index.sql": {Data: []byte("-- version: 20240201\nCREATE INDEX ordered_users_name ON ordered_users (name);")},
+	}
+
+	orderBy := func(filename string, content []byte) (int64, error) {
+		line, _, _ := strings.Cut(string(content), "\n")
+		version, ok := strings.CutPrefix(line, "-- version: ")
+		if !ok {
+			return 0, fmt.Errorf("missing version directive")
+		}
+		return strconv.ParseInt(version, 10, 64)
+	}
+
+	db := createMigrationDB(t)
+
+	err := sqlite.Migration(context.Background(), db, fs, "migrations", sqlite.WithMigrationOrderBy(orderBy))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), countMigrations(t, db))
+
+	conn, err := db.Conn(context.Background())
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	stmt, err := conn.Prepare(context.Background(), `SELECT email FROM ordered_users WHERE name = 'admin';`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, "admin@example.com", stmt.GetText("email"))
+}
+
+func TestMigrationDiskFull(t *testing.T) {
+	fs := fstest.MapFS{
+		"migrations/001_init.sql": {Data: []byte(`CREATE TABLE full_blobs (data BLOB);`)},
+		"migrations/002_fill.sql": {Data: []byte(`
+			WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+			INSERT INTO full_blobs (data) SELECT randomblob(4096) FROM n;
+		`)},
+	}
+
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	// limit the database to a few pages so the second file runs out of space
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	err = conn.Exec(ctx, `PRAGMA max_page_count = 50;`)
+	assert.NoError(t, err)
+	conn.Done()
+
+	err = sqlite.Migration(ctx, db, fs, "migrations")
+	assert.ErrorIs(t, err, sqlite.ErrMigrationIO)
+	assert.True(t, sqlite.IsIOError(err))
+
+	var migrationErr *sqlite.MigrationError
+	assert.True(t, errors.As(err, &migrationErr))
+	assert.Equal(t, "migrations/002_fill.sql", migrationErr.File)
+
+	assert.Equal(t, int64(1), countMigrations(t, db))
+}
+
+func TestMigrationHooks(t *testing.T) {
+	fs := fstest.MapFS{
+		"migrations/001_users.sql":  {Data: []byte(`CREATE TABLE hook_users (name TEXT); INSERT INTO hook_users (name) VALUES ('alice'), ('bob');`)},
+		"migrations/002_orders.sql": {Data: []byte(`CREATE TABLE hook_orders (id INTEGER PRIMARY KEY);`)},
+	}
+
+	ctx := context.Background()
+	db := createMigrationDB(t)
+
+	var calls []string
+
+	before := func(ctx context.Context, conn *sqlite.Conn, filename string) error {
+		calls = append(calls, "before "+filename)
+		return nil
+	}
+
+	after := func(ctx context.Context, conn *sqlite.Conn, filename string) error {
+		calls = append(calls, "after "+filename)
+
+		if filename == "migrations/002_orders.sql" {
+			return errors.New("orders are not ready")
+		}
+
+		// a data transformation that is awkward in SQL
+		var names []string
+		for stmt, err := range conn.Query(ctx, `SELECT name FROM hook_users;`) {
+			if err != nil {
+				return err
+			}
+			names = append(names, stmt.GetText("name"))
+		}
+		for _, name := range names {
+			err := conn.Exec(ctx, `UPDATE hook_users SET name = ? WHERE name = ?;`, strings.ToUpper(name), name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err := sqlite.Migration(ctx, db, fs, "migrations", sqlite.WithMigrationHooks(before, after))
+	assert.EqualError(t, err, "migration migrations/002_orders.sql: orders are not ready")
+
+	assert.Equal(t, []string{
+		"before migrations/001_users.sql",
+		"after migrations/001_users.sql",
+		"before migrations/002_orders.sql",
+		"after migrations/002_orders.sql",
+	}, calls)
+	assert.Equal(t, int64(1), countMigrations(t, db))
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	tables, err := conn.Tables(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hook_users"}, tables)
+
+	var names []string
+	for stmt, err := range conn.Query(ctx, `SELECT name FROM hook_users ORDER BY name;`) {
+		assert.NoError(t, err)
+		names = append(names, stmt.GetText("name"))
+	}
+	assert.Equal(t, []string{"ALICE", "BOB"}, names)
+}
+
+func TestMigrationTemplates(t *testing.T) {
+	fs := fstest.MapFS{
+		"migrations/001_users.sql.tmpl": {Data: []byte(`CREATE TABLE {{.prefix}}users (id INTEGER PRIMARY KEY, shard INTEGER CHECK (shard < {{.shards}}));`)},
+		"migrations/002_seed.sql":       {Data: []byte(`INSERT INTO tenant_users (shard) VALUES (3);`)},
+	}
+
+	ctx := context.Background()
+
+	t.Run("rendered", func(t *testing.T) {
+		db := createMigrationDB(t)
+
+		err := sqlite.Migration(ctx, db, fs, "migrations", sqlite.WithMigrationTemplateData(map[string]any{
+			"prefix": "tenant_",
+			"shards": 4,
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), countMigrations(t, db))
+
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer conn.Done()
+
+		count, err := conn.Count(ctx, "tenant_users", "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		exists, err := conn.Exists(ctx, `SELECT 1 FROM migrations_sqlite WHERE filename = ?;`, "migrations/001_users.sql.tmpl")
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		db := createMigrationDB(t)
+
+		err := sqlite.Migration(ctx, db, fs, "migrations", sqlite.WithMigrationTemplateData(map[string]any{
+			"prefix": "tenant_",
+		}))
+
+		var migrationErr *sqlite.MigrationError
+		assert.True(t, errors.As(err, &migrationErr))
+		assert.Equal(t, "migrations/001_users.sql.tmpl", migrationErr.File)
+		assert.Equal(t, int64(0), countMigrations(t, db))
+	})
+}
+
+func TestMigrationGroups(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("applied together", func(t *testing.T) {
+		fs := fstest.MapFS{
+			"migrations/001_init.sql":                 {Data: []byte(`CREATE TABLE group_users (id INTEGER PRIMARY KEY);`)},
+			"migrations/002_billing/001_accounts.sql": {Data: []byte(`CREATE TABLE group_accounts (id INTEGER PRIMARY KEY);`)},
+			"migrations/002_billing/002_invoices.sql": {Data: []byte(`CREATE TABLE group_invoices (account_id INTEGER REFERENCES group_accounts (id));`)},
+			"migrations/003_seed.sql":                 {Data: []byte(`INSERT INTO group_accounts (id) VALUES (1);`)},
+			"migrations/002_billing/notes.txt":        {Data: []byte(`not a migration`)},
+			"migrations/004_empty/readme.md":          {Data: []byte(`no sql files, not a group`)},
+			"migrations/down/002_billing.sql":         {Data: []byte(`DROP TABLE group_accounts;`)},
+			"migrations/fixtures/users.sql":           {Data: []byte(`INSERT INTO group_users (id) VALUES (1);`)},
+		}
+
+		db := createMigrationDB(t)
+
+		err := sqlite.Migration(ctx, db, fs, "migrations")
+		assert.NoError(t, err)
+
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer conn.Done()
+
+		var filenames []string
+		for stmt, err := range conn.Query(ctx, `SELECT filename FROM migrations_sqlite ORDER BY filename;`) {
+			assert.NoError(t, err)
+			filenames = append(filenames, stmt.GetText("filename"))
+		}
+		assert.Equal(t, []string{
+			"migrations/001_init.sql",
+			"migrations/002_billing",
+			"migrations/003_seed.sql",
+		}, filenames)
+
+		// directories without a numeric prefix are not groups
+		tables, err := conn.Tables(ctx)
+		assert.NoError(t, err)
+		assert.Contains(t, tables, "group_accounts")
+
+		count, err := conn.Count(ctx, "group_users", "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("file added to an applied group", func(t *testing.T) {
+		fs := fstest.MapFS{
+			"migrations/001_billing/001_accounts.sql": {Data: []byte(`CREATE TABLE group_accounts (id INTEGER PRIMARY KEY);`)},
+		}
+
+		db := createMigrationDB(t)
+
+		err := sqlite.Migration(ctx, db, fs, "migrations")
+		assert.NoError(t, err)
+
+		fs["migrations/001_billing/002_invoices.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE group_invoices (account_id INTEGER);`)}
+
+		// the group is recorded as a whole and never run again
+		err = sqlite.Migration(ctx, db, fs, "migrations")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), countMigrations(t, db))
+
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer conn.Done()
+
+		tables, err := conn.Tables(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"group_accounts"}, tables)
+	})
+
+	t.Run("rolled back together", func(t *testing.T) {
+		fs := fstest.MapFS{
+			"migrations/001_init.sql":                 {Data: []byte(`CREATE TABLE group_users (id INTEGER PRIMARY KEY);`)},
+			"migrations/002_billing/001_accounts.sql": {Data: []byte(`CREATE TABLE group_accounts (id INTEGER PRIMARY KEY);`)},
+			"migrations/002_billing/002_invoices.sql": {Data: []byte(`INSERT INTO missing_table VALUES (1);`)},
+		}
+
+		db := createMigrationDB(t)
+
+		err := sqlite.Migration(ctx, db, fs, "migrations")
+		var migrationErr *sqlite.MigrationError
+		assert.True(t, errors.As(err, &migrationErr))
+		assert.Equal(t, "migrations/002_billing/002_invoices.sql", migrationErr.File)
+		assert.Equal(t, int64(1), countMigrations(t, db))
+
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		defer conn.Done()
+
+		tables, err := conn.Tables(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"group_users"}, tables)
+	})
+}
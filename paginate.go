@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// Cursor tells Paginate how to resume after the last row of a page. Column is
+// a result column of the query whose values are unique, e.g. the primary key,
+// and Value returns it for a scanned row.
+type Cursor[T any] struct {
+	Column string
+	Value  func(T) any
+}
+
+// Paginate walks the rows of baseSQL a page of at most pageSize rows at a
+// time, using keyset pagination on cursor.Column: every page is a separate
+// query, on a connection taken from the pool only for that page, that
+// resumes after the last row of the previous one. No statement stays open
+// between pages, so writers are never held back by a long export. With
+// WithReadWriteSplit, pages are read from the read pool.
+//
+//	pages := sqlite.Paginate(ctx, db, 500, scanUser, sqlite.Cursor[User]{
+//		Column: "id",
+//		Value:  func(u User) any { return u.ID },
+//	}, `SELECT id, name FROM users WHERE active = ?`, true)
+//
+//	for page, err := range pages {
+//		...
+//	}
+//
+// baseSQL is wrapped in a subquery that adds the cursor condition, the order
+// by cursor.Column and the limit, so it must not have its own ORDER BY or
+// LIMIT. Rows come in ascending cursor order.
+func Paginate[T any](ctx context.Context, db *Database, pageSize int, scan func(*Stmt) (T, error), cursor Cursor[T], baseSQL string, args ...any) iter.Seq2[[]T, error] {
+	return func(yield func([]T, error) bool) {
+		if pageSize < 1 {
+			yield(nil, fmt.Errorf("%w: page size must be positive, got %d", ErrPrepareSQL, pageSize))
+			return
+		}
+
+		if !isIdentifierPart(cursor.Column) {
+			yield(nil, fmt.Errorf("%w: invalid cursor column %q", ErrPrepareSQL, cursor.Column))
+			return
+		}
+
+		baseSQL = strings.TrimRight(strings.TrimSpace(baseSQL), ";")
+
+		firstSQL := fmt.Sprintf("SELECT * FROM (%s) ORDER BY %s LIMIT ?;", baseSQL, cursor.Column)
+		nextSQL := fmt.Sprintf("SELECT * FROM (%s) WHERE %s > ? ORDER BY %s LIMIT ?;", baseSQL, cursor.Column, cursor.Column)
+
+		sql, values := firstSQL, append(args[:len(args):len(args)], pageSize)
+
+		for {
+			page, err := paginatePage(ctx, db, scan, sql, values)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if len(page) == 0 {
+				return
+			}
+
+			if !yield(page, nil) {
+				return
+			}
+
+			if len(page) < pageSize {
+				return
+			}
+
+			last := cursor.Value(page[len(page)-1])
+			sql, values = nextSQL, append(args[:len(args):len(args)], last, pageSize)
+		}
+	}
+}
+
+func paginatePage[T any](ctx context.Context, db *Database, scan func(*Stmt) (T, error), sql string, values []any) ([]T, error) {
+	conn, err := db.ConnFor(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Done()
+
+	var page []T
+	for stmt, err := range conn.Query(ctx, sql, values...) {
+		if err != nil {
+			return nil, err
+		}
+
+		item, err := scan(stmt)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, item)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,93 @@
+package sqlite_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	type item struct {
+		ID   int64
+		Name string
+	}
+
+	err = db.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		err := conn.ExecScript(`CREATE TABLE page_items (id INTEGER PRIMARY KEY, name TEXT, kind TEXT);`)
+		if err != nil {
+			return err
+		}
+
+		for i := range 50 {
+			kind := "even"
+			if i%2 == 1 {
+				kind = "odd"
+			}
+			err = conn.Exec(ctx, `INSERT INTO page_items (id, name, kind) VALUES (?, ?, ?);`, i+1, fmt.Sprintf("item-%d", i+1), kind)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	assert.NoError(t, err)
+
+	scan := func(stmt *sqlite.Stmt) (item, error) {
+		return item{ID: stmt.GetInt64("id"), Name: stmt.GetText("name")}, nil
+	}
+
+	cursor := sqlite.Cursor[item]{
+		Column: "id",
+		Value:  func(it item) any { return it.ID },
+	}
+
+	var sizes []int
+	var ids []int64
+	for page, err := range sqlite.Paginate(ctx, db, 10, scan, cursor, `SELECT id, name FROM page_items WHERE kind = ?;`, "even") {
+		assert.NoError(t, err)
+		sizes = append(sizes, len(page))
+		for _, it := range page {
+			ids = append(ids, it.ID)
+		}
+
+		// the pool has a single connection, so this only works if the page
+		// gave it back
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.Exec(ctx, `UPDATE page_items SET name = name || '!' WHERE id = 2;`))
+		conn.Done()
+	}
+	assert.Equal(t, []int{10, 10, 5}, sizes)
+	assert.Len(t, ids, 25)
+	assert.Equal(t, int64(1), ids[0])
+	assert.Equal(t, int64(49), ids[24])
+
+	t.Run("break", func(t *testing.T) {
+		var pages int
+		for _, err := range sqlite.Paginate(ctx, db, 10, scan, cursor, `SELECT id, name FROM page_items`) {
+			assert.NoError(t, err)
+			pages++
+			break
+		}
+		assert.Equal(t, 1, pages)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		bad := sqlite.Cursor[item]{Column: "id; DROP TABLE page_items", Value: cursor.Value}
+		for _, err := range sqlite.Paginate(ctx, db, 10, scan, bad, `SELECT id, name FROM page_items`) {
+			assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// pool is a fixed size pool of connections. It works like sqlitex.Pool, which
+// it replaces, but can also swap a connection for a fresh one once it has
+// been open for longer than maxLifetime, see WithMaxConnLifetime.
+type pool struct {
+	uri     string
+	flags   OpenFlags
+	prepare sqlitex.ConnPrepareFunc
+
+	maxLifetime time.Duration
+	log         func() *slog.Logger
+	onError     func(error)
+
+	free   chan *sqlite.Conn
+	closed chan struct{}
+
+	mu  sync.Mutex
+	all map[*sqlite.Conn]*pooledConn
+}
+
+// pooledConn is the bookkeeping of one connection of the pool
+type pooledConn struct {
+	cancel   context.CancelFunc
+	openedAt time.Time
+	inited   bool
+}
+
+func newPool(uri string, opts sqlitex.PoolOptions, maxLifetime time.Duration, log func() *slog.Logger, onError func(error)) (_ *pool, err error) {
+	if uri == ":memory:" {
+		return nil, errors.New(`sqlite: ":memory:" does not work with multiple connections, use "file::memory:?mode=memory&cache=shared"`)
+	}
+
+	size := opts.PoolSize
+	if size < 1 {
+		size = defaultPoolSize
+	}
+
+	flags := opts.Flags
+	if flags == 0 {
+		flags = OpenReadWrite | OpenCreate | OpenWAL | OpenURI
+	}
+
+	p := &pool{
+		uri:         uri,
+		flags:       flags,
+		prepare:     opts.PrepareConn,
+		maxLifetime: maxLifetime,
+		log:         log,
+		onError:     onError,
+		free:        make(chan *sqlite.Conn, size),
+		closed:      make(chan struct{}),
+		all:         make(map[*sqlite.Conn]*pooledConn, size),
+	}
+	defer func() {
+		if err != nil {
+			p.Close()
+		}
+	}()
+
+	for range size {
+		conn, err := sqlite.OpenConn(p.uri, p.flags)
+		if err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		p.all[conn] = &pooledConn{cancel: func() {}, openedAt: time.Now()}
+		p.mu.Unlock()
+
+		p.free <- conn
+	}
+
+	return p, nil
+}
+
+// Take returns a free connection, waiting for one as long as ctx allows. The
+// connection is interrupted once ctx is done, and prepared first if it's
+// new. A connection that fails to be prepared is evicted: its error is passed
+// to onError and it's replaced by a fresh one.
+func (p *pool) Take(ctx context.Context) (*sqlite.Conn, error) {
+	select {
+	case conn := <-p.free:
+		ctx, cancel := context.WithCancel(ctx)
+		conn.SetInterrupt(ctx.Done())
+
+		p.mu.Lock()
+		pc := p.all[conn]
+		pc.cancel = cancel
+		inited := pc.inited || p.prepare == nil
+		p.mu.Unlock()
+
+		if !inited {
+			if err := p.prepare(conn); err != nil {
+				// a connection that failed half way through preparing, e.g.
+				// attached but missing a function, is replaced by a fresh one
+				p.onError(err)
+				p.put(conn, true)
+				return nil, fmt.Errorf("get sqlite connection: %w", err)
+			}
+
+			p.mu.Lock()
+			pc.inited = true
+			p.mu.Unlock()
+		}
+
+		return conn, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("get sqlite connection: %w", ctx.Err())
+	case <-p.closed:
+		return nil, fmt.Errorf("get sqlite connection: pool closed")
+	}
+}
+
+// Put returns conn to the pool, it panics if conn still has an active
+// statement. A connection older than maxLifetime is closed and replaced.
+func (p *pool) Put(conn *sqlite.Conn) {
+	if query := conn.CheckReset(); query != "" {
+		panic(fmt.Sprintf("connection returned to pool has active statement: %q", query))
+	}
+	p.put(conn, false)
+}
+
+// put returns conn to the pool, replacing it by a fresh connection when it's
+// broken or has expired
+func (p *pool) put(conn *sqlite.Conn, broken bool) {
+	p.mu.Lock()
+	pc, found := p.all[conn]
+	if !found {
+		p.mu.Unlock()
+		panic("sqlite: connection not created by this pool")
+	}
+	cancel := pc.cancel
+	pc.cancel = func() {}
+	expired := p.maxLifetime > 0 && time.Since(pc.openedAt) > p.maxLifetime
+	p.mu.Unlock()
+
+	conn.SetInterrupt(nil)
+	cancel()
+
+	if broken || expired {
+		conn = p.recycle(conn)
+	}
+
+	p.free <- conn
+}
+
+// recycle closes conn and returns a freshly opened connection in its place.
+// If the new connection can't be opened, conn is kept and used a bit longer.
+func (p *pool) recycle(conn *sqlite.Conn) *sqlite.Conn {
+	fresh, err := sqlite.OpenConn(p.uri, p.flags)
+	if err != nil {
+		p.log().Warn("failed to recycle connection", "error", err)
+		p.onError(fmt.Errorf("recycle sqlite connection: %w", err))
+		return conn
+	}
+
+	// swapped in one go, so Close always sees as many connections as there
+	// are in free
+	p.mu.Lock()
+	delete(p.all, conn)
+	p.all[fresh] = &pooledConn{cancel: func() {}, openedAt: time.Now()}
+	p.mu.Unlock()
+
+	err = conn.Close()
+	if err != nil {
+		p.log().Warn("failed to close recycled connection", "error", err)
+		p.onError(fmt.Errorf("close recycled sqlite connection: %w", err))
+	}
+
+	return fresh
+}
+
+// errPoolClosed is returned by Close when the pool is already closed
+var errPoolClosed = errors.New("sqlite: pool already closed")
+
+// IsClosed reports whether Close was called
+func (p *pool) IsClosed() bool {
+	select {
+	case <-p.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close interrupts and closes every connection, waiting for the ones that
+// are checked out to be returned. Calling it again returns errPoolClosed.
+func (p *pool) Close() (err error) {
+	p.mu.Lock()
+	if p.IsClosed() {
+		p.mu.Unlock()
+		return errPoolClosed
+	}
+	close(p.closed)
+
+	n := len(p.all)
+	cancels := make([]context.CancelFunc, 0, n)
+	for _, pc := range p.all {
+		cancels = append(cancels, pc.cancel)
+		pc.cancel = func() {}
+	}
+	p.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	for range n {
+		conn := <-p.free
+		if closeErr := conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
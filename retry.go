@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+)
+
+// attempts and first backoff of RunInTx
+const (
+	runInTxAttempts = 5
+	runInTxBackoff  = 10 * time.Millisecond
+)
+
+// ExecWithRetry takes a connection and runs fn with it. If fn fails because
+// the database is busy or locked, it's retried on a fresh connection up to
+// attempts times in total, waiting backoff before the first retry and doubling
+// it after each one. Any other error is returned right away. The wait is
+// cut short if ctx is done.
+func (db *Database) ExecWithRetry(ctx context.Context, attempts int, backoff time.Duration, fn func(*Conn) error) error {
+	var err error
+
+	for attempt := 0; attempt < max(attempts, 1); attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			backoff *= 2
+		}
+
+		err = db.Exec(ctx, func(ctx context.Context, conn *Conn) error {
+			return fn(conn)
+		})
+		if !IsBusy(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// RunInTx runs fn within a transaction, which is committed if fn returns nil
+// and rolled back otherwise. If fn, or the commit, fails because the database
+// is busy or locked, e.g. another connection wrote since the transaction
+// started reading, the transaction is rolled back and fn is run again from
+// the start in a new one, a few times with an increasing backoff.
+//
+// fn can run more than once, so it must be idempotent: read what it needs
+// within the transaction and have no side effects outside of it.
+func (db *Database) RunInTx(ctx context.Context, fn func(*Conn) error) error {
+	return db.ExecWithRetry(ctx, runInTxAttempts, runInTxBackoff, func(conn *Conn) (err error) {
+		defer conn.Save()(&err)
+		return fn(conn)
+	})
+}
@@ -0,0 +1,147 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecWithRetry(t *testing.T) {
+	ctx := context.Background()
+
+	// two databases on the same file, without shared cache, behave like two processes
+	stringConn := "file:" + filepath.Join(t.TempDir(), "retry.db")
+
+	db1, err := sqlite.New(ctx, sqlite.WithStringConn(stringConn), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db1.Close()
+	})
+
+	db2, err := sqlite.New(ctx, sqlite.WithStringConn(stringConn), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db2.Close()
+	})
+
+	err = db1.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE IF NOT EXISTS retry_items (name TEXT);
+			PRAGMA busy_timeout = 10;
+		`)
+	})
+	assert.NoError(t, err)
+
+	// hold the write lock from the second database for a while
+	locker, err := db2.Conn(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, locker.Exec(ctx, `BEGIN IMMEDIATE;`))
+
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(t, locker.Exec(ctx, `COMMIT;`))
+		locker.Done()
+	}()
+
+	var calls atomic.Int32
+	err = db1.ExecWithRetry(ctx, 10, 20*time.Millisecond, func(conn *sqlite.Conn) error {
+		calls.Add(1)
+		return conn.Exec(ctx, `INSERT INTO retry_items (name) VALUES (?);`, "item")
+	})
+	assert.NoError(t, err)
+	assert.Greater(t, calls.Load(), int32(1))
+
+	<-released
+
+	// non busy errors are not retried
+	calls.Store(0)
+	err = db1.ExecWithRetry(ctx, 10, time.Millisecond, func(conn *sqlite.Conn) error {
+		calls.Add(1)
+		return conn.Exec(ctx, `INSERT INTO table_does_not_exist (name) VALUES (?);`, "item")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRunInTx(t *testing.T) {
+	ctx := context.Background()
+
+	stringConn := "file:" + filepath.Join(t.TempDir(), "runintx.db")
+
+	db1, err := sqlite.New(ctx, sqlite.WithStringConn(stringConn), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db1.Close()
+	})
+
+	db2, err := sqlite.New(ctx, sqlite.WithStringConn(stringConn), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db2.Close()
+	})
+
+	err = db1.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		return conn.ExecScript(`
+			CREATE TABLE IF NOT EXISTS counters (id INTEGER PRIMARY KEY, value INTEGER NOT NULL);
+			INSERT INTO counters (id, value) VALUES (1, 0);
+		`)
+	})
+	assert.NoError(t, err)
+
+	scanValue := func(stmt *sqlite.Stmt) (int64, error) {
+		return stmt.GetInt64("value"), nil
+	}
+
+	var calls atomic.Int32
+	err = db1.RunInTx(ctx, func(conn *sqlite.Conn) error {
+		value, err := sqlite.QueryRow(ctx, conn, scanValue, `SELECT value FROM counters WHERE id = 1;`)
+		if err != nil {
+			return err
+		}
+
+		// on the first attempt, another writer commits after we've read
+		if calls.Add(1) == 1 {
+			err := db2.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+				return conn.Exec(ctx, `UPDATE counters SET value = value + 1 WHERE id = 1;`)
+			})
+			assert.NoError(t, err)
+		}
+
+		return conn.Exec(ctx, `UPDATE counters SET value = ? WHERE id = 1;`, value+1)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+
+	err = db1.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		value, err := sqlite.QueryRow(ctx, conn, scanValue, `SELECT value FROM counters WHERE id = 1;`)
+		assert.Equal(t, int64(2), value)
+		return err
+	})
+	assert.NoError(t, err)
+
+	// other errors roll back and are returned right away
+	calls.Store(0)
+	err = db1.RunInTx(ctx, func(conn *sqlite.Conn) error {
+		calls.Add(1)
+		if err := conn.Exec(ctx, `UPDATE counters SET value = 100 WHERE id = 1;`); err != nil {
+			return err
+		}
+		return conn.Exec(ctx, `INSERT INTO table_does_not_exist (name) VALUES (?);`, "item")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	err = db1.Exec(ctx, func(ctx context.Context, conn *sqlite.Conn) error {
+		value, err := sqlite.QueryRow(ctx, conn, scanValue, `SELECT value FROM counters WHERE id = 1;`)
+		assert.Equal(t, int64(2), value)
+		return err
+	})
+	assert.NoError(t, err)
+}
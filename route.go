@@ -0,0 +1,194 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// WithReadWriteSplit opens a second pool of readers read-only connections to
+// the same database next to the regular one, and makes ConnFor hand them out
+// for statements that only read, see ConnFor. With WAL, readers never wait
+// for the writer, so long reports don't hold up the write pool. The read
+// connections are prepared like the others, including WithConnPrepareFunc,
+// minus the pragmas that need writing.
+//
+// It pays off with a database file: an in-memory database has no WAL and its
+// shared cache makes readers and the writer take table locks on each other.
+func WithReadWriteSplit(readers int) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		if readers < 1 {
+			return fmt.Errorf("read write split: readers must be positive, got %d", readers)
+		}
+		db.readers = readers
+		return nil
+	}
+}
+
+// ConnFor returns a connection suited to run sql, a single statement: one
+// from the read pool set up by WithReadWriteSplit when sql only reads, one
+// from the regular pool otherwise, or always without a read pool. Paginate
+// takes its connections from it.
+//
+// sql is routed by its leading keyword, after comments and whitespace:
+// SELECT, EXPLAIN and the PRAGMA statements that only report something, e.g.
+// PRAGMA user_version or PRAGMA table_info(users), read, everything else goes
+// to the regular pool. A statement starting with WITH is routed by the keyword
+// following its common table expressions, so WITH ... SELECT reads while
+// WITH ... INSERT, UPDATE or DELETE, a CTE statement with side effects, goes
+// to the regular pool. Side effects hidden in a read, e.g. a custom function
+// that writes, can't be detected: use Conn for those, a read-only connection
+// fails them with SQLITE_READONLY.
+func (db *Database) ConnFor(ctx context.Context, sql string) (*Conn, error) {
+	if db.readPool == nil || !isReadOnlySQL(sql) {
+		return db.Conn(ctx)
+	}
+	return db.take(ctx, db.readPool, "")
+}
+
+// isReadOnlySQL reports whether the statement sql only reads, by looking at
+// its leading keyword. A WITH clause is skipped over, its tables are in
+// parentheses, and the keyword after it decides.
+func isReadOnlySQL(sql string) bool {
+	depth := 0
+	with := false
+
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(sql[i+1:], closing)
+			if end == -1 {
+				return false
+			}
+			i += end + 1
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				return false
+			}
+			i += end
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				return false
+			}
+			i += end + 3
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case isWordStart(c):
+			j := i + 1
+			for j < len(sql) && isWordChar(sql[j]) {
+				j++
+			}
+			word := strings.ToUpper(sql[i:j])
+			i = j - 1
+
+			if depth > 0 {
+				continue
+			}
+
+			switch word {
+			case "SELECT", "EXPLAIN":
+				return true
+			case "PRAGMA":
+				return !with && isReadOnlyPragma(sql[j:])
+			case "WITH":
+				if with {
+					return false
+				}
+				with = true
+			case "INSERT", "UPDATE", "DELETE", "REPLACE":
+				return false
+			default:
+				// names and AS between the tables of a WITH clause
+				if !with {
+					return false
+				}
+			}
+		case c == ';':
+			return false
+		}
+	}
+
+	return false
+}
+
+// readPragmas are the pragmas that only report something when they're not
+// given a value, mapped to whether they take an argument in parentheses that
+// doesn't change anything, like a table name. Any other pragma, and any of
+// these given a value, goes to the regular pool: PRAGMA user_version(5) sets
+// it and PRAGMA optimize or incremental_vacuum write with no value at all.
+var readPragmas = map[string]bool{
+	"application_id":    false,
+	"auto_vacuum":       false,
+	"cache_size":        false,
+	"collation_list":    false,
+	"compile_options":   false,
+	"data_version":      false,
+	"database_list":     false,
+	"encoding":          false,
+	"foreign_key_check": true,
+	"foreign_key_list":  true,
+	"foreign_keys":      false,
+	"freelist_count":    false,
+	"function_list":     false,
+	"index_info":        true,
+	"index_list":        true,
+	"index_xinfo":       true,
+	"integrity_check":   true,
+	"journal_mode":      false,
+	"module_list":       false,
+	"page_count":        false,
+	"page_size":         false,
+	"pragma_list":       false,
+	"quick_check":       true,
+	"schema_version":    false,
+	"synchronous":       false,
+	"table_info":        true,
+	"table_list":        true,
+	"table_xinfo":       true,
+	"user_version":      false,
+}
+
+// isReadOnlyPragma reports whether rest, what follows the PRAGMA keyword,
+// names one of readPragmas, optionally prefixed by a schema, with no value or
+// an argument it allows.
+func isReadOnlyPragma(rest string) bool {
+	rest = strings.TrimSpace(rest)
+
+	name, rest := pragmaWord(rest)
+	if strings.HasPrefix(rest, ".") {
+		name, rest = pragmaWord(rest[1:])
+	}
+
+	takesArg, ok := readPragmas[strings.ToLower(name)]
+	if !ok {
+		return false
+	}
+
+	rest = strings.TrimSpace(rest)
+	if takesArg && strings.HasPrefix(rest, "(") {
+		end := strings.IndexByte(rest, ')')
+		if end == -1 {
+			return false
+		}
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+
+	return rest == "" || rest == ";"
+}
+
+// pragmaWord splits the leading word off s
+func pragmaWord(s string) (word, rest string) {
+	i := 0
+	for i < len(s) && isWordChar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReadOnlySQL(t *testing.T) {
+	testCases := []struct {
+		sql  string
+		read bool
+	}{
+		{`SELECT * FROM users;`, true},
+		{`select 1`, true},
+		{"  \n\t SELECT 1;", true},
+		{`-- latest users
+SELECT * FROM users;`, true},
+		{`/* report */ SELECT * FROM users;`, true},
+		{`/* DELETE */ -- INSERT
+		  select 1`, true},
+		{`EXPLAIN QUERY PLAN SELECT * FROM users;`, true},
+		{`EXPLAIN DELETE FROM users;`, true},
+		{`PRAGMA table_info(users);`, true},
+		{`PRAGMA journal_mode;`, true},
+		{`PRAGMA journal_mode = WAL;`, false},
+		{`PRAGMA main.user_version;`, true},
+		{`pragma USER_VERSION`, true},
+		{`PRAGMA index_list("users");`, true},
+		{`PRAGMA integrity_check;`, true},
+		{`PRAGMA user_version(5);`, false},
+		{`PRAGMA user_version = 5;`, false},
+		{`PRAGMA main.user_version(5);`, false},
+		{`PRAGMA wal_checkpoint(TRUNCATE);`, false},
+		{`PRAGMA wal_checkpoint;`, false},
+		{`PRAGMA optimize;`, false},
+		{`PRAGMA incremental_vacuum;`, false},
+		{`PRAGMA incremental_vacuum(10);`, false},
+		{`PRAGMA shrink_memory;`, false},
+		{`PRAGMA table_info(users); DELETE FROM users;`, false},
+		{`WITH active AS (SELECT * FROM users WHERE active) SELECT * FROM active;`, true},
+		{`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 10) SELECT i FROM n;`, true},
+		{`WITH a AS (SELECT 1), "b c" AS MATERIALIZED (SELECT 2) SELECT * FROM a, "b c";`, true},
+		{`WITH old AS (SELECT id FROM users WHERE age > 90) DELETE FROM users WHERE id IN old;`, false},
+		{`WITH x AS (SELECT 1) INSERT INTO users (id) SELECT * FROM x;`, false},
+		{`WITH x AS (SELECT 1) UPDATE users SET age = 1;`, false},
+		{`INSERT INTO users (name) VALUES ('SELECT');`, false},
+		{`UPDATE users SET name = 'x';`, false},
+		{`DELETE FROM users;`, false},
+		{`REPLACE INTO users (id) VALUES (1);`, false},
+		{`CREATE TABLE users (id INTEGER);`, false},
+		{`BEGIN;`, false},
+		{`VACUUM;`, false},
+		{`-- SELECT`, false},
+		{`/* SELECT`, false},
+		{``, false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.read, isReadOnlySQL(tc.sql), tc.sql)
+	}
+}
@@ -0,0 +1,225 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// ErrScan is reported by Scanner when a column is missing or its value
+// can't be read as the requested type
+var ErrScan = errors.New("database failed to scan column")
+
+// Scanner reads columns by name like GetText and friends but checks every
+// read and keeps the first failure, so a whole row can be scanned before
+// checking for errors once:
+//
+//	sc := sqlite.NewScanner(stmt)
+//	name := sc.Text("name")
+//	age := sc.Int("age")
+//	if err := sc.Err(); err != nil {
+//		return err
+//	}
+//
+// A read fails if the column doesn't exist or its storage class doesn't fit,
+// e.g. Int on a TEXT or a fractional REAL value. NULL reads as the zero
+// value. A failed read returns the zero value as well.
+type Scanner struct {
+	stmt *Stmt
+	err  error
+}
+
+// NewScanner builds a Scanner for stmt, it can be reused for every row of the
+// statement
+func NewScanner(stmt *Stmt) *Scanner {
+	return &Scanner{stmt: stmt}
+}
+
+// Err returns the first error of any read so far
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// column returns the index of col if its storage class is one of types or
+// NULL, isNull is true for NULL
+func (s *Scanner) column(col string, want string, types ...ColumnType) (idx int, isNull bool, ok bool) {
+	idx = s.stmt.ColumnIndex(col)
+	if idx < 0 {
+		s.fail(fmt.Errorf("%w: no column %s", ErrScan, col))
+		return -1, false, false
+	}
+
+	typ := s.stmt.ColumnType(idx)
+	if typ == TypeNull {
+		return idx, true, true
+	}
+
+	for _, t := range types {
+		if typ == t {
+			return idx, false, true
+		}
+	}
+
+	s.fail(fmt.Errorf("%w: column %s holds %s, not %s", ErrScan, col, typ, want))
+	return idx, false, false
+}
+
+func (s *Scanner) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Text reads col as a string, numbers are converted
+func (s *Scanner) Text(col string) string {
+	idx, isNull, ok := s.column(col, "text", TypeText, TypeInteger, TypeFloat)
+	if !ok || isNull {
+		return ""
+	}
+	return s.stmt.ColumnText(idx)
+}
+
+// Int reads col as an integer, a REAL is accepted if it's a whole number
+func (s *Scanner) Int(col string) int64 {
+	idx, isNull, ok := s.column(col, "an integer", TypeInteger, TypeFloat)
+	if !ok || isNull {
+		return 0
+	}
+
+	if s.stmt.ColumnType(idx) == TypeFloat {
+		f := s.stmt.ColumnFloat(idx)
+		if f != math.Trunc(f) {
+			s.fail(fmt.Errorf("%w: column %s holds %v, not an integer", ErrScan, col, f))
+			return 0
+		}
+	}
+
+	return s.stmt.ColumnInt64(idx)
+}
+
+// Float reads col as a float
+func (s *Scanner) Float(col string) float64 {
+	idx, isNull, ok := s.column(col, "a float", TypeFloat, TypeInteger)
+	if !ok || isNull {
+		return 0
+	}
+	return s.stmt.ColumnFloat(idx)
+}
+
+// Bool reads col like LoadBool does, but only 0 and 1 are accepted
+func (s *Scanner) Bool(col string) bool {
+	value := s.Int(col)
+	if value != 0 && value != 1 {
+		s.fail(fmt.Errorf("%w: column %s holds %d, not a bool", ErrScan, col, value))
+		return false
+	}
+	return value == 1
+}
+
+// Time reads a time stored with the default TimeSeconds encoding like
+// LoadTime does
+func (s *Scanner) Time(col string) time.Time {
+	idx, isNull, ok := s.column(col, "a time", TypeInteger)
+	if !ok || isNull {
+		return time.Time{}
+	}
+	return time.Unix(s.stmt.ColumnInt64(idx), 0).UTC()
+}
+
+// Bytes reads a copy of a BLOB or TEXT col, nil if it's NULL or empty
+func (s *Scanner) Bytes(col string) []byte {
+	idx, isNull, ok := s.column(col, "a blob", TypeBlob, TypeText)
+	if !ok || isNull || s.stmt.ColumnLen(idx) == 0 {
+		return nil
+	}
+
+	buf := make([]byte, s.stmt.ColumnLen(idx))
+	s.stmt.ColumnBytes(idx, buf)
+	return buf
+}
+
+// ColumnMeta describes one result column of a statement, see ColumnTypes
+type ColumnMeta struct {
+	Name string
+	// Type is the storage class of the value in the current row, it can
+	// change from one row to the next
+	Type ColumnType
+}
+
+// ColumnTypes returns the name and storage class of every result column of
+// stmt for the current row, meant for decoders of queries whose columns
+// aren't known in advance. Declared types aren't included since
+// zombiezen.com/go/sqlite doesn't expose sqlite3_column_decltype.
+func ColumnTypes(stmt *Stmt) []ColumnMeta {
+	count := stmt.ColumnCount()
+
+	cols := make([]ColumnMeta, count)
+	for i := range count {
+		cols[i] = ColumnMeta{
+			Name: stmt.ColumnName(i),
+			Type: stmt.ColumnType(i),
+		}
+	}
+
+	return cols
+}
+
+// ScanRowMap reads every column of the current row into a map keyed by column
+// name, with int64, float64, string, []byte or nil values depending on each
+// column's storage class. It's meant for queries whose columns aren't known
+// in advance, e.g. an admin console.
+func ScanRowMap(stmt *Stmt) (map[string]any, error) {
+	count := stmt.ColumnCount()
+
+	row := make(map[string]any, count)
+	for i := range count {
+		row[stmt.ColumnName(i)] = columnValue(stmt, i)
+	}
+
+	return row, nil
+}
+
+// ScanAllMaps steps through every remaining row of stmt and scans each with
+// ScanRowMap. The statement is not reset.
+func ScanAllMaps(stmt *Stmt) ([]map[string]any, error) {
+	var rows []map[string]any
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrExecSQL, err)
+		}
+
+		if !hasRow {
+			return rows, nil
+		}
+
+		row, err := ScanRowMap(stmt)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// columnValue returns column i of the current row as the Go type matching
+// its storage class, BLOBs are copied
+func columnValue(stmt *Stmt, i int) any {
+	switch stmt.ColumnType(i) {
+	case sqlite.TypeInteger:
+		return stmt.ColumnInt64(i)
+	case sqlite.TypeFloat:
+		return stmt.ColumnFloat(i)
+	case sqlite.TypeText:
+		return stmt.ColumnText(i)
+	case sqlite.TypeBlob:
+		buf := make([]byte, stmt.ColumnLen(i))
+		stmt.ColumnBytes(i, buf)
+		return buf
+	default:
+		return nil
+	}
+}
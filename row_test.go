@@ -0,0 +1,213 @@
+package sqlite_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func createRowTestDB(tb testing.TB, rows int) *sqlite.Conn {
+	tb.Helper()
+
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(tb, err)
+	tb.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(tb, err)
+	tb.Cleanup(conn.Done)
+
+	err = conn.ExecScript(`
+		DROP TABLE IF EXISTS row_items;
+		CREATE TABLE row_items (name TEXT, age INTEGER, score REAL, active INTEGER, created_at INTEGER, data BLOB);
+	`)
+	assert.NoError(tb, err)
+
+	values := make([][]any, 0, rows)
+	for i := range rows {
+		values = append(values, []any{fmt.Sprintf("name-%d", i), i, float64(i) / 2, i%2 == 0, time.Unix(int64(i), 0), []byte{byte(i)}})
+	}
+
+	_, err = conn.BatchInsert(ctx, `INSERT INTO row_items (name, age, score, active, created_at, data) VALUES`, values)
+	assert.NoError(tb, err)
+
+	return conn
+}
+
+func benchmarkScan(b *testing.B, scan func(stmt *sqlite.Stmt) func()) {
+	ctx := context.Background()
+	conn := createRowTestDB(b, 1000)
+
+	b.ResetTimer()
+	for range b.N {
+		stmt, err := conn.Prepare(ctx, `SELECT name, age, score, active, created_at FROM row_items;`)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		read := scan(stmt)
+		for {
+			hasRow, err := stmt.Step()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !hasRow {
+				break
+			}
+			read()
+		}
+		stmt.Reset()
+	}
+}
+
+func BenchmarkScanByName(b *testing.B) {
+	benchmarkScan(b, func(stmt *sqlite.Stmt) func() {
+		return func() {
+			_ = stmt.GetText("name")
+			_ = stmt.GetInt64("age")
+			_ = stmt.GetFloat("score")
+			_ = sqlite.LoadBool(stmt, "active")
+			_ = sqlite.LoadTime(stmt, "created_at")
+		}
+	})
+}
+
+// BenchmarkScanIndex resolves the columns once, which is what pays off in
+// tight loops: Stmt already caches the name to index map GetText uses
+func BenchmarkScanIndex(b *testing.B) {
+	benchmarkScan(b, func(stmt *sqlite.Stmt) func() {
+		name, age, score, active, createdAt := stmt.ColumnIndex("name"), stmt.ColumnIndex("age"), stmt.ColumnIndex("score"), stmt.ColumnIndex("active"), stmt.ColumnIndex("created_at")
+		return func() {
+			_ = stmt.ColumnText(name)
+			_ = stmt.ColumnInt64(age)
+			_ = stmt.ColumnFloat(score)
+			_ = stmt.ColumnInt64(active) == 1
+			_ = time.Unix(stmt.ColumnInt64(createdAt), 0).UTC()
+		}
+	})
+}
+
+func TestScanner(t *testing.T) {
+	ctx := context.Background()
+	conn := createRowTestDB(t, 2)
+
+	stmt, err := conn.Prepare(ctx, `SELECT name, age, score, active, created_at, data FROM row_items ORDER BY age;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	sc := sqlite.NewScanner(stmt)
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+
+	assert.Equal(t, "name-0", sc.Text("name"))
+	assert.Equal(t, int64(0), sc.Int("age"))
+	assert.Equal(t, 0.0, sc.Float("score"))
+	assert.True(t, sc.Bool("active"))
+	assert.Equal(t, time.Unix(0, 0).UTC(), sc.Time("created_at"))
+	assert.Equal(t, []byte{0}, sc.Bytes("data"))
+	assert.Equal(t, "0", sc.Text("age"))
+	assert.NoError(t, sc.Err())
+
+	hasRow, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+
+	// the first failure is kept, reads after it still work
+	assert.Equal(t, int64(0), sc.Int("name"))
+	assert.Equal(t, int64(0), sc.Int("score"))
+	assert.Equal(t, "name-1", sc.Text("name"))
+	assert.ErrorIs(t, sc.Err(), sqlite.ErrScan)
+	assert.ErrorContains(t, sc.Err(), "column name holds SQLITE_TEXT, not an integer")
+
+	stmt, err = conn.Prepare(ctx, `SELECT 1.5 AS score, 2 AS flag, NULL AS empty;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	hasRow, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+
+	testCases := []struct {
+		name  string
+		read  func(sc *sqlite.Scanner) any
+		want  any
+		error string
+	}{
+		{"fractional int", func(sc *sqlite.Scanner) any { return sc.Int("score") }, int64(0), "holds 1.5, not an integer"},
+		{"bool out of range", func(sc *sqlite.Scanner) any { return sc.Bool("flag") }, false, "holds 2, not a bool"},
+		{"blob from number", func(sc *sqlite.Scanner) any { return sc.Bytes("flag") }, []byte(nil), "not a blob"},
+		{"missing column", func(sc *sqlite.Scanner) any { return sc.Text("missing") }, "", "no column missing"},
+		{"null", func(sc *sqlite.Scanner) any { return sc.Int("empty") }, int64(0), ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sc := sqlite.NewScanner(stmt)
+			assert.Equal(t, tc.want, tc.read(sc))
+			if tc.error == "" {
+				assert.NoError(t, sc.Err())
+			} else {
+				assert.ErrorContains(t, sc.Err(), tc.error)
+			}
+		})
+	}
+}
+
+func TestScanRowMap(t *testing.T) {
+	ctx := context.Background()
+	conn := createRowTestDB(t, 0)
+
+	err := conn.Exec(ctx, `INSERT INTO row_items (name, age, score, active, created_at, data) VALUES (?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?);`,
+		"john", 30, 1.5, true, nil, []byte{0xde, 0xad},
+		nil, nil, nil, nil, nil, nil,
+	)
+	assert.NoError(t, err)
+
+	stmt, err := conn.Prepare(ctx, `SELECT name, age, score, active, created_at, data FROM row_items ORDER BY rowid;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	rows, err := sqlite.ScanAllMaps(stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]any{
+		{"name": "john", "age": int64(30), "score": 1.5, "active": int64(1), "created_at": nil, "data": []byte{0xde, 0xad}},
+		{"name": nil, "age": nil, "score": nil, "active": nil, "created_at": nil, "data": nil},
+	}, rows)
+}
+
+func TestColumnTypes(t *testing.T) {
+	ctx := context.Background()
+	conn := createRowTestDB(t, 0)
+
+	err := conn.Exec(ctx, `INSERT INTO row_items (name, age, score, active, created_at, data) VALUES (?, ?, ?, ?, ?, ?);`,
+		"john", 30, 1.5, true, nil, []byte{0xde, 0xad},
+	)
+	assert.NoError(t, err)
+
+	stmt, err := conn.Prepare(ctx, `SELECT name, age, score AS points, created_at, data, 'x' || name AS label FROM row_items;`)
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+
+	assert.Equal(t, []sqlite.ColumnMeta{
+		{Name: "name", Type: sqlite.TypeText},
+		{Name: "age", Type: sqlite.TypeInteger},
+		{Name: "points", Type: sqlite.TypeFloat},
+		{Name: "created_at", Type: sqlite.TypeNull},
+		{Name: "data", Type: sqlite.TypeBlob},
+		{Name: "label", Type: sqlite.TypeText},
+	}, sqlite.ColumnTypes(stmt))
+}
@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ColumnInfo describes one column of a table as reported by
+// PRAGMA table_info
+type ColumnInfo struct {
+	Name       string
+	Type       string
+	NotNull    bool
+	PrimaryKey bool
+	Default    sql.NullString
+}
+
+// Tables returns the names of the user tables in the main database, sorted
+// by name. SQLite's internal tables and the migrations_sqlite table used by
+// Migration are left out.
+func (c *Conn) Tables(ctx context.Context) ([]string, error) {
+	var tables []string
+
+	for stmt, err := range c.Query(ctx, `
+		SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'migrations_sqlite'
+		ORDER BY name;
+	`) {
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, stmt.GetText("name"))
+	}
+
+	return tables, nil
+}
+
+// Columns returns the columns of table in the order they were declared. It
+// returns ErrNotFound if the table doesn't exist.
+func (c *Conn) Columns(ctx context.Context, table string) ([]ColumnInfo, error) {
+	var columns []ColumnInfo
+
+	for stmt, err := range c.Query(ctx, `SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid;`, table) {
+		if err != nil {
+			return nil, err
+		}
+
+		column := ColumnInfo{
+			Name:       stmt.GetText("name"),
+			Type:       stmt.GetText("type"),
+			NotNull:    stmt.GetBool("notnull"),
+			PrimaryKey: stmt.GetInt64("pk") > 0,
+		}
+		if !stmt.ColumnIsNull(stmt.ColumnIndex("dflt_value")) {
+			column.Default = sql.NullString{String: stmt.GetText("dflt_value"), Valid: true}
+		}
+
+		columns = append(columns, column)
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s: %w", table, ErrNotFound)
+	}
+
+	return columns, nil
+}
@@ -0,0 +1,53 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaIntrospection(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithFile(filepath.Join(t.TempDir(), "schema.db")), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE migrations_sqlite (filename TEXT PRIMARY KEY);
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			role TEXT DEFAULT 'member',
+			created_at INTEGER
+		);
+		CREATE TABLE accounts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id));
+	`)
+	assert.NoError(t, err)
+
+	tables, err := conn.Tables(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"accounts", "users"}, tables)
+
+	columns, err := conn.Columns(ctx, "users")
+	assert.NoError(t, err)
+	assert.Equal(t, []sqlite.ColumnInfo{
+		{Name: "id", Type: "INTEGER", PrimaryKey: true},
+		{Name: "name", Type: "TEXT", NotNull: true},
+		{Name: "role", Type: "TEXT", Default: sql.NullString{String: "'member'", Valid: true}},
+		{Name: "created_at", Type: "INTEGER"},
+	}, columns)
+
+	_, err = conn.Columns(ctx, "missing")
+	assert.ErrorIs(t, err, sqlite.ErrNotFound)
+}
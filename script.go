@@ -0,0 +1,176 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// SplitScript splits a script into its statements, each trimmed and ending
+// with its semicolon when it had one. Semicolons inside string literals,
+// quoted identifiers, comments and the BEGIN ... END body of CREATE TRIGGER
+// don't end a statement. Statements made only of comments are dropped.
+func SplitScript(sql string) ([]string, error) {
+	stmts, _, err := splitScript(sql, true)
+	return stmts, err
+}
+
+// splitScript does the work of SplitScript. Unless final is set, sql is only
+// the beginning of a script: an unterminated literal or comment, or the text
+// after the last semicolon, may be completed by what follows, so they are
+// left out and rest is the offset where the next call should start from.
+func splitScript(sql string, final bool) (stmts []string, rest int, err error) {
+
+	appendStmt := func(stmt string) {
+		stmt = strings.TrimSpace(stmt)
+		if !isEmptyScript(stmt) {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	start := 0
+	// leading keywords of the current statement, enough to spot a trigger
+	var words []string
+	trigger := false
+	depth := 0
+
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(sql[i+1:], closing)
+			if end == -1 && !final {
+				return stmts, start, nil
+			}
+			if end == -1 {
+				return nil, 0, fmt.Errorf("%w: unterminated %c at offset %d", ErrPrepareSQL, c, i)
+			}
+			i += end + 1
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 && !final {
+				return stmts, start, nil
+			}
+			if end == -1 {
+				i = len(sql)
+				continue
+			}
+			i += end
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 && !final {
+				return stmts, start, nil
+			}
+			if end == -1 {
+				return nil, 0, fmt.Errorf("%w: unterminated comment at offset %d", ErrPrepareSQL, i)
+			}
+			i += end + 3
+		case isWordStart(c):
+			j := i + 1
+			for j < len(sql) && isWordChar(sql[j]) {
+				j++
+			}
+			word := strings.ToUpper(sql[i:j])
+			i = j - 1
+
+			if len(words) < 3 {
+				words = append(words, word)
+				if words[0] == "CREATE" && word == "TRIGGER" {
+					trigger = true
+				}
+			}
+
+			// CASE ... END can show up within a trigger body too
+			if trigger {
+				switch word {
+				case "BEGIN", "CASE":
+					depth++
+				case "END":
+					depth--
+				}
+			}
+		case c == ';' && depth <= 0:
+			appendStmt(sql[start : i+1])
+			start = i + 1
+			words = words[:0]
+			trigger = false
+			depth = 0
+		}
+	}
+
+	if !final {
+		return stmts, start, nil
+	}
+
+	appendStmt(sql[start:])
+
+	return stmts, len(sql), nil
+}
+
+// scriptChunkSize is how much ExecScriptReader reads at a time
+const scriptChunkSize = 64 * 1024
+
+// ExecScriptReader works like ExecScript but reads the script from r and runs
+// each statement as soon as it has been read in full, so a multi-megabyte
+// seed or dump file is never held in memory as a whole. Statements are split
+// like SplitScript does and all run within a savepoint, so either all of them
+// apply or none.
+func (c *Conn) ExecScriptReader(r io.Reader) (err error) {
+	defer c.Save()(&err)
+
+	var pending []byte
+	chunk := make([]byte, scriptChunkSize)
+
+	for {
+		n, readErr := r.Read(chunk)
+		pending = append(pending, chunk[:n]...)
+
+		final := readErr == io.EOF
+		if readErr != nil && !final {
+			return readErr
+		}
+
+		stmts, rest, err := splitScript(string(pending), final)
+		if err != nil {
+			return err
+		}
+
+		for _, stmt := range stmts {
+			err = sqlitex.ExecuteTransient(c.conn, stmt, nil)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrExecSQL, err)
+			}
+		}
+
+		if final {
+			return nil
+		}
+
+		pending = append(pending[:0], pending[rest:]...)
+	}
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isWordChar(c byte) bool {
+	return isWordStart(c) || c == '$' || c >= '0' && c <= '9'
+}
+
+// RunScriptDryRun takes the same script as RunScript but only returns the
+// statements it would run, one by one, without a database to execute them
+// on. It's meant for previews, e.g. a --dry-run flag in a setup command.
+func RunScriptDryRun(ctx context.Context, sql string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return SplitScript(sql)
+}
@@ -0,0 +1,217 @@
+package sqlite_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitScript(t *testing.T) {
+	testCases := []struct {
+		name     string
+		script   string
+		expected []string
+	}{
+		{
+			name: "plain statements",
+			script: `
+				CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+				-- seed; not a statement
+				INSERT INTO users (name) VALUES ('john');
+			`,
+			expected: []string{
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);",
+				"-- seed; not a statement\n\t\t\t\tINSERT INTO users (name) VALUES ('john');",
+			},
+		},
+		{
+			name:   "semicolons in strings and identifiers",
+			script: `INSERT INTO notes (body) VALUES ('a; b', 'it''s; fine'); SELECT "odd;name", [x;y] FROM t /* ; */;`,
+			expected: []string{
+				`INSERT INTO notes (body) VALUES ('a; b', 'it''s; fine');`,
+				`SELECT "odd;name", [x;y] FROM t /* ; */;`,
+			},
+		},
+		{
+			name: "trigger body",
+			script: `
+				CREATE TRIGGER users_ai AFTER INSERT ON users BEGIN
+					INSERT INTO audit (msg) VALUES ('added; ' || new.name);
+					UPDATE stats SET total = CASE WHEN total IS NULL THEN 1 ELSE total + 1 END;
+				END;
+				CREATE TEMP TRIGGER users_ad AFTER DELETE ON users BEGIN DELETE FROM audit; END;
+				BEGIN;
+				DELETE FROM users;
+				END;
+			`,
+			expected: []string{
+				"CREATE TRIGGER users_ai AFTER INSERT ON users BEGIN\n" +
+					"\t\t\t\t\tINSERT INTO audit (msg) VALUES ('added; ' || new.name);\n" +
+					"\t\t\t\t\tUPDATE stats SET total = CASE WHEN total IS NULL THEN 1 ELSE total + 1 END;\n" +
+					"\t\t\t\tEND;",
+				"CREATE TEMP TRIGGER users_ad AFTER DELETE ON users BEGIN DELETE FROM audit; END;",
+				"BEGIN;",
+				"DELETE FROM users;",
+				"END;",
+			},
+		},
+		{
+			name:     "missing final semicolon and comment only tail",
+			script:   "SELECT 1; SELECT 2\n-- the end",
+			expected: []string{"SELECT 1;", "SELECT 2\n-- the end"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stmts, err := sqlite.SplitScript(tc.script)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, stmts)
+		})
+	}
+
+	_, err := sqlite.SplitScript(`INSERT INTO notes (body) VALUES ('unterminated);`)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
+
+func TestRunScriptDryRun(t *testing.T) {
+	ctx := context.Background()
+
+	stmts, err := sqlite.RunScriptDryRun(ctx, `CREATE TABLE dry_run_items (name TEXT); INSERT INTO dry_run_items VALUES ('a;b');`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE TABLE dry_run_items (name TEXT);",
+		"INSERT INTO dry_run_items VALUES ('a;b');",
+	}, stmts)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = sqlite.RunScriptDryRun(canceled, `SELECT 1;`)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRunScriptCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var recorded []string
+
+	db, err := sqlite.New(
+		ctx,
+		sqlite.WithMemory(),
+		sqlite.WithPoolSize(1),
+		sqlite.WithFunctions(map[string]*sqlite.FunctionImpl{
+			"record": sqlite.NewScalarFunc(1, false, func(_ sqlite.Context, args []sqlite.Value) (sqlite.Value, error) {
+				recorded = append(recorded, args[0].Text())
+				return sqlite.Value{}, nil
+			}),
+			"cancel_script": sqlite.NewScalarFunc(0, false, func(_ sqlite.Context, args []sqlite.Value) (sqlite.Value, error) {
+				cancel()
+				return sqlite.Value{}, nil
+			}),
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = sqlite.RunScript(context.Background(), db, `
+		CREATE TABLE IF NOT EXISTS script_items (name TEXT);
+		INSERT INTO script_items (name) VALUES ('a; b');
+		SELECT record('first');
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first"}, recorded)
+
+	recorded = nil
+	err = sqlite.RunScript(ctx, db, `
+		SELECT record('first');
+		SELECT cancel_script();
+		SELECT record('second');
+		INSERT INTO script_items (name) VALUES ('c');
+	`)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"first"}, recorded)
+
+	err = db.Exec(context.Background(), func(ctx context.Context, conn *sqlite.Conn) error {
+		count, err := conn.Count(ctx, "script_items", "")
+		assert.Equal(t, int64(1), count)
+		return err
+	})
+	assert.NoError(t, err)
+}
+
+func TestExecScriptReader(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	const rows = 20000
+
+	// well over one read, so statements, literals and comments get cut
+	// between two of them
+	var sb strings.Builder
+	sb.WriteString(`
+		CREATE TABLE seed_items (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE seed_log (id INTEGER);
+		CREATE TRIGGER seed_items_log AFTER INSERT ON seed_items BEGIN
+			INSERT INTO seed_log (id) VALUES (new.id);
+		END;
+	`)
+	for i := range rows {
+		fmt.Fprintf(&sb, "-- row %d; still a comment\nINSERT INTO seed_items (id, name) VALUES (%d, 'item; %d');\n", i, i, i)
+	}
+
+	err = conn.ExecScriptReader(strings.NewReader(sb.String()))
+	assert.NoError(t, err)
+
+	count, err := conn.Count(ctx, "seed_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(rows), count)
+
+	count, err = conn.Count(ctx, "seed_log", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(rows), count)
+
+	name, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("name"), nil
+	}, `SELECT name FROM seed_items WHERE id = ?;`, rows-1)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("item; %d", rows-1), name)
+
+	// one byte at a time works too
+	err = conn.ExecScriptReader(iotest.OneByteReader(strings.NewReader(`
+		/* a; b */ DELETE FROM seed_items WHERE id < 10;
+		DELETE FROM seed_items WHERE name = 'item; 10'`)))
+	assert.NoError(t, err)
+
+	count, err = conn.Count(ctx, "seed_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(rows-11), count)
+
+	// a broken script applies nothing
+	err = conn.ExecScriptReader(strings.NewReader(`
+		DELETE FROM seed_items;
+		INSERT INTO seed_items (name) VALUES ('unterminated);
+	`))
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	count, err = conn.Count(ctx, "seed_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(rows-11), count)
+}
@@ -0,0 +1,379 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// StdlibDB returns a *sql.DB backed by this database, for libraries that only
+// speak database/sql such as query builders. Connections are taken from this
+// package's pool when database/sql needs one and returned as soon as it's
+// done with them, so the pool size, pragmas and functions set on New apply.
+// Closing the returned *sql.DB doesn't close the Database.
+func (db *Database) StdlibDB() *sql.DB {
+	sqlDB := sql.OpenDB(&stdlibConnector{db: db})
+	sqlDB.SetMaxOpenConns(db.size)
+	// idle connections would stay checked out of the pool
+	sqlDB.SetMaxIdleConns(0)
+	return sqlDB
+}
+
+var errStdlibOpen = errors.New("database/sql driver can only be used through Database.StdlibDB")
+
+type stdlibDriver struct{}
+
+func (stdlibDriver) Open(name string) (driver.Conn, error) {
+	return nil, errStdlibOpen
+}
+
+type stdlibConnector struct {
+	db *Database
+}
+
+func (c *stdlibConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.db.ConnLabeled(ctx, "database/sql")
+	if err != nil {
+		return nil, err
+	}
+
+	// Take wires the interrupt to ctx, which database/sql may cancel while
+	// still using the connection, each call sets its own instead
+	conn.conn.SetInterrupt(nil)
+
+	return &stdlibConn{conn: conn}, nil
+}
+
+func (c *stdlibConnector) Driver() driver.Driver {
+	return stdlibDriver{}
+}
+
+type stdlibConn struct {
+	conn *Conn
+}
+
+var (
+	_ driver.ConnPrepareContext = (*stdlibConn)(nil)
+	_ driver.ExecerContext      = (*stdlibConn)(nil)
+	_ driver.QueryerContext     = (*stdlibConn)(nil)
+	_ driver.ConnBeginTx        = (*stdlibConn)(nil)
+	_ driver.NamedValueChecker  = (*stdlibConn)(nil)
+)
+
+func (c *stdlibConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *stdlibConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stdlibStmt{conn: c, stmt: stmt}, nil
+}
+
+// prepare compiles a single statement. Statements aren't taken from the
+// connection's cache, so two driver statements never share one.
+func (c *stdlibConn) prepare(query string) (*Stmt, error) {
+	stmt, trailing, err := c.conn.conn.PrepareTransient(strings.TrimSpace(query))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPrepareSQL, err)
+	}
+
+	if rest := query[len(query)-trailing:]; !isEmptyScript(rest) {
+		stmt.Finalize()
+		return nil, fmt.Errorf("%w: only one statement can be prepared, found %q after it", ErrPrepareSQL, strings.TrimSpace(rest))
+	}
+
+	return stmt, nil
+}
+
+// Close returns the connection to the pool, rolling back the transaction
+// left open on it, e.g. by a BEGIN run through a *sql.Conn, so the next user
+// doesn't inherit it
+func (c *stdlibConn) Close() error {
+	defer c.conn.Done()
+
+	if c.conn.conn.AutocommitEnabled() {
+		return nil
+	}
+
+	prev := c.conn.conn.SetInterrupt(nil)
+	defer c.conn.conn.SetInterrupt(prev)
+
+	return sqlitex.ExecuteTransient(c.conn.conn, `ROLLBACK;`, nil)
+}
+
+func (c *stdlibConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *stdlibConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelSerializable:
+	default:
+		return nil, fmt.Errorf("isolation level %s is not supported", sql.IsolationLevel(opts.Isolation))
+	}
+
+	// SQLite has no read-only transactions, opts.ReadOnly is only a hint
+	_, err := c.ExecContext(ctx, `BEGIN;`, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stdlibTx{conn: c}, nil
+}
+
+// CheckNamedValue accepts every value as is, binding is done by this package
+// like for Conn.Exec, e.g. time.Time follows WithTimeEncoding. A
+// driver.Valuer, e.g. sql.NullString, is replaced by its value first, as
+// database/sql only calls Value when the driver doesn't check values itself.
+func (c *stdlibConn) CheckNamedValue(nv *driver.NamedValue) error {
+	valuer, ok := nv.Value.(driver.Valuer)
+	if !ok {
+		return nil
+	}
+
+	if rv := reflect.ValueOf(valuer); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		nv.Value = nil
+		return nil
+	}
+
+	value, err := valuer.Value()
+	if err != nil {
+		return err
+	}
+	nv.Value = value
+	return nil
+}
+
+func (c *stdlibConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	// scripts without arguments may hold several statements
+	if len(args) == 0 {
+		if stmts, err := SplitScript(query); err == nil && len(stmts) > 1 {
+			for _, stmt := range stmts {
+				if _, err := c.ExecContext(ctx, stmt, nil); err != nil {
+					return nil, err
+				}
+			}
+			return c.result(), nil
+		}
+	}
+
+	stmt, err := c.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize()
+
+	return (&stdlibStmt{conn: c, stmt: stmt}).ExecContext(ctx, args)
+}
+
+func (c *stdlibConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	stmt, err := c.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := (&stdlibStmt{conn: c, stmt: stmt}).QueryContext(ctx, args)
+	if err != nil {
+		stmt.Finalize()
+		return nil, err
+	}
+
+	// the statement only lives as long as its rows
+	rows.(*stdlibRows).finalize = true
+	return rows, nil
+}
+
+func (c *stdlibConn) result() driver.Result {
+	return stdlibResult{result: c.conn.result()}
+}
+
+// interrupt stops the running statement when ctx is done, the returned func
+// removes the interrupt
+func (c *stdlibConn) interrupt(ctx context.Context) func() {
+	prev := c.conn.conn.SetInterrupt(ctx.Done())
+	return func() {
+		c.conn.conn.SetInterrupt(prev)
+	}
+}
+
+type stdlibTx struct {
+	conn *stdlibConn
+}
+
+func (tx *stdlibTx) Commit() error {
+	_, err := tx.conn.ExecContext(context.Background(), `COMMIT;`, nil)
+	return err
+}
+
+func (tx *stdlibTx) Rollback() error {
+	_, err := tx.conn.ExecContext(context.Background(), `ROLLBACK;`, nil)
+	return err
+}
+
+type stdlibResult struct {
+	result Result
+}
+
+func (r stdlibResult) LastInsertId() (int64, error) {
+	return r.result.LastInsertID, nil
+}
+
+func (r stdlibResult) RowsAffected() (int64, error) {
+	return r.result.RowsAffected, nil
+}
+
+type stdlibStmt struct {
+	conn *stdlibConn
+	stmt *Stmt
+}
+
+var (
+	_ driver.StmtExecContext  = (*stdlibStmt)(nil)
+	_ driver.StmtQueryContext = (*stdlibStmt)(nil)
+)
+
+func (s *stdlibStmt) Close() error {
+	return s.stmt.Finalize()
+}
+
+func (s *stdlibStmt) NumInput() int {
+	// named parameters can be bound more than once, let bind check them
+	for i := 1; i <= s.stmt.BindParamCount(); i++ {
+		if s.stmt.BindParamName(i) != "" {
+			return -1
+		}
+	}
+	return s.stmt.BindParamCount()
+}
+
+func (s *stdlibStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stdlibStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stdlibStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer s.conn.interrupt(ctx)()
+
+	err := s.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	defer s.stmt.Reset()
+
+	for {
+		hasRow, err := s.stmt.Step()
+		if err != nil {
+			return nil, stepError(ctx, err)
+		}
+
+		if !hasRow {
+			return s.conn.result(), nil
+		}
+	}
+}
+
+func (s *stdlibStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	done := s.conn.interrupt(ctx)
+
+	err := s.bind(args)
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	return &stdlibRows{ctx: ctx, stmt: s.stmt, done: done}, nil
+}
+
+func (s *stdlibStmt) bind(args []driver.NamedValue) error {
+	err := s.stmt.Reset()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExecSQL, err)
+	}
+
+	err = s.stmt.ClearBindings()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExecSQL, err)
+	}
+
+	for _, arg := range args {
+		value := arg.Value
+		if t, ok := value.(time.Time); ok {
+			value = Time(t, s.conn.conn.db.timeEncoding)
+		}
+
+		if arg.Name != "" {
+			err = bindNamed(s.stmt, arg.Name, value)
+		} else {
+			err = bindValue(s.stmt, arg.Ordinal, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type stdlibRows struct {
+	ctx      context.Context
+	stmt     *Stmt
+	done     func()
+	finalize bool
+}
+
+func (r *stdlibRows) Columns() []string {
+	columns := make([]string, r.stmt.ColumnCount())
+	for i := range columns {
+		columns[i] = r.stmt.ColumnName(i)
+	}
+	return columns
+}
+
+func (r *stdlibRows) Next(dest []driver.Value) error {
+	hasRow, err := r.stmt.Step()
+	if err != nil {
+		return stepError(r.ctx, err)
+	}
+
+	if !hasRow {
+		return io.EOF
+	}
+
+	for i := range dest {
+		dest[i] = columnValue(r.stmt, i)
+	}
+
+	return nil
+}
+
+func (r *stdlibRows) Close() error {
+	defer r.done()
+
+	if r.finalize {
+		return r.stmt.Finalize()
+	}
+	return r.stmt.Reset()
+}
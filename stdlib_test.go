@@ -0,0 +1,195 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdlibDB(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(2))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	sqlDB := db.StdlibDB()
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	assert.NoError(t, sqlDB.PingContext(ctx))
+
+	_, err = sqlDB.ExecContext(ctx, `
+		DROP TABLE IF EXISTS stdlib_users;
+		CREATE TABLE stdlib_users (id INTEGER PRIMARY KEY, name TEXT, score REAL, avatar BLOB, created_at INTEGER);
+	`)
+	assert.NoError(t, err)
+
+	createdAt := time.Unix(1700000000, 0).UTC()
+
+	result, err := sqlDB.ExecContext(ctx, `INSERT INTO stdlib_users (name, score, avatar, created_at) VALUES (?, ?, ?, ?);`, "john", 1.5, []byte{1, 2}, createdAt)
+	assert.NoError(t, err)
+
+	id, err := result.LastInsertId()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+
+	_, err = sqlDB.ExecContext(ctx, `INSERT INTO stdlib_users (name) VALUES ($name);`, sql.Named("name", "jane"))
+	assert.NoError(t, err)
+
+	rows, err := sqlDB.QueryContext(ctx, `SELECT id, name, score, avatar, created_at FROM stdlib_users ORDER BY id;`)
+	assert.NoError(t, err)
+
+	type user struct {
+		id        int64
+		name      string
+		score     sql.NullFloat64
+		avatar    []byte
+		createdAt sql.NullInt64
+	}
+
+	var users []user
+	for rows.Next() {
+		var u user
+		assert.NoError(t, rows.Scan(&u.id, &u.name, &u.score, &u.avatar, &u.createdAt))
+		users = append(users, u)
+	}
+	assert.NoError(t, rows.Err())
+	assert.NoError(t, rows.Close())
+
+	assert.Equal(t, []user{
+		{id: 1, name: "john", score: sql.NullFloat64{Float64: 1.5, Valid: true}, avatar: []byte{1, 2}, createdAt: sql.NullInt64{Int64: createdAt.Unix(), Valid: true}},
+		{id: 2, name: "jane"},
+	}, users)
+
+	// prepared statements can be reused
+	stmt, err := sqlDB.PrepareContext(ctx, `SELECT name FROM stdlib_users WHERE id = ?;`)
+	assert.NoError(t, err)
+	for id, expected := range map[int64]string{1: "john", 2: "jane"} {
+		var name string
+		assert.NoError(t, stmt.QueryRowContext(ctx, id).Scan(&name))
+		assert.Equal(t, expected, name)
+	}
+	assert.NoError(t, stmt.Close())
+
+	var name string
+	err = sqlDB.QueryRowContext(ctx, `SELECT name FROM stdlib_users WHERE id = ?;`, 42).Scan(&name)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// transactions
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = tx.ExecContext(ctx, `DELETE FROM stdlib_users;`)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+
+	tx, err = sqlDB.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	result, err = tx.ExecContext(ctx, `UPDATE stdlib_users SET score = ? WHERE id = ?;`, 3.0, 2)
+	assert.NoError(t, err)
+	affected, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+	assert.NoError(t, tx.Commit())
+
+	var count int
+	assert.NoError(t, sqlDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM stdlib_users WHERE score IS NOT NULL;`).Scan(&count))
+	assert.Equal(t, 2, count)
+
+	// every connection went back to the pool
+	assert.Empty(t, db.Stats().InUse)
+}
+
+func TestStdlibDBNullValues(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	sqlDB := db.StdlibDB()
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	_, err = sqlDB.ExecContext(ctx, `CREATE TABLE stdlib_nulls (name TEXT, age INTEGER, score REAL, active INTEGER);`)
+	assert.NoError(t, err)
+
+	var nilName *sql.NullString
+
+	_, err = sqlDB.ExecContext(ctx, `INSERT INTO stdlib_nulls VALUES (?, ?, ?, ?);`,
+		sql.NullString{String: "john", Valid: true},
+		sql.NullInt64{Int64: 42, Valid: true},
+		sql.NullFloat64{},
+		sql.NullBool{Bool: true, Valid: true},
+	)
+	assert.NoError(t, err)
+
+	_, err = sqlDB.ExecContext(ctx, `INSERT INTO stdlib_nulls VALUES (?, ?, ?, ?);`,
+		nilName, sql.NullInt64{}, sql.NullFloat64{Float64: 1.5, Valid: true}, sql.NullBool{})
+	assert.NoError(t, err)
+
+	var nulls int
+	assert.NoError(t, sqlDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM stdlib_nulls WHERE name IS NULL AND age IS NULL AND active IS NULL;`).Scan(&nulls))
+	assert.Equal(t, 1, nulls)
+
+	var (
+		name   string
+		age    int64
+		score  sql.NullFloat64
+		active bool
+	)
+	assert.NoError(t, sqlDB.QueryRowContext(ctx, `SELECT name, age, score, active FROM stdlib_nulls WHERE name = ?;`, sql.NullString{String: "john", Valid: true}).Scan(&name, &age, &score, &active))
+	assert.Equal(t, "john", name)
+	assert.Equal(t, int64(42), age)
+	assert.False(t, score.Valid)
+	assert.True(t, active)
+}
+
+func TestStdlibDBCloseRollsBack(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	sqlDB := db.StdlibDB()
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	_, err = sqlDB.ExecContext(ctx, `CREATE TABLE stdlib_items (name TEXT);`)
+	assert.NoError(t, err)
+
+	// a transaction started by hand and never finished
+	sqlConn, err := sqlDB.Conn(ctx)
+	assert.NoError(t, err)
+	_, err = sqlConn.ExecContext(ctx, `BEGIN;`)
+	assert.NoError(t, err)
+	_, err = sqlConn.ExecContext(ctx, `INSERT INTO stdlib_items (name) VALUES ('lost');`)
+	assert.NoError(t, err)
+	assert.NoError(t, sqlConn.Close())
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	// the pooled connection isn't stuck in the transaction
+	assert.NoError(t, conn.Exec(ctx, `BEGIN;`))
+	assert.NoError(t, conn.Exec(ctx, `COMMIT;`))
+
+	count, err := conn.Count(ctx, "stdlib_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
@@ -0,0 +1,560 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxVariableNumber is the default SQLITE_MAX_VARIABLE_NUMBER. Statements
+// generated by this package never bind more parameters than this.
+const maxVariableNumber = 999
+
+var (
+	ErrNotStruct = errors.New("database expected a struct value")
+)
+
+// structField maps one exported struct field to a column. The column name
+// comes from the `db` tag and falls back to the field name. A field tagged
+// with `db:"-"` is skipped.
+type structField struct {
+	column    string
+	index     []int
+	omitEmpty bool
+}
+
+func structFields(typ reflect.Type) ([]structField, error) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: got %s", ErrNotStruct, typ)
+	}
+
+	fields := appendStructFields(nil, typ, nil, map[reflect.Type]bool{typ: true})
+
+	return resolveStructFields(typ, fields)
+}
+
+// appendStructFields collects the columns of typ. Embedded structs without
+// a `db` tag name are flattened, so a base model shared by several types maps
+// its columns at the top level. onPath holds the struct types being
+// flattened, so a type embedding a pointer to itself stops there.
+func appendStructFields(fields []structField, typ reflect.Type, parent []int, onPath map[reflect.Type]bool) []structField {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		index := append(slices.Clone(parent), field.Index...)
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				if !onPath[embedded] {
+					onPath[embedded] = true
+					fields = appendStructFields(fields, embedded, index, onPath)
+					delete(onPath, embedded)
+				}
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fields = append(fields, structField{
+			column:    name,
+			index:     index,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+
+	return fields
+}
+
+// resolveStructFields applies Go's rule for embedded fields to columns: when
+// several fields map to the same column, the least nested one wins, e.g. an
+// ID field of the struct hides the ID of an embedded base model. Fields
+// tied at the same depth are ambiguous and rejected.
+func resolveStructFields(typ reflect.Type, fields []structField) ([]structField, error) {
+	shallowest := make(map[string]int, len(fields))
+	count := make(map[string]int, len(fields))
+	for _, field := range fields {
+		depth, found := shallowest[field.column]
+		switch {
+		case !found || len(field.index) < depth:
+			shallowest[field.column] = len(field.index)
+			count[field.column] = 1
+		case len(field.index) == depth:
+			count[field.column]++
+		}
+	}
+
+	resolved := fields[:0]
+	for _, field := range fields {
+		if len(field.index) != shallowest[field.column] {
+			continue
+		}
+
+		if count[field.column] > 1 {
+			return nil, fmt.Errorf("%w: column %q is ambiguous in %s", ErrNotStruct, field.column, typ)
+		}
+
+		resolved = append(resolved, field)
+	}
+
+	return resolved, nil
+}
+
+// checkStructNames reports an error unless table and the column of every
+// field can be put into sql as is, see isIdentifier. ScanStruct doesn't need
+// it, the columns it reads can be any expression's name.
+func checkStructNames(table string, fields []structField) error {
+	if !isIdentifier(table) {
+		return fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	for _, field := range fields {
+		if !isIdentifierPart(field.column) {
+			return fmt.Errorf("%w: invalid column name %q", ErrPrepareSQL, field.column)
+		}
+	}
+
+	return nil
+}
+
+func structColumns(fields []structField) []string {
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, field.column)
+	}
+	return columns
+}
+
+// Insert inserts v, a struct or a pointer to a struct, into table. Every
+// exported field becomes a column named by its `db` tag (or the field name).
+// Fields tagged `db:"-"` are skipped and fields tagged `db:"name,omitempty"`
+// are left out when they hold their zero value, so the column default applies.
+// table and the columns must be plain identifiers.
+func (c *Conn) Insert(ctx context.Context, table string, v any) (Result, error) {
+	value, err := structValue(v)
+	if err != nil {
+		return Result{}, err
+	}
+
+	fields, err := structFields(value.Type())
+	if err != nil {
+		return Result{}, err
+	}
+
+	err = checkStructNames(table, fields)
+	if err != nil {
+		return Result{}, err
+	}
+
+	columns := make([]string, 0, len(fields))
+	args := make([]any, 0, len(fields))
+	for _, field := range fields {
+		fieldValue := fieldValue(value, field)
+		if field.omitEmpty && (!fieldValue.IsValid() || fieldValue.IsZero()) {
+			continue
+		}
+		columns = append(columns, field.column)
+		args = append(args, fieldInterface(value, field))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	if len(columns) == 0 {
+		sb.WriteString(" DEFAULT VALUES;")
+	} else {
+		sb.WriteString(" (")
+		sb.WriteString(strings.Join(columns, ", "))
+		sb.WriteString(") VALUES (")
+		placeholders(len(columns), &sb)
+		sb.WriteString(");")
+	}
+
+	err = c.Exec(ctx, sb.String(), args...)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return c.result(), nil
+}
+
+// Update sets the columns of the rows of table matching where to the fields
+// of v, a struct or a pointer to a struct, mapped like Insert does: fields
+// tagged `db:"-"` are skipped and fields tagged `db:"name,omitempty"` are
+// left untouched when they hold their zero value, which makes partial
+// updates easy. The field values are bound first, then args for where.
+//
+//	result, err := conn.Update(ctx, "users", user, "id = ?", user.ID)
+//
+// where is required, pass "1=1" to update every row. table and the columns
+// must be plain identifiers.
+func (c *Conn) Update(ctx context.Context, table string, v any, where string, args ...any) (Result, error) {
+	if !isIdentifier(table) {
+		return Result{}, fmt.Errorf("%w: invalid table name %q", ErrPrepareSQL, table)
+	}
+
+	if strings.TrimSpace(where) == "" {
+		return Result{}, fmt.Errorf("%w: update needs a where clause, use 1=1 to update every row", ErrPrepareSQL)
+	}
+
+	value, err := structValue(v)
+	if err != nil {
+		return Result{}, err
+	}
+
+	fields, err := structFields(value.Type())
+	if err != nil {
+		return Result{}, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("UPDATE ")
+	sb.WriteString(table)
+	sb.WriteString(" SET ")
+
+	values := make([]any, 0, len(fields)+len(args))
+	for _, field := range fields {
+		fieldValue := fieldValue(value, field)
+		if field.omitEmpty && (!fieldValue.IsValid() || fieldValue.IsZero()) {
+			continue
+		}
+
+		if !isIdentifierPart(field.column) {
+			return Result{}, fmt.Errorf("%w: invalid column name %q", ErrPrepareSQL, field.column)
+		}
+
+		if len(values) > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(field.column)
+		sb.WriteString(" = ?")
+		values = append(values, fieldInterface(value, field))
+	}
+
+	if len(values) == 0 {
+		return Result{}, fmt.Errorf("%w: update has no columns to set", ErrPrepareSQL)
+	}
+
+	sb.WriteString(" WHERE ")
+	sb.WriteString(where)
+	sb.WriteString(";")
+
+	err = c.Exec(ctx, sb.String(), append(values, args...)...)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return c.result(), nil
+}
+
+// structValue returns the struct v holds or points to, or ErrNotStruct if v
+// is nil, a nil pointer or not a struct
+func structValue(v any) (reflect.Value, error) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return reflect.Value{}, fmt.Errorf("%w: got nil %s", ErrNotStruct, value.Type())
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("%w: got %T", ErrNotStruct, v)
+	}
+
+	return value, nil
+}
+
+func structValues(value reflect.Value, fields []structField) []any {
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+
+	values := make([]any, 0, len(fields))
+	for _, field := range fields {
+		values = append(values, fieldInterface(value, field))
+	}
+	return values
+}
+
+// fieldValue returns the value of field in v. The returned value is invalid
+// if the field lives in a nil embedded pointer.
+func fieldValue(v reflect.Value, field structField) reflect.Value {
+	fieldValue, err := v.FieldByIndexErr(field.index)
+	if err != nil {
+		return reflect.Value{}
+	}
+	return fieldValue
+}
+
+func fieldInterface(v reflect.Value, field structField) any {
+	value := fieldValue(v, field)
+	if !value.IsValid() {
+		return nil
+	}
+	return value.Interface()
+}
+
+// BulkInsertStructs inserts all items into table. The columns are derived once
+// from T's exported fields (see the `db` tag) and the rows are inserted in
+// chunks that stay under SQLite's bound variable limit. All chunks run inside
+// a single savepoint, so either every item is inserted or none are. table and
+// the columns must be plain identifiers.
+func BulkInsertStructs[T any](ctx context.Context, conn *Conn, table string, items []T) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	fields, err := structFields(reflect.TypeFor[T]())
+	if err != nil {
+		return 0, err
+	}
+
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("%w: %s has no columns", ErrNotStruct, reflect.TypeFor[T]())
+	}
+
+	err = checkStructNames(table, fields)
+	if err != nil {
+		return 0, err
+	}
+
+	rows := make([][]any, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, structValues(reflect.ValueOf(item), fields))
+	}
+
+	sql := "INSERT INTO " + table + " (" + strings.Join(structColumns(fields), ", ") + ") VALUES"
+
+	result, err := conn.BatchInsert(ctx, sql, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(result.RowsAffected), nil
+}
+
+// BuildWhere builds a WHERE condition from filter, a struct or a pointer to
+// a struct, for search queries with optional criteria:
+//
+//	type UserFilter struct {
+//		Name   string `db:"name"`
+//		Age    *int   `db:"age"`
+//		Active *bool  `db:"active"`
+//	}
+//
+//	where, args, err := sqlite.BuildWhere(UserFilter{Name: "john"})
+//	if err != nil {
+//		return err
+//	}
+//	conn.Query(ctx, "SELECT * FROM users WHERE "+where, args...)
+//
+// Every exported field that holds a non-zero value becomes `column = ?`,
+// joined by AND, with pointers dereferenced so a pointer to a zero value
+// still filters, e.g. Active set to false. Zero and nil fields are skipped
+// and if nothing is set, or filter is nil, the condition is "1=1" with no
+// args, so it can always be appended. Columns follow the `db` tag like Insert
+// and must be plain identifiers. It fails with ErrNotStruct if filter isn't
+// a struct.
+func BuildWhere(filter any) (clause string, args []any, err error) {
+	if filter == nil {
+		return "1=1", nil, nil
+	}
+
+	value := reflect.ValueOf(filter)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return "1=1", nil, nil
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return "", nil, fmt.Errorf("%w: got %T", ErrNotStruct, filter)
+	}
+
+	fields, err := structFields(value.Type())
+	if err != nil {
+		return "", nil, err
+	}
+
+	conds := make([]string, 0, len(fields))
+	for _, field := range fields {
+		fieldValue := fieldValue(value, field)
+		if !fieldValue.IsValid() || fieldValue.IsZero() {
+			continue
+		}
+
+		if !isIdentifierPart(field.column) {
+			return "", nil, fmt.Errorf("%w: invalid column name %q", ErrPrepareSQL, field.column)
+		}
+
+		if fieldValue.Kind() == reflect.Pointer {
+			fieldValue = fieldValue.Elem()
+		}
+
+		conds = append(conds, field.column+" = ?")
+		args = append(args, fieldValue.Interface())
+	}
+
+	if len(conds) == 0 {
+		return "1=1", nil, nil
+	}
+
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// ScanStruct reads the current row of stmt into dst, a pointer to a struct,
+// matching columns to fields like Insert does, by `db` tag or field name.
+// Columns without a field and fields without a column are ignored, NULL sets
+// the zero value. Besides the types bindValue handles:
+//
+//   - a field implementing sql.Scanner scans the column's value
+//   - time.Time reads Unix seconds, or RFC 3339 text, see ScanStructAs for
+//     the other encodings
+//   - pointers are allocated as needed and set to nil for NULL
+//   - structs, maps and slices (other than []byte) are decoded from JSON, so
+//     a JSON column maps straight to a nested struct
+//
+// zombiezen.com/go/sqlite doesn't expose the declared type of a column, so
+// JSON columns are recognized by the type of their field rather than by a
+// JSON declaration.
+func ScanStruct(stmt *Stmt, dst any) error {
+	return ScanStructAs(stmt, dst, TimeSeconds)
+}
+
+// ScanStructAs is ScanStruct for time.Time fields stored with the given
+// encoding, e.g. the one set by WithTimeEncoding which Insert and Update
+// bind them with. RFC 3339 text is read whatever enc is.
+func ScanStructAs(stmt *Stmt, dst any, enc TimeEncoding) error {
+	value := reflect.ValueOf(dst)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return fmt.Errorf("%w: ScanStruct needs a non nil pointer, got %T", ErrNotStruct, dst)
+	}
+
+	fields, err := structFields(value.Type())
+	if err != nil {
+		return err
+	}
+
+	value = value.Elem()
+
+	for _, field := range fields {
+		idx := stmt.ColumnIndex(field.column)
+		if idx < 0 {
+			continue
+		}
+
+		err := scanColumn(stmt, idx, settableField(value, field.index), enc)
+		if err != nil {
+			return fmt.Errorf("%w: column %s: %w", ErrScan, field.column, err)
+		}
+	}
+
+	return nil
+}
+
+// settableField returns the field at index of v, allocating the nil embedded
+// pointers on the way
+func settableField(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+var (
+	scannerType  = reflect.TypeFor[sql.Scanner]()
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+)
+
+// scanColumn sets dst to column idx of the current row of stmt, a time.Time
+// stored with enc
+func scanColumn(stmt *Stmt, idx int, dst reflect.Value, enc TimeEncoding) error {
+	if dst.Addr().Type().Implements(scannerType) {
+		return dst.Addr().Interface().(sql.Scanner).Scan(columnValue(stmt, idx))
+	}
+
+	if stmt.ColumnIsNull(idx) {
+		dst.SetZero()
+		return nil
+	}
+
+	switch dst.Type() {
+	case timeType:
+		t, err := enc.loadColumn(stmt, idx)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		dst.SetInt(stmt.ColumnInt64(idx))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(dst.Type().Elem())
+		if err := scanColumn(stmt, idx, elem.Elem(), enc); err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.String:
+		dst.SetString(stmt.ColumnText(idx))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		dst.SetInt(stmt.ColumnInt64(idx))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		dst.SetUint(uint64(stmt.ColumnInt64(idx)))
+	case reflect.Float32, reflect.Float64:
+		dst.SetFloat(stmt.ColumnFloat(idx))
+	case reflect.Bool:
+		dst.SetBool(stmt.ColumnInt64(idx) == 1)
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct, reflect.Interface:
+		if dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, stmt.ColumnLen(idx))
+			stmt.ColumnBytes(idx, buf)
+			dst.SetBytes(buf)
+			return nil
+		}
+		return json.Unmarshal([]byte(stmt.ColumnText(idx)), dst.Addr().Interface())
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownType, dst.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,625 @@
+package sqlite_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkInsertStructs(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS bulk_users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);`)
+	assert.NoError(t, err)
+
+	type user struct {
+		ID      int64  `db:"id"`
+		Name    string `db:"name"`
+		Age     int    `db:"age"`
+		Ignored string `db:"-"`
+	}
+
+	users := make([]user, 0, 2000)
+	for i := range 2000 {
+		users = append(users, user{
+			ID:      int64(i + 1),
+			Name:    fmt.Sprintf("user-%d", i+1),
+			Age:     i % 100,
+			Ignored: "ignored",
+		})
+	}
+
+	n, err := sqlite.BulkInsertStructs(ctx, conn, "bulk_users", users)
+	assert.NoError(t, err)
+	assert.Equal(t, 2000, n)
+
+	stmt, err := conn.Prepare(ctx, `SELECT COUNT(*) AS count FROM bulk_users;`)
+	assert.NoError(t, err)
+	_, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), stmt.GetInt64("count"))
+	stmt.Reset()
+
+	stmt, err = conn.Prepare(ctx, `SELECT name, age FROM bulk_users WHERE id = ?;`, 1234)
+	assert.NoError(t, err)
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, "user-1234", stmt.GetText("name"))
+	assert.Equal(t, int64(1233%100), stmt.GetInt64("age"))
+	stmt.Reset()
+
+	_, err = sqlite.BulkInsertStructs(ctx, conn, "bulk_users (id) VALUES (1); --", users)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	type badColumn struct {
+		Name string `db:"name) VALUES (1); --"`
+	}
+	_, err = sqlite.BulkInsertStructs(ctx, conn, "bulk_users", []badColumn{{Name: "x"}})
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
+
+func TestBulkInsertStructsRollback(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS bulk_items (id INTEGER PRIMARY KEY);`)
+	assert.NoError(t, err)
+
+	type item struct {
+		ID int64 `db:"id"`
+	}
+
+	// the duplicate lands in the second chunk, so the first chunk has to be rolled back
+	items := make([]item, 0, 1500)
+	for i := range 1500 {
+		items = append(items, item{ID: int64(i + 1)})
+	}
+	items[1499].ID = 1
+
+	_, err = sqlite.BulkInsertStructs(ctx, conn, "bulk_items", items)
+	assert.Error(t, err)
+
+	stmt, err := conn.Prepare(ctx, `SELECT COUNT(*) AS count FROM bulk_items;`)
+	assert.NoError(t, err)
+	_, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stmt.GetInt64("count"))
+	stmt.Reset()
+}
+
+func TestInsert(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE IF NOT EXISTS insert_users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'member',
+			tags TEXT
+		);
+	`)
+	assert.NoError(t, err)
+
+	type user struct {
+		ID    int64    `db:"id,omitempty"`
+		Name  string   `db:"name"`
+		Role  string   `db:"role,omitempty"`
+		Tags  []string `db:"tags"`
+		Cache string   `db:"-"`
+	}
+
+	result, err := conn.Insert(ctx, "insert_users", user{Name: "john", Tags: []string{"a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.LastInsertID)
+	assert.Equal(t, int64(1), result.RowsAffected)
+
+	result, err = conn.Insert(ctx, "insert_users", &user{Name: "jane", Role: "admin"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.LastInsertID)
+
+	stmt, err := conn.Prepare(ctx, `SELECT name, role, tags FROM insert_users ORDER BY id;`)
+	assert.NoError(t, err)
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, "john", stmt.GetText("name"))
+	assert.Equal(t, "member", stmt.GetText("role"))
+	tags, err := sqlite.LoadJsonArray[string](stmt, "tags")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, tags)
+
+	hasRow, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, "jane", stmt.GetText("name"))
+	assert.Equal(t, "admin", stmt.GetText("role"))
+	stmt.Reset()
+
+	_, err = conn.Insert(ctx, "insert_users", "not a struct")
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+
+	var missing *user
+	_, err = conn.Insert(ctx, "insert_users", missing)
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+
+	_, err = conn.Insert(ctx, "insert_users", nil)
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+
+	_, err = conn.Insert(ctx, "insert_users; DROP TABLE insert_users --", user{Name: "bob"})
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	_, err = conn.Insert(ctx, "insert_users", struct {
+		Name string `db:"name) VALUES ('x'); --"`
+	}{Name: "bob"})
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE update_users (id INTEGER PRIMARY KEY, name TEXT, role TEXT, age INTEGER);
+		INSERT INTO update_users VALUES (1, 'john', 'member', 30), (2, 'jane', 'member', 40);
+	`)
+	assert.NoError(t, err)
+
+	type user struct {
+		Name  string `db:"name"`
+		Role  string `db:"role"`
+		Age   int    `db:"age"`
+		Cache string `db:"-"`
+	}
+
+	type patch struct {
+		Name string `db:"name,omitempty"`
+		Role string `db:"role,omitempty"`
+		Age  int    `db:"age,omitempty"`
+	}
+
+	row := func(id int64) user {
+		u, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (user, error) {
+			return user{
+				Name: stmt.GetText("name"),
+				Role: stmt.GetText("role"),
+				Age:  int(stmt.GetInt64("age")),
+			}, nil
+		}, `SELECT name, role, age FROM update_users WHERE id = ?;`, id)
+		assert.NoError(t, err)
+		return u
+	}
+
+	result, err := conn.Update(ctx, "update_users", user{Name: "johnny", Role: "admin", Cache: "x"}, "id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowsAffected)
+	assert.Equal(t, user{Name: "johnny", Role: "admin", Age: 0}, row(1))
+	assert.Equal(t, user{Name: "jane", Role: "member", Age: 40}, row(2))
+
+	result, err = conn.Update(ctx, "update_users", &patch{Age: 41}, "id = ?", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowsAffected)
+	assert.Equal(t, user{Name: "jane", Role: "member", Age: 41}, row(2))
+
+	result, err = conn.Update(ctx, "update_users", patch{Role: "guest"}, "1=1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Equal(t, "guest", row(1).Role)
+	assert.Equal(t, "guest", row(2).Role)
+
+	_, err = conn.Update(ctx, "update_users", patch{}, "id = ?", 1)
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	_, err = conn.Update(ctx, "update_users", patch{Age: 1}, "")
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	_, err = conn.Update(ctx, "update_users; --", patch{Age: 1}, "1=1")
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	_, err = conn.Update(ctx, "update_users", struct {
+		Age int `db:"age = 1, name"`
+	}{1}, "1=1")
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+
+	_, err = conn.Update(ctx, "update_users", 42, "1=1")
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+
+	var missing *patch
+	_, err = conn.Update(ctx, "update_users", missing, "1=1")
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+
+	_, err = conn.Update(ctx, "update_users", nil, "1=1")
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+}
+
+func TestStructEmbeddedFields(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS embedded_users (id INTEGER PRIMARY KEY, created_at INTEGER, updated_by TEXT, name TEXT);`)
+	assert.NoError(t, err)
+
+	type Model struct {
+		ID        int64     `db:"id"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+
+	type Audit struct {
+		UpdatedBy string `db:"updated_by"`
+	}
+
+	type user struct {
+		Model
+		*Audit
+		Name string `db:"name"`
+	}
+
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	_, err = conn.Insert(ctx, "embedded_users", user{
+		Model: Model{ID: 1, CreatedAt: createdAt},
+		Audit: &Audit{UpdatedBy: "admin"},
+		Name:  "john",
+	})
+	assert.NoError(t, err)
+
+	// a nil embedded pointer binds its columns as NULL
+	n, err := sqlite.BulkInsertStructs(ctx, conn, "embedded_users", []user{
+		{Model: Model{ID: 2, CreatedAt: createdAt}, Name: "jane"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	stmt, err := conn.Prepare(ctx, `SELECT id, created_at, updated_by, name FROM embedded_users ORDER BY id;`)
+	assert.NoError(t, err)
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, int64(1), stmt.GetInt64("id"))
+	assert.Equal(t, createdAt, sqlite.LoadTime(stmt, "created_at"))
+	assert.Equal(t, "admin", stmt.GetText("updated_by"))
+	assert.Equal(t, "john", stmt.GetText("name"))
+
+	hasRow, err = stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, int64(2), stmt.GetInt64("id"))
+	assert.True(t, stmt.IsNull("updated_by"))
+	assert.Equal(t, "jane", stmt.GetText("name"))
+	stmt.Reset()
+}
+
+func TestStructEmbeddedFieldConflicts(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE conflict_items (id INTEGER PRIMARY KEY, name TEXT);`)
+	assert.NoError(t, err)
+
+	type Base struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+
+	type Other struct {
+		Name string `db:"name"`
+	}
+
+	// the least nested field wins
+	type shadowed struct {
+		Base
+		Name string `db:"name"`
+	}
+
+	_, err = conn.Insert(ctx, "conflict_items", shadowed{Base: Base{ID: 1, Name: "hidden"}, Name: "outer"})
+	assert.NoError(t, err)
+
+	name, err := sqlite.QueryRow(ctx, conn, func(stmt *sqlite.Stmt) (string, error) {
+		return stmt.GetText("name"), nil
+	}, `SELECT name FROM conflict_items WHERE id = 1;`)
+	assert.NoError(t, err)
+	assert.Equal(t, "outer", name)
+
+	// two fields at the same depth can't be told apart
+	type ambiguous struct {
+		Base
+		Other
+	}
+
+	_, err = conn.Insert(ctx, "conflict_items", ambiguous{Base: Base{ID: 2}})
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+
+	// a type embedding a pointer to itself is only flattened once
+	type node struct {
+		*node
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+
+	_, err = conn.Insert(ctx, "conflict_items", node{ID: 3, Name: "node"})
+	assert.NoError(t, err)
+
+	count, err := conn.Count(ctx, "conflict_items", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestBuildWhere(t *testing.T) {
+	type filter struct {
+		Name   string `db:"name"`
+		Age    *int   `db:"age"`
+		Active *bool  `db:"active"`
+		Ignore string `db:"-"`
+		secret string
+	}
+
+	age := 30
+	inactive := false
+
+	testCases := []struct {
+		name   string
+		filter any
+		clause string
+		args   []any
+	}{
+		{"empty", filter{}, "1=1", nil},
+		{"nil", nil, "1=1", nil},
+		{"nil pointer", (*filter)(nil), "1=1", nil},
+		{"skipped fields", filter{Ignore: "x", secret: "y"}, "1=1", nil},
+		{"one field", filter{Name: "john"}, "name = ?", []any{"john"}},
+		{"pointer to zero", filter{Active: &inactive}, "active = ?", []any{false}},
+		{"partial", &filter{Name: "john", Age: &age}, "name = ? AND age = ?", []any{"john", 30}},
+		{"all", filter{Name: "john", Age: &age, Active: &inactive}, "name = ? AND age = ? AND active = ?", []any{"john", 30, false}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clause, args, err := sqlite.BuildWhere(tc.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.clause, clause)
+			assert.Equal(t, tc.args, args)
+		})
+	}
+
+	_, _, err := sqlite.BuildWhere("name")
+	assert.ErrorIs(t, err, sqlite.ErrNotStruct)
+
+	_, _, err = sqlite.BuildWhere(struct {
+		Name string `db:"name = name OR 1"`
+	}{Name: "john"})
+	assert.ErrorIs(t, err, sqlite.ErrPrepareSQL)
+}
+
+func TestBuildWhereQuery(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE where_users (name TEXT, age INTEGER, active INTEGER);
+		INSERT INTO where_users (name, age, active) VALUES ('john', 30, 1), ('jane', 30, 0), ('bob', 40, 0);
+	`)
+	assert.NoError(t, err)
+
+	type filter struct {
+		Age    int   `db:"age"`
+		Active *bool `db:"active"`
+	}
+
+	names := func(f filter) []string {
+		where, args, err := sqlite.BuildWhere(f)
+		assert.NoError(t, err)
+
+		var names []string
+		for stmt, err := range conn.Query(ctx, `SELECT name FROM where_users WHERE `+where+` ORDER BY name;`, args...) {
+			assert.NoError(t, err)
+			names = append(names, stmt.GetText("name"))
+		}
+		return names
+	}
+
+	inactive := false
+
+	assert.Equal(t, []string{"bob", "jane", "john"}, names(filter{}))
+	assert.Equal(t, []string{"jane", "john"}, names(filter{Age: 30}))
+	assert.Equal(t, []string{"bob", "jane"}, names(filter{Active: &inactive}))
+	assert.Equal(t, []string{"jane"}, names(filter{Age: 30, Active: &inactive}))
+}
+
+func TestScanStruct(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`
+		CREATE TABLE scan_users (
+			id INTEGER PRIMARY KEY,
+			name TEXT,
+			nickname TEXT,
+			created_at INTEGER,
+			address JSON,
+			tags JSON,
+			meta JSON
+		);
+		INSERT INTO scan_users VALUES
+			(1, 'john', 'jj', 1700000000, '{"street":"Main St","city":"Springfield"}', '["a","b"]', '{"k":"v"}'),
+			(2, 'jane', NULL, 1700000000, NULL, NULL, NULL);
+	`)
+	assert.NoError(t, err)
+
+	type Address struct {
+		Street string `json:"street"`
+		City   string `json:"city"`
+	}
+
+	type Base struct {
+		ID int64 `db:"id"`
+	}
+
+	type User struct {
+		*Base
+		Name      string            `db:"name"`
+		Nickname  *string           `db:"nickname"`
+		CreatedAt time.Time         `db:"created_at"`
+		Address   Address           `db:"address"`
+		Tags      []string          `db:"tags"`
+		Meta      map[string]string `db:"meta"`
+		Missing   string            `db:"missing"`
+	}
+
+	var users []User
+	for stmt, err := range conn.Query(ctx, `SELECT * FROM scan_users ORDER BY id;`) {
+		assert.NoError(t, err)
+
+		var u User
+		assert.NoError(t, sqlite.ScanStruct(stmt, &u))
+		users = append(users, u)
+	}
+
+	nickname := "jj"
+	assert.Equal(t, []User{
+		{
+			Base:      &Base{ID: 1},
+			Name:      "john",
+			Nickname:  &nickname,
+			CreatedAt: time.Unix(1700000000, 0).UTC(),
+			Address:   Address{Street: "Main St", City: "Springfield"},
+			Tags:      []string{"a", "b"},
+			Meta:      map[string]string{"k": "v"},
+		},
+		{
+			Base:      &Base{ID: 2},
+			Name:      "jane",
+			CreatedAt: time.Unix(1700000000, 0).UTC(),
+		},
+	}, users)
+
+	err = conn.Exec(ctx, `INSERT INTO scan_users (id, address) VALUES (3, 'not json');`)
+	assert.NoError(t, err)
+
+	for stmt, err := range conn.Query(ctx, `SELECT address FROM scan_users WHERE id = 3;`) {
+		assert.NoError(t, err)
+
+		var u User
+		assert.ErrorIs(t, sqlite.ScanStruct(stmt, &u), sqlite.ErrScan)
+	}
+
+	assert.ErrorIs(t, sqlite.ScanStruct(nil, User{}), sqlite.ErrNotStruct)
+}
+
+func TestScanStructTimeEncoding(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithTimeEncoding(sqlite.TimeMillis))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE scan_events (id INTEGER PRIMARY KEY, at INTEGER);`)
+	assert.NoError(t, err)
+
+	type Event struct {
+		ID int64     `db:"id"`
+		At time.Time `db:"at"`
+	}
+
+	want := Event{ID: 1, At: time.Date(2024, 3, 14, 15, 9, 26, 535000000, time.UTC)}
+
+	_, err = conn.Insert(ctx, "scan_events", want)
+	assert.NoError(t, err)
+
+	for stmt, err := range conn.Query(ctx, `SELECT * FROM scan_events;`) {
+		assert.NoError(t, err)
+
+		var got Event
+		assert.NoError(t, sqlite.ScanStructAs(stmt, &got, sqlite.TimeMillis))
+		assert.Equal(t, want, got)
+
+		// read as seconds, the milliseconds are way off
+		assert.NoError(t, sqlite.ScanStruct(stmt, &got))
+		assert.NotEqual(t, want.At, got.At)
+	}
+}
@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeEncoding defines how a time.Time is stored in the database.
+// The default, TimeSeconds, stores whole Unix seconds.
+type TimeEncoding int
+
+const (
+	TimeSeconds TimeEncoding = iota // Unix seconds as INTEGER
+	TimeMillis                      // Unix milliseconds as INTEGER
+	TimeNanos                       // Unix nanoseconds as INTEGER
+	TimeRFC3339                     // RFC3339 with nanoseconds as TEXT, readable by SQLite date functions
+)
+
+func (enc TimeEncoding) String() string {
+	switch enc {
+	case TimeSeconds:
+		return "seconds"
+	case TimeMillis:
+		return "millis"
+	case TimeNanos:
+		return "nanos"
+	case TimeRFC3339:
+		return "rfc3339"
+	default:
+		return fmt.Sprintf("TimeEncoding(%d)", int(enc))
+	}
+}
+
+// encode returns the value that is bound for t, either an int64 or a string
+func (enc TimeEncoding) encode(t time.Time) any {
+	t = t.UTC()
+
+	switch enc {
+	case TimeMillis:
+		return t.UnixMilli()
+	case TimeNanos:
+		return t.UnixNano()
+	case TimeRFC3339:
+		return t.Format(time.RFC3339Nano)
+	default:
+		return t.Unix()
+	}
+}
+
+func (enc TimeEncoding) bind(stmt *Stmt, i int, t time.Time) {
+	switch v := enc.encode(t).(type) {
+	case string:
+		stmt.BindText(i, v)
+	case int64:
+		stmt.BindInt64(i, v)
+	}
+}
+
+func (enc TimeEncoding) load(stmt *Stmt, key string) (time.Time, error) {
+	switch enc {
+	case TimeMillis:
+		return time.UnixMilli(stmt.GetInt64(key)).UTC(), nil
+	case TimeNanos:
+		return time.Unix(0, stmt.GetInt64(key)).UTC(), nil
+	case TimeRFC3339:
+		value := stmt.GetText(key)
+		if value == "" {
+			return time.Time{}, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.UTC(), nil
+	default:
+		return time.Unix(stmt.GetInt64(key), 0).UTC(), nil
+	}
+}
+
+// loadColumn reads column idx like load does, except that a TEXT value is
+// read as RFC 3339 whatever enc is, see ScanStructAs
+func (enc TimeEncoding) loadColumn(stmt *Stmt, idx int) (time.Time, error) {
+	if stmt.ColumnType(idx) == TypeText {
+		t, err := time.Parse(time.RFC3339Nano, stmt.ColumnText(idx))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.UTC(), nil
+	}
+
+	value := stmt.ColumnInt64(idx)
+	switch enc {
+	case TimeMillis:
+		return time.UnixMilli(value).UTC(), nil
+	case TimeNanos:
+		return time.Unix(0, value).UTC(), nil
+	default:
+		return time.Unix(value, 0).UTC(), nil
+	}
+}
+
+// TimeValue binds a time.Time with a specific encoding, regardless of
+// the database's default. Create it with Time.
+type TimeValue struct {
+	Time     time.Time
+	Encoding TimeEncoding
+}
+
+// Time wraps t so it's bound using enc, for example
+//
+//	conn.Prepare(ctx, `INSERT INTO events (at) VALUES (?);`, sqlite.Time(at, sqlite.TimeNanos))
+func Time(t time.Time, enc TimeEncoding) TimeValue {
+	return TimeValue{Time: t, Encoding: enc}
+}
+
+// TimeRangeCond returns the condition `col >= ? AND col < ?` and its args for
+// the half-open window [from, to) of a column holding times stored with the
+// default TimeSeconds encoding, the way a time.Time is bound:
+//
+//	where, args := sqlite.TimeRangeCond("created_at", from, to)
+//	conn.Query(ctx, `SELECT * FROM events WHERE `+where, args...)
+//
+// The args are from.Unix() and to.Unix(), so sub-second parts are dropped.
+func TimeRangeCond(col string, from, to time.Time) (clause string, args []any) {
+	return col + " >= ? AND " + col + " < ?", []any{from.Unix(), to.Unix()}
+}
@@ -0,0 +1,132 @@
+package sqlite_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeEncoding(t *testing.T) {
+	at := time.Date(2024, 3, 14, 15, 9, 26, 535897932, time.UTC)
+
+	testCases := []struct {
+		enc  sqlite.TimeEncoding
+		want time.Time
+	}{
+		{sqlite.TimeSeconds, at.Truncate(time.Second)},
+		{sqlite.TimeMillis, at.Truncate(time.Millisecond)},
+		{sqlite.TimeNanos, at},
+		{sqlite.TimeRFC3339, at},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.enc.String(), func(t *testing.T) {
+			ctx := context.Background()
+
+			db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithTimeEncoding(tc.enc))
+			assert.NoError(t, err)
+			t.Cleanup(func() {
+				db.Close()
+			})
+
+			conn, err := db.Conn(ctx)
+			assert.NoError(t, err)
+			defer conn.Done()
+
+			err = conn.ExecScript(`CREATE TABLE IF NOT EXISTS time_encodings (at ANY, wrapped ANY);`)
+			assert.NoError(t, err)
+
+			err = conn.Exec(ctx, `INSERT INTO time_encodings (at, wrapped) VALUES (?, ?);`, at, sqlite.Time(at, tc.enc))
+			assert.NoError(t, err)
+
+			stmt, err := conn.Prepare(ctx, `SELECT at, wrapped FROM time_encodings;`)
+			assert.NoError(t, err)
+			defer stmt.Reset()
+
+			hasRow, err := stmt.Step()
+			assert.NoError(t, err)
+			assert.True(t, hasRow)
+
+			got, err := sqlite.LoadTimeAs(stmt, "at", tc.enc)
+			assert.NoError(t, err)
+			assert.True(t, tc.want.Equal(got), "want %s, got %s", tc.want, got)
+
+			got, err = sqlite.LoadTimeAs(stmt, "wrapped", tc.enc)
+			assert.NoError(t, err)
+			assert.True(t, tc.want.Equal(got), "want %s, got %s", tc.want, got)
+		})
+	}
+}
+
+func TestTimeEncodingRFC3339DateFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	at := time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	stmt, err := conn.Prepare(ctx, `SELECT strftime('%Y-%m-%d', ?) AS day;`, sqlite.Time(at, sqlite.TimeRFC3339))
+	assert.NoError(t, err)
+	defer stmt.Reset()
+
+	hasRow, err := stmt.Step()
+	assert.NoError(t, err)
+	assert.True(t, hasRow)
+	assert.Equal(t, "2024-03-14", stmt.GetText("day"))
+}
+
+func TestTimeRangeCond(t *testing.T) {
+	ctx := context.Background()
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	clause, args := sqlite.TimeRangeCond("created_at", from, to)
+	assert.Equal(t, "created_at >= ? AND created_at < ?", clause)
+	assert.Equal(t, []any{from.Unix(), to.Unix()}, args)
+
+	// the zone doesn't matter, only the instant
+	_, args = sqlite.TimeRangeCond("created_at", from.In(time.FixedZone("EST", -5*3600)), to)
+	assert.Equal(t, []any{from.Unix(), to.Unix()}, args)
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.ExecScript(`CREATE TABLE range_events (name TEXT, created_at INTEGER);`)
+	assert.NoError(t, err)
+
+	for name, at := range map[string]time.Time{
+		"before": from.Add(-time.Second),
+		"start":  from,
+		"middle": from.AddDate(0, 0, 14),
+		"end":    to,
+	} {
+		err = conn.Exec(ctx, `INSERT INTO range_events (name, created_at) VALUES (?, ?);`, name, at)
+		assert.NoError(t, err)
+	}
+
+	var names []string
+	for stmt, err := range conn.Query(ctx, `SELECT name FROM range_events WHERE `+clause+` ORDER BY created_at;`, args...) {
+		assert.NoError(t, err)
+		names = append(names, stmt.GetText("name"))
+	}
+	assert.Equal(t, []string{"start", "middle"}, names)
+}
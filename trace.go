@@ -0,0 +1,89 @@
+package sqlite
+
+import (
+	"context"
+	"strings"
+)
+
+// Tracer starts spans around queries, see WithTracer. It mirrors the Start
+// method of OpenTelemetry's trace.Tracer without the options, so an adapter
+// is a few lines:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, sqlite.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is the part of a tracing span the package uses
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+}
+
+// WithTracer wraps every Conn.Exec, Conn.ExecMany, Conn.Query and QueryRow
+// call in a span named after the statement's operation and table, e.g.
+// "SELECT users". Spans carry the statement rendered with ShowSql as
+// db.statement, the number of rows affected or returned and the error if
+// any. Without a tracer no span is created.
+func WithTracer(t Tracer) OptionFunc {
+	return func(ctx context.Context, db *Database) error {
+		db.tracer = t
+		return nil
+	}
+}
+
+// spanName returns the operation of sql followed by the table it works on
+// when it's easy to tell, e.g. "INSERT users", and never any values
+func spanName(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "sqlite"
+	}
+
+	op := strings.ToUpper(strings.TrimRight(fields[0], ";"))
+
+	var after string
+	switch op {
+	case "SELECT", "DELETE":
+		after = "FROM"
+	case "INSERT", "REPLACE":
+		after = "INTO"
+	case "UPDATE":
+		if len(fields) > 1 {
+			return op + " " + spanTable(fields[1])
+		}
+		return op
+	default:
+		return op
+	}
+
+	for i := 1; i < len(fields)-1; i++ {
+		if strings.EqualFold(fields[i], after) {
+			if table := spanTable(fields[i+1]); table != "" {
+				return op + " " + table
+			}
+			break
+		}
+	}
+
+	return op
+}
+
+// spanTable cleans up a table name token, anything that isn't a plain
+// identifier (a subquery, a placeholder) is dropped
+func spanTable(token string) string {
+	token = strings.Trim(token, "();,")
+	if i := strings.IndexByte(token, '('); i >= 0 {
+		token = token[:i]
+	}
+	if !isIdentifier(token) {
+		return ""
+	}
+	return token
+}
@@ -0,0 +1,89 @@
+package sqlite_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"ella.to/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordedSpan) RecordError(err error)              { s.err = err }
+func (s *recordedSpan) End()                               { s.ended = true }
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, sqlite.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	span := &recordedSpan{name: name, attrs: map[string]any{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestWithTracer(t *testing.T) {
+	ctx := context.Background()
+	tracer := &recordingTracer{}
+
+	db, err := sqlite.New(ctx, sqlite.WithMemory(), sqlite.WithPoolSize(1), sqlite.WithTracer(tracer))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Done()
+
+	err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS traced_users (name TEXT);`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `DELETE FROM traced_users;`)
+	assert.NoError(t, err)
+
+	err = conn.Exec(ctx, `INSERT INTO traced_users (name) VALUES (?), (?);`, "john", "jane")
+	assert.NoError(t, err)
+
+	for _, err := range conn.Query(ctx, `SELECT name FROM traced_users WHERE name != ?;`, "nobody") {
+		assert.NoError(t, err)
+	}
+
+	err = conn.Exec(ctx, `UPDATE missing_table SET name = ?;`, "joe")
+	assert.Error(t, err)
+
+	assert.Len(t, tracer.spans, 5)
+	for _, span := range tracer.spans {
+		assert.True(t, span.ended)
+		assert.Equal(t, "sqlite", span.attrs["db.system"])
+	}
+
+	assert.Equal(t, "CREATE", tracer.spans[0].name)
+	assert.Equal(t, "DELETE traced_users", tracer.spans[1].name)
+
+	insert := tracer.spans[2]
+	assert.Equal(t, "INSERT traced_users", insert.name)
+	assert.Equal(t, "INSERT INTO traced_users (name) VALUES ('john'), ('jane');", insert.attrs["db.statement"])
+	assert.Equal(t, int64(2), insert.attrs["db.rows_affected"])
+	assert.NoError(t, insert.err)
+
+	query := tracer.spans[3]
+	assert.Equal(t, "SELECT traced_users", query.name)
+	assert.Equal(t, int64(2), query.attrs["db.rows_returned"])
+
+	failed := tracer.spans[4]
+	assert.Equal(t, "UPDATE missing_table", failed.name)
+	assert.ErrorIs(t, failed.err, sqlite.ErrPrepareSQL)
+}